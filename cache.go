@@ -0,0 +1,31 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/config"
+	"github.com/ChrisTheShark/golang-mysql-api/controllers"
+	"github.com/ChrisTheShark/golang-mysql-api/logging"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+	"github.com/ChrisTheShark/golang-mysql-api/repository/cache"
+)
+
+// withCache wraps the repository in a cache.CachingUserRepository when the
+// cache is given a size, serving its statistics at /admin/cache
+func withCache(users repository.UserRepository, r routes, cfg config.Cache) (repository.UserRepository, error) {
+	if cfg.Size == 0 {
+		return users, nil
+	}
+	cached, err := cache.NewCachingUserRepository(users, cache.Config{
+		Size:        cfg.Size,
+		TTL:         time.Duration(cfg.TTL),
+		NegativeTTL: time.Duration(cfg.NegativeTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	cc := controllers.NewCacheController(cached)
+	r.GET("/admin/cache", cc.GetCacheStats)
+	logging.Infof("caching up to %d users for %s, statistics are at /admin/cache", cfg.Size, cfg.TTL)
+	return cached, nil
+}
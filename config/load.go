@@ -0,0 +1,202 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// FileEnv names the environment variable naming the configuration file,
+// when the -config flag does not
+const FileEnv = "CONFIG_FILE"
+
+// setting is a single value of a Config, named key as a flag and in the
+// messages reporting it
+type setting struct {
+	key    string
+	env    string
+	usage  string
+	secret bool
+	field  reflect.Value
+}
+
+// settingsOf lists the settings held by the struct v points to, depth first
+func settingsOf(v interface{}) []setting {
+	var settings []setting
+	var walk func(rv reflect.Value, prefix string)
+	walk = func(rv reflect.Value, prefix string) {
+		rt := rv.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			field := rt.Field(i)
+			key := prefix + strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if field.Type.Kind() == reflect.Struct {
+				walk(rv.Field(i), key+".")
+				continue
+			}
+			settings = append(settings, setting{
+				key:    key,
+				env:    field.Tag.Get("env"),
+				usage:  field.Tag.Get("usage"),
+				secret: field.Tag.Get("secret") == "true",
+				field:  rv.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(v).Elem(), "")
+	return settings
+}
+
+// parse parses s as a value of the type of the setting
+func (s setting) parse(raw string) (reflect.Value, error) {
+	v := reflect.New(s.field.Type())
+	if setter, ok := v.Interface().(interface{ Set(string) error }); ok {
+		return v.Elem(), setter.Set(raw)
+	}
+	switch s.field.Kind() {
+	case reflect.String:
+		v.Elem().SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return v.Elem(), fmt.Errorf("%q is not a whole number", raw)
+		}
+		v.Elem().SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return v.Elem(), fmt.Errorf("%q is not true or false", raw)
+		}
+		v.Elem().SetBool(b)
+	default:
+		panic(fmt.Sprintf("unsupported type %s of setting %s", s.field.Type(), s.key))
+	}
+	return v.Elem(), nil
+}
+
+// flagValue holds the value of a setting given as a flag until the sources
+// of lower precedence have been applied
+type flagValue struct {
+	setting
+	value reflect.Value
+	def   string
+}
+
+func (f *flagValue) String() string {
+	return f.def
+}
+
+func (f *flagValue) Set(raw string) error {
+	v, err := f.parse(raw)
+	if err != nil {
+		return err
+	}
+	f.value = v
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.field.Kind() == reflect.Bool
+}
+
+// Load builds the configuration from its defaults, the file named by the
+// -config flag or CONFIG_FILE, the environment variables read with getenv
+// and the flags at the start of args, each overriding the last, and
+// validates it. An environment variable set to the empty string is treated
+// as unset. It returns the arguments following the flags.
+func Load(args []string, getenv func(string) string, usage io.Writer) (Config, []string, error) {
+	cfg := Default()
+	settings := settingsOf(&cfg)
+
+	fs := flag.NewFlagSet("golang-mysql-api", flag.ContinueOnError)
+	fs.SetOutput(usage)
+	fs.Usage = func() {
+		fmt.Fprintln(usage, "usage: golang-mysql-api [FLAG]... [migrate|purge|export|import|config] [ARG]...")
+		fs.PrintDefaults()
+	}
+	file := fs.String("config", getenv(FileEnv), "YAML or JSON file to read the configuration from ("+FileEnv+")")
+	flags := make([]*flagValue, len(settings))
+	for i, s := range settings {
+		flags[i] = &flagValue{setting: s, def: fmt.Sprint(s.field.Interface())}
+		fs.Var(flags[i], s.key, fmt.Sprintf("%s (%s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	if *file != "" {
+		if err := readFile(*file, &cfg); err != nil {
+			return cfg, nil, err
+		}
+	}
+	for _, s := range settings {
+		raw := getenv(s.env)
+		if raw == "" {
+			continue
+		}
+		v, err := s.parse(raw)
+		if err != nil {
+			return cfg, nil, fmt.Errorf("invalid %s: %v", s.env, err)
+		}
+		s.field.Set(v)
+	}
+	for _, f := range flags {
+		if f.value.IsValid() {
+			f.field.Set(f.value)
+		}
+	}
+	return cfg, fs.Args(), cfg.Validate()
+}
+
+// readFile decodes the configuration file at path over cfg, as JSON when its
+// extension is .json and as YAML otherwise. Unknown settings are rejected so
+// that a misspelt one is not silently ignored.
+func readFile(path string, cfg *Config) error {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read the configuration due to: %v", err)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(bs))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(cfg)
+	} else {
+		err = yaml.UnmarshalStrict(bs, cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid configuration file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Patterns locating the password of a URL or mysql DSN, and of a key=value
+// postgres connection string
+var (
+	userinfoPassword = regexp.MustCompile(`^((?:[A-Za-z][A-Za-z0-9+.-]*://)?[^:@/]*):.*@`)
+	passwordParam    = regexp.MustCompile(`(?i)(password=)[^\s&]+`)
+)
+
+// redact replaces the passwords held by a connection string
+func redact(s string) string {
+	s = userinfoPassword.ReplaceAllString(s, "${1}:xxxxx@")
+	return passwordParam.ReplaceAllString(s, "${1}xxxxx")
+}
+
+// Redacted returns a copy of the configuration whose secrets are redacted
+func (c Config) Redacted() Config {
+	for _, s := range settingsOf(&c) {
+		if s.secret {
+			s.field.SetString(redact(s.field.String()))
+		}
+	}
+	return c
+}
@@ -0,0 +1,374 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/ChrisTheShark/golang-mysql-api/logging"
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+)
+
+// MaxBatchSize bounds the number of items in a single batch request
+const MaxBatchSize = 10000
+
+// errBatchTooLarge refuses a batch holding more than MaxBatchSize items
+var errBatchTooLarge = requestError{http.StatusRequestEntityTooLarge, fmt.Sprintf("the batch must contain at most %d items", MaxBatchSize)}
+
+// BatchPath addresses the batch endpoints. httprouter cannot route a path
+// sharing its first segment with /users/:id, so BatchRoutes serves it ahead
+// of the router.
+const BatchPath = "/users:batch"
+
+// batchResult reports the outcome of a single item of a batch request. ID
+// names the user the item created, updated or deleted; Detail and Errors
+// describe why it failed, as in a problem document.
+type batchResult struct {
+	Index  int               `json:"index"`
+	Status int               `json:"status"`
+	ID     string            `json:"id,omitempty"`
+	Detail string            `json:"detail,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// itemError attributes the failure of an all-or-nothing batch to one of its
+// items
+type itemError struct {
+	index int
+	err   error
+}
+
+func (e itemError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.index, e.err)
+}
+
+func (e itemError) Unwrap() error {
+	return e.err
+}
+
+// BatchRoutes serves the batch endpoints at BatchPath, passing every other
+// request on to next
+func (u UserController) BatchRoutes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != BatchPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		setRoute(r.Context(), BatchPath)
+		defer func() {
+			if v := recover(); v != nil {
+				Panic(w, r, v)
+			}
+		}()
+		switch r.Method {
+		case http.MethodPost:
+			u.CreateUsers(w, r)
+		case http.MethodPatch:
+			u.UpdateUsers(w, r)
+		case http.MethodDelete:
+			u.DeleteUsers(w, r)
+		default:
+			w.Header().Set("Allow", "DELETE, PATCH, POST")
+			MethodNotAllowed(w, r)
+		}
+	})
+}
+
+// CreateUsers create a batch of json encoded users with multi-row inserts.
+// Unless atomic=false, either every user is created or none is.
+func (u UserController) CreateUsers(w http.ResponseWriter, r *http.Request) {
+	atomic, items, ok := batchRequest(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := u.context(r)
+	defer cancel()
+
+	results := make([]batchResult, len(items))
+	var users []models.User
+	var indexes []int
+	for i, item := range items {
+		user, err := decodeUser(item)
+		if err != nil {
+			if atomic {
+				batchError(ctx, w, r, itemError{i, err})
+				return
+			}
+			results[i] = failedItem(ctx, i, err)
+			continue
+		}
+		users = append(users, user)
+		indexes = append(indexes, i)
+	}
+
+	ids, err := u.userRepository.CreateMany(ctx, users)
+	switch {
+	case err == nil:
+		for j, id := range ids {
+			results[indexes[j]] = batchResult{Index: indexes[j], Status: http.StatusCreated, ID: id}
+		}
+	case atomic:
+		repositoryError(ctx, w, r, err)
+		return
+	case errors.Is(err, models.ErrConflict) || errors.Is(err, models.ErrValidation):
+		// The database refused one of the users; create them one at a time
+		// to learn which.
+		for j, user := range users {
+			i := indexes[j]
+			id, err := u.userRepository.Create(ctx, user)
+			if err != nil {
+				results[i] = failedItem(ctx, i, err)
+				continue
+			}
+			results[i] = batchResult{Index: i, Status: http.StatusCreated, ID: id}
+		}
+	default:
+		for _, i := range indexes {
+			results[i] = failedItem(ctx, i, err)
+		}
+	}
+
+	if atomic {
+		writeBatch(w, http.StatusCreated, results)
+		return
+	}
+	writeBatch(w, http.StatusMultiStatus, results)
+}
+
+// UpdateUsers apply a batch of JSON Merge Patch documents, each naming the
+// id of the user it patches. Unless atomic=false, either every user is
+// updated or none is.
+func (u UserController) UpdateUsers(w http.ResponseWriter, r *http.Request) {
+	atomic, items, ok := batchRequest(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := u.context(r)
+	defer cancel()
+
+	u.runBatch(ctx, w, r, atomic, items, http.StatusOK, func(tx repository.UserRepository, item json.RawMessage) (string, error) {
+		id, err := itemID(item)
+		if err != nil {
+			return "", err
+		}
+		user, err := tx.GetByID(ctx, id)
+		if err != nil {
+			return id, err
+		}
+		_, err = applyPatch(ctx, tx, *user, item)
+		return id, err
+	})
+}
+
+// DeleteUsers soft delete a batch of users, each named by an object holding
+// its id. Unless atomic=false, either every user is deleted or none is.
+func (u UserController) DeleteUsers(w http.ResponseWriter, r *http.Request) {
+	atomic, items, ok := batchRequest(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := u.context(r)
+	defer cancel()
+
+	u.runBatch(ctx, w, r, atomic, items, http.StatusNoContent, func(tx repository.UserRepository, item json.RawMessage) (string, error) {
+		id, err := itemID(item)
+		if err != nil {
+			return "", err
+		}
+		user, err := tx.GetByID(ctx, id)
+		if err != nil {
+			return id, err
+		}
+		return id, tx.Delete(ctx, *user)
+	})
+}
+
+// runBatch applies fn to each item of a batch, reporting status for each
+// item it succeeds on. An atomic batch runs in a single transaction and
+// fails as a whole with its first failing item; otherwise each item runs in
+// a transaction of its own and the response is 207 Multi-Status.
+func (u UserController) runBatch(ctx context.Context, w http.ResponseWriter, r *http.Request, atomic bool, items []json.RawMessage, status int,
+	fn func(repository.UserRepository, json.RawMessage) (string, error)) {
+	results := make([]batchResult, len(items))
+	if atomic {
+		err := u.userRepository.WithTx(ctx, func(tx repository.UserRepository) error {
+			for i, item := range items {
+				id, err := fn(tx, item)
+				if err != nil {
+					return itemError{i, err}
+				}
+				results[i] = batchResult{Index: i, Status: status, ID: id}
+			}
+			return nil
+		})
+		if err != nil {
+			batchError(ctx, w, r, err)
+			return
+		}
+		writeBatch(w, http.StatusOK, results)
+		return
+	}
+
+	for i, item := range items {
+		var id string
+		err := u.userRepository.WithTx(ctx, func(tx repository.UserRepository) error {
+			var err error
+			id, err = fn(tx, item)
+			return err
+		})
+		if err != nil {
+			results[i] = failedItem(ctx, i, err)
+			results[i].ID = id
+			continue
+		}
+		results[i] = batchResult{Index: i, Status: status, ID: id}
+	}
+	writeBatch(w, http.StatusMultiStatus, results)
+}
+
+// batchRequest parses the mode and items of a batch request, answering with
+// a problem document when it cannot
+func batchRequest(w http.ResponseWriter, r *http.Request) (atomic bool, items []json.RawMessage, ok bool) {
+	atomic, err := atomicBatch(r.URL.Query())
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return false, nil, false
+	}
+	items, err = decodeBatch(r)
+	if err != nil {
+		repositoryError(r.Context(), w, r, err)
+		return false, nil, false
+	}
+	return atomic, items, true
+}
+
+// atomicBatch parses the atomic query parameter. Batches are all-or-nothing
+// unless it is false.
+func atomicBatch(q url.Values) (bool, error) {
+	v := q.Get("atomic")
+	if v == "" {
+		return true, nil
+	}
+	atomic, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.New("atomic must be true or false")
+	}
+	return atomic, nil
+}
+
+// decodeBatch reads the items of a batch request, sent either as a JSON
+// array or as newline delimited JSON. Items are read one at a time so a
+// batch over MaxBatchSize is refused without reading the rest of it.
+func decodeBatch(r *http.Request) ([]json.RawMessage, error) {
+	var items []json.RawMessage
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	dec := json.NewDecoder(r.Body)
+	switch mediaType {
+	case "", "application/json":
+		notArray := requestError{http.StatusBadRequest, "the request body must be a JSON array of items"}
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+			return nil, notArray
+		}
+		for dec.More() {
+			if len(items) == MaxBatchSize {
+				return nil, errBatchTooLarge
+			}
+			var item json.RawMessage
+			if err := dec.Decode(&item); err != nil {
+				return nil, notArray
+			}
+			items = append(items, item)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, notArray
+		}
+	case "application/x-ndjson", "application/ndjson":
+		for {
+			var item json.RawMessage
+			err := dec.Decode(&item)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, requestError{http.StatusBadRequest, fmt.Sprintf("item %d is not valid JSON", len(items))}
+			}
+			if len(items) == MaxBatchSize {
+				return nil, errBatchTooLarge
+			}
+			items = append(items, item)
+		}
+	default:
+		return nil, requestError{http.StatusUnsupportedMediaType, "the request body must be application/json or application/x-ndjson"}
+	}
+
+	if len(items) == 0 {
+		return nil, requestError{http.StatusBadRequest, "the batch must contain at least one item"}
+	}
+	return items, nil
+}
+
+// decodeUser reads a json encoded user from a batch item
+func decodeUser(item json.RawMessage) (models.User, error) {
+	var user models.User
+	if err := json.Unmarshal(item, &user); err != nil || user.IsEmpty() {
+		return user, requestError{http.StatusBadRequest, "the item must be a JSON encoded user"}
+	}
+	if err := user.Validate(); err != nil {
+		return user, err
+	}
+	user.DeletedAt = nil
+	return user, nil
+}
+
+// itemID reads the id naming the user a batch item applies to
+func itemID(item json.RawMessage) (string, error) {
+	var ref struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(item, &ref); err != nil || ref.ID == "" {
+		return "", requestError{http.StatusBadRequest, "the item must be a JSON object naming the id of a user"}
+	}
+	return ref.ID, nil
+}
+
+// failedItem reports an item of a batch that failed with err
+func failedItem(ctx context.Context, index int, err error) batchResult {
+	p := problemFor(ctx, err)
+	if p.Status >= http.StatusInternalServerError {
+		logging.Errorf("%v", err)
+	}
+	return batchResult{Index: index, Status: p.Status, Detail: p.Detail, Errors: p.Errors}
+}
+
+// batchError answers a failed all-or-nothing batch, naming the item that
+// failed it when there is one
+func batchError(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) {
+	var item itemError
+	if !errors.As(err, &item) {
+		repositoryError(ctx, w, r, err)
+		return
+	}
+	p := problemFor(ctx, item.err)
+	if p.Status >= http.StatusInternalServerError {
+		logging.Errorf("%v", err)
+	}
+	p.Detail = fmt.Sprintf("item %d: %s", item.index, p.Detail)
+	writeProblemDocument(w, r, p)
+}
+
+// writeBatch answers a batch request with the result of each of its items
+func writeBatch(w http.ResponseWriter, status int, results []batchResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(results)
+}
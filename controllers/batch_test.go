@@ -0,0 +1,281 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	mocks "github.com/ChrisTheShark/golang-mysql-api/repository/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveBatch sends a batch request through BatchRoutes
+func serveBatch(uc *UserController, method, target, contentType, body string) *http.Response {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	uc.BatchRoutes(http.NotFoundHandler()).ServeHTTP(w, r)
+	return w.Result()
+}
+
+func decodeResults(t *testing.T, resp *http.Response) []batchResult {
+	t.Helper()
+	defer resp.Body.Close()
+	var results []batchResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatalf("unable to decode batch results due to: %v", err)
+	}
+	return results
+}
+
+func TestCreateUsers(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	uc := NewUserController(ur)
+
+	resp := serveBatch(uc, http.MethodPost, BatchPath, "application/json",
+		`[{"name":"Felix Leiter","gender":"male","age":40},{"name":"Vesper Lynd","gender":"female","age":30}]`)
+
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, []batchResult{
+		{Index: 0, Status: http.StatusCreated, ID: "2"},
+		{Index: 1, Status: http.StatusCreated, ID: "3"},
+	}, decodeResults(t, resp))
+	assert.Len(t, ur.CallsTo("CreateMany"), 1)
+	user, err := ur.GetByID(context.Background(), "3")
+	assert.Nil(t, err)
+	assert.Equal(t, "Vesper Lynd", user.Name)
+}
+
+func TestCreateUsersNDJSONPerItem(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	uc := NewUserController(ur)
+
+	resp := serveBatch(uc, http.MethodPost, BatchPath+"?atomic=false", "application/x-ndjson",
+		"{\"name\":\"Felix Leiter\",\"gender\":\"male\",\"age\":40}\n"+
+			"{\"name\":\"Q\",\"gender\":\"male\",\"age\":-1}\n"+
+			"\"James Bond\"\n")
+
+	results := decodeResults(t, resp)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	if assert.Len(t, results, 3) {
+		assert.Equal(t, batchResult{Index: 0, Status: http.StatusCreated, ID: "2"}, results[0])
+		assert.Equal(t, http.StatusUnprocessableEntity, results[1].Status)
+		assert.Contains(t, results[1].Errors, "age")
+		assert.Equal(t, http.StatusBadRequest, results[2].Status)
+	}
+	users, _ := ur.GetAll(context.Background())
+	assert.Len(t, users, 2)
+}
+
+func TestCreateUsersAtomicFailure(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	uc := NewUserController(ur)
+
+	resp := serveBatch(uc, http.MethodPost, BatchPath, "",
+		`[{"name":"Felix Leiter","gender":"male","age":40},{"name":"Q","gender":"male","age":-1}]`)
+
+	var problem Problem
+	json.NewDecoder(resp.Body).Decode(&problem)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, "item 1: the user was rejected as invalid", problem.Detail)
+	assert.Contains(t, problem.Errors, "age")
+	assert.Empty(t, ur.CallsTo("CreateMany"))
+}
+
+// conflictingRepository refuses the multi-row insert of any batch holding a
+// user named Q, as a database enforcing a unique name would
+type conflictingRepository struct {
+	*mocks.MockUserRepository
+}
+
+func (r conflictingRepository) CreateMany(ctx context.Context, users []models.User) ([]string, error) {
+	for _, user := range users {
+		if user.Name == "Q" {
+			return nil, models.ConflictError{Message: "unable to create users"}
+		}
+	}
+	return r.MockUserRepository.CreateMany(ctx, users)
+}
+
+func (r conflictingRepository) Create(ctx context.Context, user models.User) (string, error) {
+	if user.Name == "Q" {
+		return "", models.ConflictError{Message: "unable to create user"}
+	}
+	return r.MockUserRepository.Create(ctx, user)
+}
+
+func TestCreateUsersPerItemConflict(t *testing.T) {
+	t.Parallel()
+
+	ur := conflictingRepository{mocks.NewMockUserRepository()}
+	uc := NewUserController(ur)
+	body := `[{"name":"Felix Leiter","gender":"male","age":40},{"name":"Q","gender":"male","age":30}]`
+
+	resp := serveBatch(uc, http.MethodPost, BatchPath+"?atomic=false", "application/json", body)
+
+	results := decodeResults(t, resp)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, batchResult{Index: 0, Status: http.StatusCreated, ID: "2"}, results[0])
+		assert.Equal(t, http.StatusConflict, results[1].Status)
+	}
+
+	resp = serveBatch(uc, http.MethodPost, BatchPath, "application/json", body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+}
+
+func TestUpdateUsers(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	ur.Seed(models.User{Name: "Felix Leiter", Gender: "male", Age: 40})
+	uc := NewUserController(ur)
+
+	resp := serveBatch(uc, http.MethodPatch, BatchPath, "application/json", `[{"id":"1","age":45},{"id":"2","age":41}]`)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []batchResult{
+		{Index: 0, Status: http.StatusOK, ID: "1"},
+		{Index: 1, Status: http.StatusOK, ID: "2"},
+	}, decodeResults(t, resp))
+	user, _ := ur.GetByID(context.Background(), "2")
+	assert.Equal(t, 41, user.Age)
+	assert.Equal(t, "Felix Leiter", user.Name)
+}
+
+func TestUpdateUsersAtomicFailure(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	uc := NewUserController(ur)
+
+	resp := serveBatch(uc, http.MethodPatch, BatchPath, "application/json", `[{"id":"1","age":45},{"id":"99","age":41}]`)
+
+	var problem Problem
+	json.NewDecoder(resp.Body).Decode(&problem)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "item 1: the requested user does not exist", problem.Detail)
+	user, _ := ur.GetByID(context.Background(), "1")
+	assert.Equal(t, 44, user.Age)
+}
+
+func TestUpdateUsersPerItem(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	uc := NewUserController(ur)
+
+	resp := serveBatch(uc, http.MethodPatch, BatchPath+"?atomic=false", "application/json",
+		`[{"id":"1","age":45},{"id":"99","age":41},{"age":41},{"id":"1","id":"2"}]`)
+
+	results := decodeResults(t, resp)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	if assert.Len(t, results, 4) {
+		assert.Equal(t, batchResult{Index: 0, Status: http.StatusOK, ID: "1"}, results[0])
+		assert.Equal(t, http.StatusNotFound, results[1].Status)
+		assert.Equal(t, "99", results[1].ID)
+		assert.Equal(t, http.StatusBadRequest, results[2].Status)
+		assert.Equal(t, http.StatusNotFound, results[3].Status)
+	}
+	user, _ := ur.GetByID(context.Background(), "1")
+	assert.Equal(t, 45, user.Age)
+}
+
+func TestDeleteUsers(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	ur.Seed(models.User{Name: "Felix Leiter", Gender: "male", Age: 40})
+	uc := NewUserController(ur)
+
+	resp := serveBatch(uc, http.MethodDelete, BatchPath, "application/x-ndjson", "{\"id\":\"1\"}\n{\"id\":\"2\"}\n")
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []batchResult{
+		{Index: 0, Status: http.StatusNoContent, ID: "1"},
+		{Index: 1, Status: http.StatusNoContent, ID: "2"},
+	}, decodeResults(t, resp))
+	users, _ := ur.GetAll(context.Background())
+	assert.Empty(t, users)
+}
+
+func TestDeleteUsersPerItem(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	uc := NewUserController(ur)
+
+	resp := serveBatch(uc, http.MethodDelete, BatchPath+"?atomic=false", "application/json", `[{"id":"99"},{"id":"1"}]`)
+
+	results := decodeResults(t, resp)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, http.StatusNotFound, results[0].Status)
+		assert.Equal(t, batchResult{Index: 1, Status: http.StatusNoContent, ID: "1"}, results[1])
+	}
+}
+
+func TestBatchUnavailable(t *testing.T) {
+	t.Parallel()
+
+	uc := NewUserController(mocks.NewMockErroringUserRepository())
+
+	resp := serveBatch(uc, http.MethodPost, BatchPath+"?atomic=false", "application/json", `[{"name":"Q","gender":"male","age":30}]`)
+
+	results := decodeResults(t, resp)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Equal(t, []batchResult{{Index: 0, Status: http.StatusServiceUnavailable, Detail: "the database is unavailable"}}, results)
+
+	resp = serveBatch(uc, http.MethodDelete, BatchPath, "application/json", `[{"id":"1"}]`)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestBatchBadRequests(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		method      string
+		target      string
+		contentType string
+		body        string
+		expected    int
+	}{
+		"method":                   {http.MethodGet, BatchPath, "", "", http.StatusMethodNotAllowed},
+		"atomic":                   {http.MethodPost, BatchPath + "?atomic=maybe", "", "[{}]", http.StatusBadRequest},
+		"media type":               {http.MethodPost, BatchPath, "text/csv", "name\nQ", http.StatusUnsupportedMediaType},
+		"not an array":             {http.MethodPost, BatchPath, "application/json", `{"name":"Q"}`, http.StatusBadRequest},
+		"bad ndjson":               {http.MethodDelete, BatchPath, "application/x-ndjson", "{\"id\":\"1\"}\n{", http.StatusBadRequest},
+		"empty":                    {http.MethodPatch, BatchPath, "application/json", "[]", http.StatusBadRequest},
+		"too large":                {http.MethodDelete, BatchPath, "application/x-ndjson", strings.Repeat("{}\n", MaxBatchSize+1), http.StatusRequestEntityTooLarge},
+		"too large, unread ndjson": {http.MethodDelete, BatchPath, "application/x-ndjson", strings.Repeat("{}\n", MaxBatchSize+1) + "{", http.StatusRequestEntityTooLarge},
+		"too large array":          {http.MethodPost, BatchPath, "application/json", "[" + strings.Repeat("{},", MaxBatchSize) + "{}]", http.StatusRequestEntityTooLarge},
+		"too large, unread array":  {http.MethodPost, BatchPath, "application/json", "[" + strings.Repeat("{},", MaxBatchSize+1) + "{", http.StatusRequestEntityTooLarge},
+		"unterminated array":       {http.MethodPost, BatchPath, "application/json", `[{"name":"Q"}`, http.StatusBadRequest},
+		"other route":              {http.MethodPost, "/users", "application/json", "[]", http.StatusNotFound},
+	}
+	for name, tt := range tests {
+		uc := NewUserController(mocks.NewMockUserRepository())
+
+		resp := serveBatch(uc, tt.method, tt.target, tt.contentType, tt.body)
+		resp.Body.Close()
+
+		assert.Equal(t, tt.expected, resp.StatusCode, name)
+		if tt.expected == http.StatusMethodNotAllowed {
+			assert.Equal(t, "DELETE, PATCH, POST", resp.Header.Get("Allow"))
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ChrisTheShark/golang-mysql-api/repository/cache"
+	"github.com/julienschmidt/httprouter"
+)
+
+// CacheController struct containing web related logic to inspect the cache
+// of a cache.CachingUserRepository. It is only routed when the cache is
+// enabled.
+type CacheController struct {
+	cache *cache.CachingUserRepository
+}
+
+// NewCacheController is a convenience function to create a CacheController
+func NewCacheController(r *cache.CachingUserRepository) *CacheController {
+	return &CacheController{r}
+}
+
+// GetCacheStats retrieve the hit, miss, eviction and invalidation counters
+// of the cache
+func (c CacheController) GetCacheStats(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.cache.Stats())
+}
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/repository/cache"
+	mocks "github.com/ChrisTheShark/golang-mysql-api/repository/mocks"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+func newCachingUserRepository(t *testing.T) *cache.CachingUserRepository {
+	r, err := cache.NewCachingUserRepository(mocks.NewMockUserRepository(), cache.Config{Size: 10, TTL: time.Minute})
+	if err != nil {
+		t.Fatalf("unable to create CachingUserRepository due to: %v", err)
+	}
+	return r
+}
+
+func TestGetCacheStats(t *testing.T) {
+	t.Parallel()
+
+	users := newCachingUserRepository(t)
+	uc := NewUserController(users)
+	getUser(uc, "1").Body.Close()
+	getUser(uc, "1").Body.Close()
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/cache", nil)
+	w := httptest.NewRecorder()
+	NewCacheController(users).GetCacheStats(w, r, httprouter.Params{})
+
+	var stats cache.Stats
+	json.NewDecoder(w.Body).Decode(&stats)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, cache.Stats{Hits: 1, Misses: 1, Entries: 1, Size: 10}, stats)
+}
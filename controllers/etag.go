@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+)
+
+// etag formats the version of a user as a strong entity tag
+func etag(user models.User) string {
+	return `"` + strconv.Itoa(user.Version) + `"`
+}
+
+// entityTags splits a comma separated If-Match or If-None-Match header into
+// its entity tags. The tags of this API never contain commas.
+func entityTags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// checkIfMatch reports a version mismatch unless the If-Match header of the
+// request is absent, is "*" or names the current version of the user. As
+// RFC 7232 requires, weak tags never match.
+func checkIfMatch(r *http.Request, user models.User) error {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+	for _, tag := range entityTags(header) {
+		if tag == "*" || tag == etag(user) {
+			return nil
+		}
+	}
+	return models.VersionMismatchError{Message: "If-Match does not name the current version"}
+}
+
+// notModified reports whether the If-None-Match header of the request names
+// the current version of the user, using the weak comparison RFC 7232
+// prescribes for GET
+func notModified(r *http.Request, user models.User) bool {
+	for _, tag := range entityTags(r.Header.Get("If-None-Match")) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag(user) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckIfMatch(t *testing.T) {
+	t.Parallel()
+
+	user := models.User{ID: "1", Version: 3}
+	tests := map[string]bool{
+		``:              true,
+		`*`:             true,
+		`"3"`:           true,
+		`"1", "3"`:      true,
+		`"2"`:           false,
+		`W/"3"`:         false,
+		`3`:             false,
+		`"2", W/"3", "`: false,
+	}
+	for header, matches := range tests {
+		r := httptest.NewRequest(http.MethodPut, "/users/1", nil)
+		r.Header.Set("If-Match", header)
+
+		err := checkIfMatch(r, user)
+
+		if matches {
+			assert.Nil(t, err, header)
+		} else {
+			assert.True(t, errors.Is(err, models.ErrVersionMismatch), header)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	t.Parallel()
+
+	user := models.User{ID: "1", Version: 3}
+	tests := map[string]bool{
+		``:         false,
+		`*`:        true,
+		`"3"`:      true,
+		`W/"3"`:    true,
+		`"1", "3"`: true,
+		`"2"`:      false,
+		`"2", "4"`: false,
+	}
+	for header, matches := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+		r.Header.Set("If-None-Match", header)
+
+		assert.Equal(t, matches, notModified(r, user), header)
+	}
+}
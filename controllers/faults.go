@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ChrisTheShark/golang-mysql-api/repository/chaos"
+	"github.com/julienschmidt/httprouter"
+)
+
+// FaultController struct containing web related logic to configure the
+// faults injected by a chaos.FaultyUserRepository. It is only routed in
+// builds made with the chaos tag.
+type FaultController struct {
+	faults *chaos.FaultyUserRepository
+}
+
+// NewFaultController is a convenience function to create a FaultController
+func NewFaultController(r *chaos.FaultyUserRepository) *FaultController {
+	return &FaultController{r}
+}
+
+// GetFaults retrieve the fault injection configuration
+func (f FaultController) GetFaults(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f.faults.Config())
+}
+
+// SetFaults replace the fault injection configuration with a json encoded
+// chaos.Config
+func (f FaultController) SetFaults(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var config chaos.Config
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "the request body must be a JSON encoded fault configuration")
+		return
+	}
+	if err := f.faults.Configure(config); err != nil {
+		writeProblem(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f.faults.Config())
+}
+
+// ClearFaults stop injecting faults
+func (f FaultController) ClearFaults(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	f.faults.Configure(chaos.Config{})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/repository/chaos"
+	mocks "github.com/ChrisTheShark/golang-mysql-api/repository/mocks"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFaultyUserRepository(t *testing.T, config chaos.Config) *chaos.FaultyUserRepository {
+	r, err := chaos.NewFaultyUserRepository(mocks.NewMockUserRepository(), config)
+	if err != nil {
+		t.Fatalf("unable to create FaultyUserRepository due to: %v", err)
+	}
+	return r
+}
+
+func getUser(uc *UserController, id string) *http.Response {
+	r := httptest.NewRequest(http.MethodGet, "/users/"+id, nil)
+	w := httptest.NewRecorder()
+	uc.GetUserByID(w, r, httprouter.Params{{Key: "id", Value: id}})
+	return w.Result()
+}
+
+func TestSetFaults(t *testing.T) {
+	t.Parallel()
+
+	faults := newFaultyUserRepository(t, chaos.Config{})
+	body := `{"rules": {"GetByID": {"script": [{"error": "unavailable"}]}}}`
+	r := httptest.NewRequest(http.MethodPut, "/admin/faults", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	NewFaultController(faults).SetFaults(w, r, httprouter.Params{})
+	resp := w.Result()
+
+	var config chaos.Config
+	json.NewDecoder(resp.Body).Decode(&config)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []chaos.Fault{{Error: "unavailable"}}, config.Rules["GetByID"].Script)
+	assert.Equal(t, config, faults.Config())
+}
+
+func TestSetFaultsInvalid(t *testing.T) {
+	t.Parallel()
+
+	faults := newFaultyUserRepository(t, chaos.Config{})
+	fc := NewFaultController(faults)
+	for body, status := range map[string]int{
+		`{"rules": `: http.StatusBadRequest,
+		`{"rules": {"GetByID": {"probability": 2}}}`: http.StatusUnprocessableEntity,
+	} {
+		r := httptest.NewRequest(http.MethodPut, "/admin/faults", strings.NewReader(body))
+		w := httptest.NewRecorder()
+
+		fc.SetFaults(w, r, httprouter.Params{})
+		resp := w.Result()
+
+		assert.Equal(t, status, resp.StatusCode, body)
+		assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	}
+	assert.Empty(t, faults.Config().Rules)
+}
+
+func TestGetAndClearFaults(t *testing.T) {
+	t.Parallel()
+
+	faults := newFaultyUserRepository(t, chaos.Config{Rules: map[string]chaos.Rule{
+		chaos.AnyMethod: {Fault: chaos.Fault{Error: "unavailable"}, Probability: 1},
+	}})
+	fc := NewFaultController(faults)
+
+	w := httptest.NewRecorder()
+	fc.GetFaults(w, httptest.NewRequest(http.MethodGet, "/admin/faults", nil), httprouter.Params{})
+	var config chaos.Config
+	json.NewDecoder(w.Result().Body).Decode(&config)
+	assert.Equal(t, 1.0, config.Rules[chaos.AnyMethod].Probability)
+
+	w = httptest.NewRecorder()
+	fc.ClearFaults(w, httptest.NewRequest(http.MethodDelete, "/admin/faults", nil), httprouter.Params{})
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+	assert.Empty(t, faults.Config().Rules)
+}
+
+// TestGetUserByIDRecovers shows a client retrying through a database that
+// fails, stalls and then recovers
+func TestGetUserByIDRecovers(t *testing.T) {
+	t.Parallel()
+
+	faults := newFaultyUserRepository(t, chaos.Config{Rules: map[string]chaos.Rule{
+		"GetByID": {Script: []chaos.Fault{{Error: "unavailable"}, {Timeout: true}}},
+	}})
+	uc := NewUserController(faults, WithTimeout(10*time.Millisecond))
+
+	assert.Equal(t, http.StatusServiceUnavailable, getUser(uc, "1").StatusCode)
+	assert.Equal(t, http.StatusGatewayTimeout, getUser(uc, "1").StatusCode)
+	assert.Equal(t, http.StatusOK, getUser(uc, "1").StatusCode)
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// DefaultCheckTimeout bounds each readiness check unless overridden
+const DefaultCheckTimeout = 2 * time.Second
+
+// Statuses reported by the health endpoints
+const (
+	StatusOK       = "ok"
+	StatusFailing  = "failing"
+	StatusDraining = "draining"
+)
+
+// Check is a dependency the service needs to serve requests. Run returns
+// nil while the dependency is healthy and should give up once its context
+// is done.
+type Check struct {
+	Name string
+	Run  func(context.Context) error
+}
+
+// CheckResult reports the outcome of a single check
+type CheckResult struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Health is the body of a liveness or readiness response
+type Health struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// HealthController struct containing web related logic to report whether
+// the service is alive and whether it should be sent traffic
+type HealthController struct {
+	draining int32
+	checks   []Check
+	timeout  time.Duration
+}
+
+// NewHealthController is a convenience function to create a
+// HealthController whose readiness runs the given checks, each bounded by
+// timeout, or by DefaultCheckTimeout when timeout is not positive
+func NewHealthController(timeout time.Duration, checks ...Check) *HealthController {
+	if timeout <= 0 {
+		timeout = DefaultCheckTimeout
+	}
+	return &HealthController{checks: checks, timeout: timeout}
+}
+
+// Drain fails readiness from then on, so load balancers stop routing
+// requests to a service about to shut down
+func (h *HealthController) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+// Draining reports whether Drain has been called
+func (h *HealthController) Draining() bool {
+	return atomic.LoadInt32(&h.draining) == 1
+}
+
+// GetLiveness answer 200 for as long as the process can serve requests at
+// all. Dependencies are left to readiness, so an unavailable database does
+// not get the service restarted.
+func (h *HealthController) GetLiveness(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeHealth(w, http.StatusOK, Health{Status: StatusOK})
+}
+
+// GetReadiness answer 200 when every check passes and 503 when any fails or
+// the service is draining, reporting the status and latency of each check
+func (h *HealthController) GetReadiness(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if h.Draining() {
+		writeHealth(w, http.StatusServiceUnavailable, Health{Status: StatusDraining})
+		return
+	}
+
+	health := Health{Status: StatusOK, Checks: map[string]CheckResult{}}
+	// Checks run one after the other so they do not compete for the
+	// connections of a small pool.
+	for _, check := range h.checks {
+		result := h.run(r.Context(), check)
+		if result.Status != StatusOK {
+			health.Status = StatusFailing
+		}
+		health.Checks[check.Name] = result
+	}
+	status := http.StatusOK
+	if health.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, health)
+}
+
+// run runs a check, giving up on it once the timeout passes even when it
+// ignores its context
+func (h *HealthController) run(ctx context.Context, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Run(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("no answer within %s", h.timeout)
+	}
+
+	result := CheckResult{Status: StatusOK, Latency: time.Since(start).String()}
+	if err != nil {
+		result.Status, result.Error = StatusFailing, err.Error()
+	}
+	return result
+}
+
+func writeHealth(w http.ResponseWriter, status int, health Health) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(health)
+}
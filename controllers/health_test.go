@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+func getHealth(handle httprouter.Handle) (int, Health) {
+	r := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	w := httptest.NewRecorder()
+	handle(w, r, httprouter.Params{})
+
+	var body Health
+	json.NewDecoder(w.Body).Decode(&body)
+	return w.Code, body
+}
+
+func passing(context.Context) error {
+	return nil
+}
+
+func TestGetLiveness(t *testing.T) {
+	t.Parallel()
+
+	h := NewHealthController(DefaultCheckTimeout, Check{"database", func(context.Context) error {
+		return errors.New("connection refused")
+	}})
+	status, body := getHealth(h.GetLiveness)
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, Health{Status: StatusOK}, body)
+}
+
+func TestGetReadiness(t *testing.T) {
+	t.Parallel()
+
+	h := NewHealthController(DefaultCheckTimeout, Check{"database", passing}, Check{"migrations", passing})
+	status, body := getHealth(h.GetReadiness)
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, StatusOK, body.Status)
+	assert.Len(t, body.Checks, 2)
+	assert.Equal(t, StatusOK, body.Checks["migrations"].Status)
+	assert.NotEmpty(t, body.Checks["migrations"].Latency)
+}
+
+func TestGetReadinessFailingCheck(t *testing.T) {
+	t.Parallel()
+
+	h := NewHealthController(DefaultCheckTimeout, Check{"database", passing}, Check{"migrations", func(context.Context) error {
+		return errors.New("2 migrations are pending")
+	}})
+	status, body := getHealth(h.GetReadiness)
+
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, StatusFailing, body.Status)
+	assert.Equal(t, StatusOK, body.Checks["database"].Status)
+	assert.Equal(t, CheckResult{Status: StatusFailing, Latency: body.Checks["migrations"].Latency, Error: "2 migrations are pending"}, body.Checks["migrations"])
+}
+
+func TestGetReadinessCheckTimesOut(t *testing.T) {
+	t.Parallel()
+
+	unresponsive := make(chan struct{})
+	defer close(unresponsive)
+	h := NewHealthController(10*time.Millisecond, Check{"database", func(context.Context) error {
+		<-unresponsive
+		return nil
+	}})
+	status, body := getHealth(h.GetReadiness)
+
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "no answer within 10ms", body.Checks["database"].Error)
+}
+
+func TestNewHealthControllerDefaultsTimeout(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, DefaultCheckTimeout, NewHealthController(0).timeout)
+	assert.Equal(t, DefaultCheckTimeout, NewHealthController(-time.Second).timeout)
+	assert.Equal(t, time.Second, NewHealthController(time.Second).timeout)
+}
+
+func TestGetReadinessFailsOnceDraining(t *testing.T) {
+	t.Parallel()
+
+	h := NewHealthController(DefaultCheckTimeout, Check{"database", passing})
+	h.Drain()
+	status, body := getHealth(h.GetReadiness)
+
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, Health{Status: StatusDraining}, body)
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/logging"
+)
+
+// statusRecorder records the status of the response it writes
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(bs []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(bs)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// LogRequests is middleware logging every request at debug level once it
+// has been answered, with the status and duration of its response. Applied
+// within RequestID, the line carries the identifier of the request.
+func LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !logging.Enabled(logging.Debug) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		logging.Debugf("%s %s %d %s request_id=%s", r.Method, r.URL.RequestURI(), rec.status, time.Since(start), RequestIDFromContext(r.Context()))
+	})
+}
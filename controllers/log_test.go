@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/ChrisTheShark/golang-mysql-api/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogRequests(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	logging.SetLevel(logging.Debug)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		logging.SetLevel(logging.Info)
+	}()
+
+	h := RequestID(LogRequests(http.HandlerFunc(NotFound)))
+	r := httptest.NewRequest(http.MethodGet, "/widgets?page=2", nil)
+	r.Header.Set(RequestIDHeader, "abc-123")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, buf.String(), "DEBUG GET /widgets?page=2 404 ")
+	assert.Contains(t, buf.String(), "request_id=abc-123")
+}
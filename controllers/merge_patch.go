@@ -0,0 +1,37 @@
+package controllers
+
+import "encoding/json"
+
+// mergePatch applies an RFC 7396 JSON Merge Patch document to a JSON
+// encoded target and returns the resulting JSON document.
+func mergePatch(target, patch []byte) ([]byte, error) {
+	var t, p interface{}
+	if err := json.Unmarshal(target, &t); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergeValue(t, p))
+}
+
+// mergeValue implements the MergePatch(Target, Patch) function described in
+// section 2 of RFC 7396.
+func mergeValue(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+			continue
+		}
+		t[name] = mergeValue(t[name], value)
+	}
+	return t
+}
@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Examples taken from Appendix A of RFC 7396.
+func TestMergePatch(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		target string
+		patch  string
+		result string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, c := range cases {
+		result, err := mergePatch([]byte(c.target), []byte(c.patch))
+		if err != nil {
+			t.Fatalf("unable to apply patch %s to %s due to: %v", c.patch, c.target, err)
+		}
+		assert.JSONEq(t, c.result, string(result))
+	}
+}
+
+func TestMergePatchInvalidPatch(t *testing.T) {
+	t.Parallel()
+
+	result, err := mergePatch([]byte(`{"a":"b"}`), []byte(`{"a":`))
+
+	assert.Nil(t, result)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// UnmatchedRoute labels the requests that matched no route
+const UnmatchedRoute = "unmatched"
+
+// knownMethods are the methods recorded by name; any other is recorded as
+// "other" so clients cannot add series at will
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+type routeKey struct{}
+
+// routeLabel is filled in with the route of a request by the handle serving
+// it
+type routeLabel struct {
+	route string
+}
+
+// Route labels the requests served by h with route, the path pattern h is
+// registered at, so HTTPMetrics records them by route rather than by path
+func Route(route string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		setRoute(r.Context(), route)
+		h(w, r, ps)
+	}
+}
+
+func setRoute(ctx context.Context, route string) {
+	if label, ok := ctx.Value(routeKey{}).(*routeLabel); ok {
+		label.route = route
+	}
+}
+
+// HTTPMetrics counts and times the requests served, by method, route and
+// status
+type HTTPMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// NewHTTPMetrics is a convenience function to create an HTTPMetrics,
+// registering its metrics with registerer
+func NewHTTPMetrics(registerer prometheus.Registerer) *HTTPMetrics {
+	m := &HTTPMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests served, by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Duration of HTTP requests, by method and route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+	}
+	registerer.MustRegister(m.requests, m.duration)
+	return m
+}
+
+// Middleware records every request passed on to next. Requests are labelled
+// with the route set by Route, or UnmatchedRoute when none was.
+func (m *HTTPMetrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		label := &routeLabel{route: UnmatchedRoute}
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			method := r.Method
+			if !knownMethods[method] {
+				method = "other"
+			}
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			m.requests.WithLabelValues(method, label.route, strconv.Itoa(status)).Inc()
+			m.duration.WithLabelValues(method, label.route).Observe(time.Since(start).Seconds())
+		}()
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), routeKey{}, label)))
+	})
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mocks "github.com/ChrisTheShark/golang-mysql-api/repository/mocks"
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPMetrics(t *testing.T) {
+	t.Parallel()
+
+	registry := prometheus.NewRegistry()
+	m := NewHTTPMetrics(registry)
+	uc := NewUserController(mocks.NewMockUserRepository())
+	router := httprouter.New()
+	router.GET("/users/:id", Route("/users/:id", uc.GetUserByID))
+	h := m.Middleware(uc.BatchRoutes(router))
+
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/users/1"},
+		{http.MethodGet, "/users/1"},
+		{http.MethodGet, "/users/2"},
+		{http.MethodDelete, "/users:batch"},
+		{http.MethodGet, "/widgets"},
+		{"BREW", "/users/1"},
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.requests.WithLabelValues(http.MethodGet, "/users/:id", "200")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.requests.WithLabelValues(http.MethodGet, "/users/:id", "404")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.requests.WithLabelValues(http.MethodDelete, BatchPath, "400")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.requests.WithLabelValues(http.MethodGet, UnmatchedRoute, "404")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.requests.WithLabelValues("other", UnmatchedRoute, "405")))
+
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `http_requests_total{method="GET",route="/users/:id",status="200"} 2`)
+	assert.Contains(t, rec.Body.String(), `http_request_duration_seconds_count{method="GET",route="/users/:id"} 3`)
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+)
+
+// pagingParams are the query parameters interpreted by listOptions itself;
+// every other parameter is treated as a filter.
+var pagingParams = []string{"limit", "offset", "cursor", "sort", "include_deleted"}
+
+// listOptions parses the paging, sorting and filtering query parameters of a
+// listing request.
+func listOptions(q url.Values) (repository.ListOptions, error) {
+	var opts repository.ListOptions
+
+	filterParams := url.Values{}
+	for k, v := range q {
+		filterParams[k] = v
+	}
+	for _, k := range pagingParams {
+		filterParams.Del(k)
+	}
+	filter, err := repository.ParseFilter(filterParams)
+	if err != nil {
+		return opts, err
+	}
+	opts.Filter = filter
+
+	if opts.Limit, opts.Offset, err = limitOffset(q); err != nil {
+		return opts, err
+	}
+	opts.Cursor = q.Get("cursor")
+	if opts.Cursor != "" && q.Get("offset") != "" {
+		return opts, errors.New("cursor and offset cannot be combined")
+	}
+	sort, err := repository.ParseSort(q.Get("sort"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Sort = sort
+	opts.IncludeDeleted, err = includeDeleted(q)
+	return opts, err
+}
+
+// historyOptions parses the paging query parameters of a request for the
+// audit trail of a user
+func historyOptions(q url.Values) (repository.HistoryOptions, error) {
+	var opts repository.HistoryOptions
+	var err error
+	opts.Limit, opts.Offset, err = limitOffset(q)
+	return opts, err
+}
+
+// limitOffset parses the limit and offset query parameters, leaving either
+// zero when absent
+func limitOffset(q url.Values) (limit, offset int, err error) {
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > repository.MaxLimit {
+			return 0, 0, fmt.Errorf("limit must be an integer between 1 and %d", repository.MaxLimit)
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+	}
+	return limit, offset, nil
+}
+
+// includeDeleted parses the include_deleted query parameter, which makes
+// soft deleted users visible
+func includeDeleted(q url.Values) (bool, error) {
+	v := q.Get("include_deleted")
+	if v == "" {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.New("include_deleted must be true or false")
+	}
+	return include, nil
+}
+
+// setPaginationHeaders reports the total number of matching users in
+// X-Total-Count and links to neighbouring pages in an RFC 8288 Link header.
+// Requests that page by offset receive offset links; all others receive
+// keyset cursor links.
+func setPaginationHeaders(w http.ResponseWriter, r *http.Request, opts repository.ListOptions, page *repository.UserPage) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+
+	var links []string
+	if r.URL.Query().Get("offset") != "" {
+		links = offsetLinks(r, opts.Limit, opts.Offset, len(page.Users), page.Total)
+	} else {
+		links = append(links, pageLink(r, "first", nil))
+		if page.NextCursor != "" {
+			links = append(links, pageLink(r, "next", map[string]string{"cursor": page.NextCursor}))
+		}
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// setHistoryHeaders reports the total number of audit entries recorded for
+// a user in X-Total-Count and links to neighbouring pages in a Link header
+func setHistoryHeaders(w http.ResponseWriter, r *http.Request, opts repository.HistoryOptions, page *repository.AuditPage) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	links := offsetLinks(r, opts.Limit, opts.Offset, len(page.Entries), page.Total)
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// offsetLinks links to the first, previous, next and last pages of a
+// listing paged by offset, given the size of the current page
+func offsetLinks(r *http.Request, limit, offset, size, total int) []string {
+	if limit <= 0 {
+		limit = repository.DefaultLimit
+	}
+	links := []string{pageLink(r, "first", map[string]string{"offset": "0"})}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, pageLink(r, "prev", map[string]string{"offset": strconv.Itoa(prev)}))
+	}
+	if offset+size < total {
+		links = append(links, pageLink(r, "next", map[string]string{"offset": strconv.Itoa(offset + limit)}))
+	}
+	if total > 0 {
+		last := (total - 1) / limit * limit
+		links = append(links, pageLink(r, "last", map[string]string{"offset": strconv.Itoa(last)}))
+	}
+	return links
+}
+
+// pageLink formats a Link header value for the request URL with its cursor
+// and offset replaced by set
+func pageLink(r *http.Request, rel string, set map[string]string) string {
+	q := r.URL.Query()
+	q.Del("cursor")
+	q.Del("offset")
+	for k, v := range set {
+		q.Set(k, v)
+	}
+	u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+	return fmt.Sprintf("<%s>; rel=\"%s\"", u.String(), rel)
+}
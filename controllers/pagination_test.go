@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListOptions(t *testing.T) {
+	t.Parallel()
+
+	q, _ := url.ParseQuery("limit=10&offset=20&sort=name,-age")
+	opts, err := listOptions(q)
+	if err != nil {
+		t.Fatalf("unable to parse list options due to: %v", err)
+	}
+
+	assert.Equal(t, 10, opts.Limit)
+	assert.Equal(t, 20, opts.Offset)
+	assert.Equal(t, []repository.SortField{{Field: "name"}, {Field: "age", Descending: true}}, opts.Sort)
+}
+
+func TestListOptionsFilter(t *testing.T) {
+	t.Parallel()
+
+	q, _ := url.ParseQuery("limit=10&age[gte]=30&gender=female")
+	opts, err := listOptions(q)
+	if err != nil {
+		t.Fatalf("unable to parse list options due to: %v", err)
+	}
+
+	assert.Equal(t, 10, opts.Limit)
+	assert.Equal(t, []repository.Condition{
+		{Field: "age", Op: repository.OpGte, Value: 30},
+		{Field: "gender", Op: repository.OpEq, Value: "female"},
+	}, opts.Filter.Conditions)
+}
+
+func TestListOptionsIncludeDeleted(t *testing.T) {
+	t.Parallel()
+
+	q, _ := url.ParseQuery("include_deleted=true&gender=female")
+	opts, err := listOptions(q)
+	if err != nil {
+		t.Fatalf("unable to parse list options due to: %v", err)
+	}
+
+	assert.True(t, opts.IncludeDeleted)
+	assert.Len(t, opts.Filter.Conditions, 1)
+}
+
+func TestListOptionsInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, query := range []string{
+		"limit=0",
+		"limit=abc",
+		"limit=1001",
+		"offset=-1",
+		"offset=abc",
+		"cursor=abc&offset=10",
+		"sort=password",
+		"password=secret",
+		"age[gte]=thirty",
+		"include_deleted=maybe",
+	} {
+		q, _ := url.ParseQuery(query)
+		_, err := listOptions(q)
+		assert.NotNil(t, err, query)
+	}
+}
+
+func TestSetPaginationHeadersOffset(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/users?limit=2&offset=2&sort=name", nil)
+	w := httptest.NewRecorder()
+	opts, _ := listOptions(r.URL.Query())
+	page := &repository.UserPage{Users: make([]models.User, 2), Total: 7}
+
+	setPaginationHeaders(w, r, opts, page)
+
+	assert.Equal(t, "7", w.Header().Get("X-Total-Count"))
+	assert.Equal(t, `</users?limit=2&offset=0&sort=name>; rel="first", `+
+		`</users?limit=2&offset=0&sort=name>; rel="prev", `+
+		`</users?limit=2&offset=4&sort=name>; rel="next", `+
+		`</users?limit=2&offset=6&sort=name>; rel="last"`, w.Header().Get("Link"))
+}
+
+func TestSetPaginationHeadersCursor(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/users?limit=2", nil)
+	w := httptest.NewRecorder()
+	opts, _ := listOptions(r.URL.Query())
+	page := &repository.UserPage{Users: make([]models.User, 2), Total: 7, NextCursor: "abc"}
+
+	setPaginationHeaders(w, r, opts, page)
+
+	assert.Equal(t, "7", w.Header().Get("X-Total-Count"))
+	assert.Equal(t, `</users?limit=2>; rel="first", </users?cursor=abc&limit=2>; rel="next"`, w.Header().Get("Link"))
+}
+
+func TestHistoryOptions(t *testing.T) {
+	t.Parallel()
+
+	q, _ := url.ParseQuery("limit=5&offset=10&sort=name")
+	opts, err := historyOptions(q)
+
+	assert.Nil(t, err)
+	assert.Equal(t, repository.HistoryOptions{Limit: 5, Offset: 10}, opts)
+
+	for _, query := range []string{"limit=0", "offset=-1"} {
+		q, _ := url.ParseQuery(query)
+		_, err := historyOptions(q)
+		assert.NotNil(t, err, query)
+	}
+}
+
+func TestSetHistoryHeaders(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/users/1/history?limit=2", nil)
+	w := httptest.NewRecorder()
+	opts, _ := historyOptions(r.URL.Query())
+	page := &repository.AuditPage{Entries: make([]models.AuditEntry, 2), Total: 3}
+
+	setHistoryHeaders(w, r, opts, page)
+
+	assert.Equal(t, "3", w.Header().Get("X-Total-Count"))
+	assert.Equal(t, `</users/1/history?limit=2&offset=0>; rel="first", `+
+		`</users/1/history?limit=2&offset=2>; rel="next", `+
+		`</users/1/history?limit=2&offset=2>; rel="last"`, w.Header().Get("Link"))
+}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/ChrisTheShark/golang-mysql-api/logging"
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+)
+
+// RequestIDHeader carries the identifier correlating a request with its
+// response and log lines
+const RequestIDHeader = "X-Request-ID"
+
+// Problem is an RFC 7807 problem details document describing a failed
+// request. Errors maps the JSON name of each invalid field to a message.
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	Errors    map[string]string `json:"errors,omitempty"`
+}
+
+// writeProblem responds with an application/problem+json document whose
+// type and title are derived from the status code.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	writeProblemDocument(w, r, Problem{Status: status, Detail: detail})
+}
+
+// writeValidationProblem responds with 422 and a message for each invalid field
+func writeValidationProblem(w http.ResponseWriter, r *http.Request, detail string, fields map[string]string) {
+	writeProblemDocument(w, r, Problem{Status: http.StatusUnprocessableEntity, Detail: detail, Errors: fields})
+}
+
+// writeValidationError responds with 422, listing the invalid fields of a
+// models.ValidationError when err wraps one
+func writeValidationError(w http.ResponseWriter, r *http.Request, err error) {
+	var validation models.ValidationError
+	errors.As(err, &validation)
+	writeValidationProblem(w, r, "the user was rejected as invalid", validation.Fields)
+}
+
+func writeProblemDocument(w http.ResponseWriter, r *http.Request, p Problem) {
+	p.Title = http.StatusText(p.Status)
+	p.Type = "/problems/" + strings.ToLower(strings.Replace(p.Title, " ", "-", -1))
+	p.Instance = r.URL.RequestURI()
+	p.RequestID = requestID(w, r)
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+type requestIDKey struct{}
+
+// validRequestID restricts client supplied request IDs to a safe alphabet so
+// they can be echoed in headers and logs verbatim
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID is middleware assigning every request an identifier, reusing a
+// well formed X-Request-ID supplied by the client. The identifier is echoed
+// in the X-Request-ID response header and available to handlers through
+// RequestIDFromContext.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestIDFromContext returns the identifier assigned by RequestID, or an
+// empty string when the middleware was not applied
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestID returns the identifier of a request, assigning one when the
+// RequestID middleware has not already done so
+func requestID(w http.ResponseWriter, r *http.Request) string {
+	if id := RequestIDFromContext(r.Context()); id != "" {
+		return id
+	}
+	if id := w.Header().Get(RequestIDHeader); id != "" {
+		return id
+	}
+	id := newRequestID()
+	w.Header().Set(RequestIDHeader, id)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NotFound answers requests for unknown routes with a problem document
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusNotFound, "no resource exists at this path")
+}
+
+// MethodNotAllowed answers requests using an unsupported method with a
+// problem document
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusMethodNotAllowed, "the resource does not support the "+r.Method+" method")
+}
+
+// Panic answers requests whose handler panicked with a problem document.
+// Handlers aborting a response already under way with http.ErrAbortHandler
+// are left to net/http, which drops the connection.
+func Panic(w http.ResponseWriter, r *http.Request, v interface{}) {
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+	logging.Errorf("panic serving %s: %v", r.URL.Path, v)
+	writeProblem(w, r, http.StatusInternalServerError, "an unexpected error occurred")
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteProblem(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/users/99?verbose=true", nil)
+	w := httptest.NewRecorder()
+
+	writeProblem(w, r, http.StatusNotFound, "the requested user does not exist")
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	var p Problem
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		t.Fatalf("unable to decode problem due to: %v", err)
+	}
+
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "/problems/not-found", p.Type)
+	assert.Equal(t, "Not Found", p.Title)
+	assert.Equal(t, http.StatusNotFound, p.Status)
+	assert.Equal(t, "the requested user does not exist", p.Detail)
+	assert.Equal(t, "/users/99?verbose=true", p.Instance)
+	assert.NotEmpty(t, p.RequestID)
+	assert.Equal(t, p.RequestID, resp.Header.Get(RequestIDHeader))
+}
+
+func TestWriteValidationProblem(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodPost, "/users", nil)
+	w := httptest.NewRecorder()
+
+	writeValidationProblem(w, r, "the user was rejected as invalid", map[string]string{"age": "must not be negative"})
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	var p Problem
+	json.NewDecoder(resp.Body).Decode(&p)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, "/problems/unprocessable-entity", p.Type)
+	assert.Equal(t, map[string]string{"age": "must not be negative"}, p.Errors)
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	t.Parallel()
+
+	var seen string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+		writeProblem(w, r, http.StatusServiceUnavailable, "the database is unavailable")
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/users", nil)
+	r.Header.Set(RequestIDHeader, "abc-123")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	var p Problem
+	json.NewDecoder(w.Body).Decode(&p)
+
+	assert.Equal(t, "abc-123", seen)
+	assert.Equal(t, "abc-123", w.Header().Get(RequestIDHeader))
+	assert.Equal(t, "abc-123", p.RequestID)
+}
+
+func TestRequestIDMiddlewareRejectsMalformedID(t *testing.T) {
+	t.Parallel()
+
+	var seen string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/users", nil)
+	r.Header.Set(RequestIDHeader, "bad id\r\ninjected: true")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.NotEqual(t, "bad id\r\ninjected: true", seen)
+	assert.Len(t, seen, 32)
+	assert.Equal(t, seen, w.Header().Get(RequestIDHeader))
+}
+
+func TestNotFound(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/nowhere", nil)
+	w := httptest.NewRecorder()
+
+	NotFound(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+}
+
+func TestPanic(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/users", nil)
+	w := httptest.NewRecorder()
+
+	Panic(w, r, "blamo")
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		Panic(httptest.NewRecorder(), r, http.ErrAbortHandler)
+	})
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisTheShark/golang-mysql-api/logging"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+	"github.com/ChrisTheShark/golang-mysql-api/transfer"
+)
+
+// ImportPath addresses the import endpoint. Like BatchPath it is served by
+// ImportRoutes ahead of the router, which cannot route it alongside
+// /users/:id.
+const ImportPath = "/users/import"
+
+// exportExtensions names the file extension offered for each export format
+var exportExtensions = map[string]string{
+	transfer.CSV:    ".csv",
+	transfer.NDJSON: ".ndjson",
+}
+
+// exportFormat selects the export format requested by the Accept header of
+// a listing, or the empty string when the client prefers a JSON page. The
+// first supported media range listed wins.
+func exportFormat(r *http.Request) string {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case transfer.CSV:
+			return transfer.CSV
+		case transfer.NDJSON, "application/ndjson":
+			return transfer.NDJSON
+		case "application/json", "application/*", "*/*":
+			return ""
+		}
+	}
+	return ""
+}
+
+// writeTracker records whether any of the body of a response has been
+// written
+type writeTracker struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (t *writeTracker) Write(bs []byte) (int, error) {
+	t.written = true
+	return t.ResponseWriter.Write(bs)
+}
+
+func (t *writeTracker) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// exportUsers streams every user matching opts in format. An export is
+// bounded by the client rather than by the timeout of a single operation,
+// as a large table takes a while to stream. Once the export has begun a
+// failure can no longer be reported with a status, so the response is
+// aborted instead, letting the client tell a truncated export from a
+// complete one.
+func (u UserController) exportUsers(w http.ResponseWriter, r *http.Request, opts repository.ListOptions, format string) {
+	q := r.URL.Query()
+	for _, param := range []string{"limit", "offset", "cursor"} {
+		if q.Get(param) != "" {
+			writeProblem(w, r, http.StatusBadRequest, "exports include every matching user and cannot be paged with "+param)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", format)
+	w.Header().Set("Content-Disposition", `attachment; filename="users`+exportExtensions[format]+`"`)
+	tracker := &writeTracker{ResponseWriter: w}
+	_, err := transfer.Export(r.Context(), u.userRepository, opts, tracker, format)
+	if err == nil {
+		return
+	}
+	if !tracker.written {
+		w.Header().Del("Content-Disposition")
+		repositoryError(r.Context(), w, r, err)
+		return
+	}
+	logging.Warnf("export aborted due to: %v", err)
+	panic(http.ErrAbortHandler)
+}
+
+// ImportRoutes serves the import endpoint at ImportPath, passing every other
+// request on to next
+func (u UserController) ImportRoutes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != ImportPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		setRoute(r.Context(), ImportPath)
+		defer func() {
+			if v := recover(); v != nil {
+				Panic(w, r, v)
+			}
+		}()
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			MethodNotAllowed(w, r)
+			return
+		}
+		u.ImportUsers(w, r)
+	})
+}
+
+// ImportUsers create users from a CSV whose header names their fields,
+// mapped with map[field]=header query parameters. Every row is imported or,
+// when any row is invalid or dry_run=true, none is. The response reports
+// the rejected rows, as CSV when the client accepts it.
+func (u UserController) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	opts, err := importOptions(r.URL.Query())
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != transfer.CSV {
+		writeProblem(w, r, http.StatusUnsupportedMediaType, "the request body must be text/csv")
+		return
+	}
+
+	ctx, cancel := u.context(r)
+	defer cancel()
+
+	report, err := transfer.Import(ctx, u.userRepository, r.Body, opts)
+	var formatErr transfer.FormatError
+	switch {
+	case errors.As(err, &formatErr):
+		writeProblem(w, r, http.StatusBadRequest, formatErr.Message)
+		return
+	case errors.Is(err, transfer.ErrTooManyRows):
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the CSV must hold at most %d rows", MaxBatchSize))
+		return
+	case err != nil:
+		repositoryError(ctx, w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	switch {
+	case len(report.Errors) > 0:
+		status = http.StatusUnprocessableEntity
+	case report.Imported > 0:
+		status = http.StatusCreated
+	}
+	if exportFormat(r) == transfer.CSV {
+		w.Header().Set("Content-Type", transfer.CSV)
+		w.Header().Set("Content-Disposition", `attachment; filename="import-errors.csv"`)
+		w.WriteHeader(status)
+		report.WriteCSV(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// importOptions parses the query parameters of an import: dry_run and a
+// map[field]=header parameter for each field read from a column not named
+// after it
+func importOptions(q url.Values) (transfer.Options, error) {
+	opts := transfer.Options{Columns: map[string]string{}, MaxRows: MaxBatchSize}
+	for k, v := range q {
+		switch {
+		case k == "dry_run":
+			dryRun, err := strconv.ParseBool(q.Get(k))
+			if err != nil {
+				return opts, errors.New("dry_run must be true or false")
+			}
+			opts.DryRun = dryRun
+		case strings.HasPrefix(k, "map[") && strings.HasSuffix(k, "]"):
+			field := k[len("map[") : len(k)-1]
+			if len(v) != 1 || v[0] == "" {
+				return opts, fmt.Errorf("%s must name a single column", k)
+			}
+			opts.Columns[field] = v[0]
+		default:
+			return opts, fmt.Errorf("unknown query parameter %q", k)
+		}
+	}
+	return opts, nil
+}
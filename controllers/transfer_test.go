@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+	mocks "github.com/ChrisTheShark/golang-mysql-api/repository/mocks"
+	"github.com/ChrisTheShark/golang-mysql-api/transfer"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+// exportUsers requests an export of target in the accepted format
+func exportUsers(uc *UserController, target, accept string) *http.Response {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.Header.Set("Accept", accept)
+	w := httptest.NewRecorder()
+	uc.GetUsers(w, r, httprouter.Params{})
+	return w.Result()
+}
+
+func TestExportUsersCSV(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	ur.Seed(models.User{Name: "Vesper Lynd", Gender: "female", Age: 32})
+	uc := NewUserController(ur)
+
+	resp := exportUsers(uc, "/users?sort=-age", "text/csv, application/json;q=0.5")
+	bs, _ := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="users.csv"`, resp.Header.Get("Content-Disposition"))
+	assert.Equal(t, "id,name,gender,age,deleted_at\n1,James Bond,male,44,\n2,Vesper Lynd,female,32,\n", string(bs))
+}
+
+func TestExportUsersNDJSON(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	ur.Seed(models.User{Name: "Vesper Lynd", Gender: "female", Age: 32})
+	uc := NewUserController(ur)
+
+	resp := exportUsers(uc, "/users?gender=female", "application/x-ndjson")
+	bs, _ := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "{\"name\":\"Vesper Lynd\",\"gender\":\"female\",\"age\":32,\"id\":\"2\"}\n", string(bs))
+	assert.Len(t, ur.CallsTo("Each"), 1)
+	assert.Empty(t, ur.CallsTo("List"))
+}
+
+func TestExportUsersRejectsPaging(t *testing.T) {
+	t.Parallel()
+
+	resp := exportUsers(NewUserController(mocks.NewMockUserRepository()), "/users?limit=10", "text/csv")
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestExportUsersUnavailable(t *testing.T) {
+	t.Parallel()
+
+	resp := exportUsers(NewUserController(mocks.NewMockErroringUserRepository()), "/users", "text/csv")
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	assert.Empty(t, resp.Header.Get("Content-Disposition"))
+}
+
+// truncatingRepository fails Each once it has passed on n users, as a
+// connection dropped midway through an export would
+type truncatingRepository struct {
+	*mocks.MockUserRepository
+	n int
+}
+
+func (r truncatingRepository) Each(ctx context.Context, opts repository.ListOptions, fn func(models.User) error) error {
+	for i := 0; i < r.n; i++ {
+		if err := fn(models.User{ID: "1", Name: "James Bond", Gender: "male", Age: 44}); err != nil {
+			return err
+		}
+	}
+	return models.UnavailableError{Message: "unable to locate users", Err: errors.New("blamo")}
+}
+
+func TestExportUsersAbortsTruncatedExport(t *testing.T) {
+	t.Parallel()
+
+	uc := NewUserController(truncatingRepository{mocks.NewMockUserRepository(), 1000})
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		exportUsers(uc, "/users", "text/csv")
+	})
+}
+
+func TestExportFormat(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"":                                "",
+		"application/json":                "",
+		"*/*, text/csv":                   "",
+		"text/csv; charset=utf-8":         transfer.CSV,
+		"application/ndjson":              transfer.NDJSON,
+		"text/html, application/x-ndjson": transfer.NDJSON,
+	}
+	for accept, expected := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/users", nil)
+		r.Header.Set("Accept", accept)
+		assert.Equal(t, expected, exportFormat(r), accept)
+	}
+}
+
+// serveImport sends an import request through ImportRoutes
+func serveImport(uc *UserController, method, target, contentType, accept, body string) *http.Response {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	r.Header.Set("Accept", accept)
+	w := httptest.NewRecorder()
+	uc.ImportRoutes(http.NotFoundHandler()).ServeHTTP(w, r)
+	return w.Result()
+}
+
+func decodeReport(t *testing.T, resp *http.Response) transfer.Report {
+	t.Helper()
+	defer resp.Body.Close()
+	var report transfer.Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("unable to decode import report due to: %v", err)
+	}
+	return report
+}
+
+func TestImportUsers(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	uc := NewUserController(ur)
+
+	resp := serveImport(uc, http.MethodPost, ImportPath+"?map[name]=Full%20Name", "text/csv", "",
+		"Full Name,gender,age\nFelix Leiter,male,40\nVesper Lynd,female,32\n")
+
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, transfer.Report{Rows: 2, Imported: 2, Errors: []transfer.RowError{}}, decodeReport(t, resp))
+	user, err := ur.GetByID(context.Background(), "3")
+	assert.Nil(t, err)
+	assert.Equal(t, "Vesper Lynd", user.Name)
+}
+
+func TestImportUsersDryRun(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	uc := NewUserController(ur)
+
+	resp := serveImport(uc, http.MethodPost, ImportPath+"?dry_run=true", "text/csv", "", "name,gender,age\nFelix Leiter,male,40\n")
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, transfer.Report{Rows: 1, DryRun: true, Errors: []transfer.RowError{}}, decodeReport(t, resp))
+	assert.Empty(t, ur.CallsTo("CreateMany"))
+}
+
+func TestImportUsersInvalidRows(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	uc := NewUserController(ur)
+	body := "name,gender,age\nFelix Leiter,male,40\nQ,male,old\n"
+
+	resp := serveImport(uc, http.MethodPost, ImportPath, "text/csv", "", body)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, []transfer.RowError{{Row: 3, Field: "age", Message: "must be a whole number"}}, decodeReport(t, resp).Errors)
+
+	resp = serveImport(uc, http.MethodPost, ImportPath, "text/csv", "text/csv", body)
+	bs, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, `attachment; filename="import-errors.csv"`, resp.Header.Get("Content-Disposition"))
+	assert.Equal(t, "row,field,message\n3,age,must be a whole number\n", string(bs))
+	assert.Empty(t, ur.CallsTo("CreateMany"))
+}
+
+func TestImportUsersUnavailable(t *testing.T) {
+	t.Parallel()
+
+	uc := NewUserController(mocks.NewMockErroringUserRepository())
+
+	resp := serveImport(uc, http.MethodPost, ImportPath, "text/csv", "", "name,gender,age\nQ,male,30\n")
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestImportUsersBadRequests(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		method      string
+		target      string
+		contentType string
+		body        string
+		expected    int
+	}{
+		"method":        {http.MethodGet, ImportPath, "text/csv", "", http.StatusMethodNotAllowed},
+		"media type":    {http.MethodPost, ImportPath, "application/json", "[]", http.StatusUnsupportedMediaType},
+		"dry run":       {http.MethodPost, ImportPath + "?dry_run=maybe", "text/csv", "name,gender,age\n", http.StatusBadRequest},
+		"unknown param": {http.MethodPost, ImportPath + "?atomic=false", "text/csv", "name,gender,age\n", http.StatusBadRequest},
+		"unknown field": {http.MethodPost, ImportPath + "?map[email]=Email", "text/csv", "name,gender,age\n", http.StatusBadRequest},
+		"no header":     {http.MethodPost, ImportPath, "text/csv", "", http.StatusBadRequest},
+		"too large":     {http.MethodPost, ImportPath, "text/csv", "name,gender,age\n" + strings.Repeat("Q,male,30\n", MaxBatchSize+1), http.StatusRequestEntityTooLarge},
+		"other route":   {http.MethodPost, "/users", "text/csv", "", http.StatusNotFound},
+	}
+	for name, tt := range tests {
+		uc := NewUserController(mocks.NewMockUserRepository())
+
+		resp := serveImport(uc, tt.method, tt.target, tt.contentType, "", tt.body)
+		resp.Body.Close()
+
+		assert.Equal(t, tt.expected, resp.StatusCode, name)
+		if tt.expected == http.StatusMethodNotAllowed {
+			assert.Equal(t, "POST", resp.Header.Get("Allow"))
+		}
+	}
+}
@@ -1,84 +1,446 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"log"
+	"errors"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/ChrisTheShark/golang-mysql-api/logging"
 	"github.com/ChrisTheShark/golang-mysql-api/models"
 	"github.com/ChrisTheShark/golang-mysql-api/repository"
 	"github.com/julienschmidt/httprouter"
 )
 
+// DefaultTimeout bounds each repository operation performed by a
+// UserController unless overridden with WithTimeout.
+const DefaultTimeout = 5 * time.Second
+
 // UserController struct containing web related logic to operate on Users
 type UserController struct {
 	userRepository repository.UserRepository
+	timeout        time.Duration
+	seeOther       bool
+}
+
+// Option configures optional behavior of a UserController
+type Option func(*UserController)
+
+// WithTimeout sets the deadline applied to each repository operation. A
+// non-positive duration disables the deadline, leaving only the request's
+// own cancellation in effect.
+func WithTimeout(d time.Duration) Option {
+	return func(u *UserController) {
+		u.timeout = d
+	}
+}
+
+// WithSeeOtherOnCreate restores the original behavior of AddUser, which
+// answers with a 303 redirect to the created user instead of 201 Created
+func WithSeeOtherOnCreate(enabled bool) Option {
+	return func(u *UserController) {
+		u.seeOther = enabled
+	}
 }
 
 // NewUserController is a convenience function to create a UserController
-func NewUserController(r repository.UserRepository) *UserController {
-	return &UserController{r}
+func NewUserController(r repository.UserRepository, opts ...Option) *UserController {
+	u := &UserController{
+		userRepository: r,
+		timeout:        DefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(u)
+	}
+	return u
+}
+
+// DefaultActor is recorded in the audit trail for writes made by requests
+// without an X-Actor header
+const DefaultActor = "anonymous"
+
+// maxActorLength bounds the X-Actor header recorded in the audit trail
+const maxActorLength = 255
+
+// context derives the context for a repository operation from the incoming
+// request, so a client disconnect or an expired deadline aborts the query.
+// Writes made with it are attributed in the audit trail to the actor named
+// by the X-Actor header and to the request's ID.
+func (u UserController) context(r *http.Request) (context.Context, context.CancelFunc) {
+	ctx := repository.WithActor(r.Context(), actor(r))
+	ctx = repository.WithRequestID(ctx, RequestIDFromContext(r.Context()))
+	if u.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, u.timeout)
+}
+
+// actor names who made a request, as reported by its X-Actor header
+func actor(r *http.Request) string {
+	actor := strings.TrimSpace(r.Header.Get("X-Actor"))
+	if actor == "" {
+		return DefaultActor
+	}
+	if len(actor) > maxActorLength {
+		actor = actor[:maxActorLength]
+	}
+	return actor
+}
+
+// requestError aborts a transaction because of a problem with the request
+// itself, which is answered with status and detail
+type requestError struct {
+	status int
+	detail string
+}
+
+func (e requestError) Error() string {
+	return e.detail
+}
+
+// repositoryError reports a failed repository operation as a problem
+// document, mapping each class of the models error taxonomy to its HTTP
+// status. Operations that ran out of time answer with 504 and unclassified
+// failures with 503.
+func repositoryError(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) {
+	p := problemFor(ctx, err)
+	if p.Status >= http.StatusInternalServerError {
+		logging.Errorf("%v", err)
+	}
+	writeProblemDocument(w, r, p)
 }
 
-// GetUsers retrieve all users
+// problemFor describes a failed repository operation as the problem
+// document reporting it
+func problemFor(ctx context.Context, err error) Problem {
+	var reqErr requestError
+	var validation models.ValidationError
+	switch {
+	case errors.As(err, &reqErr):
+		return Problem{Status: reqErr.status, Detail: reqErr.detail}
+	case errors.Is(err, models.ErrNotFound):
+		return Problem{Status: http.StatusNotFound, Detail: "the requested user does not exist"}
+	case errors.Is(err, models.ErrConflict):
+		return Problem{Status: http.StatusConflict, Detail: "the request conflicts with an existing user"}
+	case errors.Is(err, models.ErrValidation):
+		errors.As(err, &validation)
+		return Problem{Status: http.StatusUnprocessableEntity, Detail: "the user was rejected as invalid", Errors: validation.Fields}
+	case errors.Is(err, models.ErrVersionMismatch):
+		return Problem{Status: http.StatusPreconditionFailed, Detail: "the user has been modified since it was retrieved"}
+	case errors.Is(err, context.DeadlineExceeded) || ctx.Err() == context.DeadlineExceeded:
+		return Problem{Status: http.StatusGatewayTimeout, Detail: "the database did not respond in time"}
+	default:
+		return Problem{Status: http.StatusServiceUnavailable, Detail: "the database is unavailable"}
+	}
+}
+
+// GetUsers retrieve a page of users, honoring the limit, offset, cursor and
+// sort query parameters. Clients accepting CSV or newline delimited JSON
+// are instead sent every matching user as an export.
 func (u UserController) GetUsers(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	users, err := u.userRepository.GetAll()
+	opts, err := listOptions(r.URL.Query())
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if format := exportFormat(r); format != "" {
+		u.exportUsers(w, r, opts, format)
+		return
+	}
+
+	ctx, cancel := u.context(r)
+	defer cancel()
+
+	page, err := u.userRepository.List(ctx, opts)
 	if err != nil {
-		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		if errors.Is(err, repository.ErrInvalidCursor) {
+			writeProblem(w, r, http.StatusBadRequest, "the cursor is invalid or was issued for a different sort order")
+			return
+		}
+		repositoryError(ctx, w, r, err)
 		return
 	}
+	setPaginationHeaders(w, r, opts, page)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
+	json.NewEncoder(w).Encode(page.Users)
 }
 
-// GetUserByID get a user by string identifier
+// GetUserByID get a user by string identifier along with its ETag,
+// answering with 304 when If-None-Match names the current version. Soft
+// deleted users are only found with include_deleted=true.
 func (u UserController) GetUserByID(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	include, err := includeDeleted(r.URL.Query())
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := u.context(r)
+	defer cancel()
+
 	id := p.ByName("id")
-	user, err := u.userRepository.GetByID(id)
+	var user *models.User
+	if include {
+		user, err = repository.GetIncludingDeleted(ctx, u.userRepository, id)
+	} else {
+		user, err = u.userRepository.GetByID(ctx, id)
+	}
 	if err != nil {
-		if _, ok := err.(models.UserNotFoundError); ok {
-			http.Error(w, "not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		repositoryError(ctx, w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(*user))
+	if notModified(r, *user) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
 
-// AddUser add a json encoded user
+// AddUser add a json encoded user, answering with 201 Created, a Location
+// header and the stored user
 func (u UserController) AddUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var user models.User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil || user.IsEmpty() {
-		log.Println(err)
-		http.Error(w, "bad request", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "the request body must be a JSON encoded user")
+		return
+	}
+	if err := user.Validate(); err != nil {
+		writeValidationError(w, r, err)
 		return
 	}
-	id, err := u.userRepository.Create(user)
+
+	user.DeletedAt = nil
+
+	ctx, cancel := u.context(r)
+	defer cancel()
+
+	id, err := u.userRepository.Create(ctx, user)
 	if err != nil {
-		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		repositoryError(ctx, w, r, err)
 		return
 	}
-	http.Redirect(w, r, fmt.Sprintf("/users/%v", id), http.StatusSeeOther)
+	location := "/users/" + url.PathEscape(id)
+	if u.seeOther {
+		http.Redirect(w, r, location, http.StatusSeeOther)
+		return
+	}
+	user.ID = id
+	user.Version = 1
+	w.Header().Set("Location", location)
+	w.Header().Set("ETag", etag(user))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
 }
 
-// DeleteUser remove a user
-func (u UserController) DeleteUser(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+// UpdateUser replace a user with a json encoded user, provided If-Match,
+// when present, names its current version
+func (u UserController) UpdateUser(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	id := p.ByName("id")
-	user, err := u.userRepository.GetByID(id)
+	var user models.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil || user.IsEmpty() {
+		writeProblem(w, r, http.StatusBadRequest, "the request body must be a JSON encoded user")
+		return
+	}
+	if err := user.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	if user.ID != "" && user.ID != id {
+		writeProblem(w, r, http.StatusBadRequest, "the user id cannot be changed")
+		return
+	}
+	user.ID = id
+
+	ctx, cancel := u.context(r)
+	defer cancel()
+
+	err := u.userRepository.WithTx(ctx, func(tx repository.UserRepository) error {
+		current, err := tx.GetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(r, *current); err != nil {
+			return err
+		}
+		user.Version = current.Version
+		user.DeletedAt = current.DeletedAt
+		if err := tx.Update(ctx, user); err != nil {
+			return err
+		}
+		user.Version++
+		return nil
+	})
 	if err != nil {
-		if _, ok := err.(models.UserNotFoundError); ok {
-			http.Error(w, "not found", http.StatusNotFound)
-			return
+		repositoryError(ctx, w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(user))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// PatchUser apply a JSON Merge Patch (RFC 7396) document to a user,
+// provided If-Match, when present, names its current version
+func (u UserController) PatchUser(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/merge-patch+json" && mediaType != "application/json") {
+		writeProblem(w, r, http.StatusUnsupportedMediaType, "the request body must be application/merge-patch+json")
+		return
+	}
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "the request body could not be read")
+		return
+	}
+
+	ctx, cancel := u.context(r)
+	defer cancel()
+
+	id := p.ByName("id")
+	var updated models.User
+	err = u.userRepository.WithTx(ctx, func(tx repository.UserRepository) error {
+		user, err := tx.GetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(r, *user); err != nil {
+			return err
 		}
-		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		updated, err = applyPatch(ctx, tx, *user, patch)
+		return err
+	})
+	if err != nil {
+		repositoryError(ctx, w, r, err)
 		return
 	}
-	if err := u.userRepository.Delete(*user); err != nil {
-		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+	w.Header().Set("ETag", etag(updated))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// applyPatch applies a JSON Merge Patch document to a user read within tx
+// and stores the result, returning the user as updated
+func applyPatch(ctx context.Context, tx repository.UserRepository, user models.User, patch []byte) (models.User, error) {
+	original, err := json.Marshal(user)
+	if err != nil {
+		return models.User{}, err
+	}
+	patched, err := mergePatch(original, patch)
+	if err != nil {
+		return models.User{}, requestError{http.StatusBadRequest, "the request body must be a JSON merge patch document"}
+	}
+	var updated models.User
+	if err := json.Unmarshal(patched, &updated); err != nil {
+		return models.User{}, requestError{http.StatusBadRequest, "the patched document is not a valid user"}
+	}
+	if updated.ID != user.ID {
+		return models.User{}, requestError{http.StatusBadRequest, "the user id cannot be changed"}
+	}
+	if err := updated.Validate(); err != nil {
+		return models.User{}, err
+	}
+
+	updated.Version = user.Version
+	updated.DeletedAt = user.DeletedAt
+	if err := tx.Update(ctx, updated); err != nil {
+		return models.User{}, err
+	}
+	updated.Version++
+	return updated, nil
+}
+
+// DeleteUser soft delete a user, looking it up and deleting it in a single
+// transaction, provided If-Match, when present, names its current version
+func (u UserController) DeleteUser(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	ctx, cancel := u.context(r)
+	defer cancel()
+
+	id := p.ByName("id")
+	err := u.userRepository.WithTx(ctx, func(tx repository.UserRepository) error {
+		user, err := tx.GetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(r, *user); err != nil {
+			return err
+		}
+		return tx.Delete(ctx, *user)
+	})
+	if err != nil {
+		repositoryError(ctx, w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// RestoreUser undo the soft delete of a user, provided If-Match, when
+// present, names its current version, answering with the restored user
+func (u UserController) RestoreUser(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	ctx, cancel := u.context(r)
+	defer cancel()
+
+	id := p.ByName("id")
+	var user *models.User
+	err := u.userRepository.WithTx(ctx, func(tx repository.UserRepository) error {
+		var err error
+		if user, err = repository.GetIncludingDeleted(ctx, tx, id); err != nil {
+			return err
+		}
+		if err := checkIfMatch(r, *user); err != nil {
+			return err
+		}
+		if err := tx.Restore(ctx, *user); err != nil {
+			return err
+		}
+		user.Version++
+		user.DeletedAt = nil
+		return nil
+	})
+	if errors.Is(err, models.ErrConflict) {
+		writeProblem(w, r, http.StatusConflict, "the user is not deleted")
+		return
+	}
+	if err != nil {
+		repositoryError(ctx, w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(*user))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// GetUserHistory retrieve a page of the audit trail of a user, deleted or
+// purged, oldest entry first, honoring the limit and offset query parameters
+func (u UserController) GetUserHistory(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	opts, err := historyOptions(r.URL.Query())
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := u.context(r)
+	defer cancel()
+
+	id := p.ByName("id")
+	page, err := u.userRepository.History(ctx, id, opts)
+	if err == nil && page.Total == 0 {
+		// Users created before the audit trail was introduced have no
+		// history but still exist; only unknown users are not found.
+		_, err = repository.GetIncludingDeleted(ctx, u.userRepository, id)
+	}
+	if err != nil {
+		repositoryError(ctx, w, r, err)
+		return
+	}
+	setHistoryHeaders(w, r, opts, page)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page.Entries)
+}
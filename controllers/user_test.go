@@ -2,11 +2,16 @@ package controllers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
 	mocks "github.com/ChrisTheShark/golang-mysql-api/repository/mocks"
 	"github.com/julienschmidt/httprouter"
 
@@ -17,6 +22,8 @@ import (
 )
 
 func TestGetAllUsers(t *testing.T) {
+	t.Parallel()
+
 	r := httptest.NewRequest(http.MethodGet, "/users", nil)
 	w := httptest.NewRecorder()
 	p := httprouter.Params{}
@@ -31,7 +38,103 @@ func TestGetAllUsers(t *testing.T) {
 	assert.Equal(t, "[{\"name\":\"James Bond\",\"gender\":\"male\",\"age\":44,\"id\":\"1\"}]\n", string(bs))
 }
 
+func TestGetUsersPaginated(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	ur.Create(context.Background(), models.User{
+		Name:   "Q",
+		Gender: "male",
+		Age:    30,
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/users?limit=1&sort=-name", nil)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+
+	uc := NewUserController(ur)
+	uc.GetUsers(w, r, p)
+	resp := w.Result()
+
+	bs, _ := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+
+	var users []models.User
+	json.Unmarshal(bs, &users)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, users, 1)
+	assert.Equal(t, "Q", users[0].Name)
+	assert.NotEmpty(t, resp.Header.Get("X-Total-Count"))
+	assert.Contains(t, resp.Header.Get("Link"), `rel="next"`)
+}
+
+func TestGetUsersFiltered(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/users?name[prefix]=Jam&age[gte]=40", nil)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+
+	uc := NewUserController(mocks.NewMockUserRepository())
+	uc.GetUsers(w, r, p)
+	resp := w.Result()
+
+	bs, _ := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("X-Total-Count"))
+	assert.Equal(t, "[{\"name\":\"James Bond\",\"gender\":\"male\",\"age\":44,\"id\":\"1\"}]\n", string(bs))
+}
+
+func TestGetUsersUnknownFilter(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/users?password=secret", nil)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+
+	uc := NewUserController(mocks.NewMockUserRepository())
+	uc.GetUsers(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestGetUsersBadRequest(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/users?sort=password", nil)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+
+	uc := NewUserController(mocks.NewMockUserRepository())
+	uc.GetUsers(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "400 Bad Request", resp.Status)
+}
+
+func TestGetUsersInvalidCursor(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/users?cursor=garbage", nil)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+
+	uc := NewUserController(mocks.NewMockUserRepository())
+	uc.GetUsers(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "400 Bad Request", resp.Status)
+}
+
 func TestGetAllUsersNegativePath(t *testing.T) {
+	t.Parallel()
+
 	r := httptest.NewRequest(http.MethodGet, "/users", nil)
 	w := httptest.NewRecorder()
 	p := httprouter.Params{}
@@ -44,7 +147,24 @@ func TestGetAllUsersNegativePath(t *testing.T) {
 	assert.Equal(t, "503 Service Unavailable", resp.Status)
 }
 
+func TestGetAllUsersTimeout(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/users", nil)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+
+	uc := NewUserController(mocks.NewMockTimeoutUserRepository(), WithTimeout(10*time.Millisecond))
+	uc.GetUsers(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	assert.Equal(t, "504 Gateway Timeout", resp.Status)
+}
+
 func TestGetUserByID(t *testing.T) {
+	t.Parallel()
+
 	r := httptest.NewRequest(http.MethodGet, "/users/1", nil)
 	w := httptest.NewRecorder()
 	p := httprouter.Params{}
@@ -64,6 +184,8 @@ func TestGetUserByID(t *testing.T) {
 }
 
 func TestGetUserByIDNotFound(t *testing.T) {
+	t.Parallel()
+
 	r := httptest.NewRequest(http.MethodGet, "/users/1", nil)
 	w := httptest.NewRecorder()
 	p := httprouter.Params{}
@@ -76,11 +198,20 @@ func TestGetUserByIDNotFound(t *testing.T) {
 	uc.GetUserByID(w, r, p)
 	resp := w.Result()
 
+	var problem Problem
+	json.NewDecoder(resp.Body).Decode(&problem)
+	defer resp.Body.Close()
+
 	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
 	assert.Equal(t, "404 Not Found", resp.Status)
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, http.StatusNotFound, problem.Status)
+	assert.Equal(t, "/users/1", problem.Instance)
 }
 
 func TestGetUserByIDNegativePath(t *testing.T) {
+	t.Parallel()
+
 	r := httptest.NewRequest(http.MethodGet, "/users/1", nil)
 	w := httptest.NewRecorder()
 	p := httprouter.Params{}
@@ -97,7 +228,48 @@ func TestGetUserByIDNegativePath(t *testing.T) {
 	assert.Equal(t, "503 Service Unavailable", resp.Status)
 }
 
+func TestGetUserByIDTimeout(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+	p = append(p, httprouter.Param{
+		Key:   "id",
+		Value: "1",
+	})
+
+	uc := NewUserController(mocks.NewMockTimeoutUserRepository(), WithTimeout(10*time.Millisecond))
+	uc.GetUserByID(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	assert.Equal(t, "504 Gateway Timeout", resp.Status)
+}
+
+func TestGetUserByIDClientCanceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest(http.MethodGet, "/users/1", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+	p = append(p, httprouter.Param{
+		Key:   "id",
+		Value: "1",
+	})
+
+	uc := NewUserController(mocks.NewMockTimeoutUserRepository())
+	uc.GetUserByID(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
 func TestAddUser(t *testing.T) {
+	t.Parallel()
+
 	user := models.User{
 		Name:   "James Bond",
 		Gender: "male",
@@ -114,11 +286,45 @@ func TestAddUser(t *testing.T) {
 	uc.AddUser(w, r, p)
 	resp := w.Result()
 
+	var created models.User
+	json.NewDecoder(resp.Body).Decode(&created)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "201 Created", resp.Status)
+	assert.NotEmpty(t, created.ID)
+	assert.Equal(t, "/users/"+created.ID, resp.Header.Get("Location"))
+	assert.Equal(t, user.Name, created.Name)
+	assert.Equal(t, user.Gender, created.Gender)
+	assert.Equal(t, user.Age, created.Age)
+}
+
+func TestAddUserSeeOther(t *testing.T) {
+	t.Parallel()
+
+	user := models.User{
+		Name:   "James Bond",
+		Gender: "male",
+		Age:    44,
+	}
+
+	bs, _ := json.Marshal(&user)
+	r := httptest.NewRequest(http.MethodPost, "/users", bytes.NewReader(bs))
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+
+	uc := NewUserController(mocks.NewMockUserRepository(), WithSeeOtherOnCreate(true))
+	uc.AddUser(w, r, p)
+	resp := w.Result()
+
 	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
 	assert.Equal(t, "303 See Other", resp.Status)
+	assert.Regexp(t, "^/users/[0-9]+$", resp.Header.Get("Location"))
 }
 
 func TestAddUserBadRequest(t *testing.T) {
+	t.Parallel()
+
 	otherStruct := struct {
 		Sport      string
 		NumPlayers int
@@ -140,7 +346,37 @@ func TestAddUserBadRequest(t *testing.T) {
 	assert.Equal(t, "400 Bad Request", resp.Status)
 }
 
+func TestAddUserInvalid(t *testing.T) {
+	t.Parallel()
+
+	user := models.User{
+		Name:   "James Bond",
+		Gender: "male",
+		Age:    -5,
+	}
+
+	bs, _ := json.Marshal(&user)
+	r := httptest.NewRequest(http.MethodPost, "/users", bytes.NewReader(bs))
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+
+	ur := mocks.NewMockUserRepository()
+	uc := NewUserController(ur)
+	uc.AddUser(w, r, p)
+	resp := w.Result()
+
+	var problem Problem
+	json.NewDecoder(resp.Body).Decode(&problem)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, map[string]string{"age": "must be at least 0"}, problem.Errors)
+	assert.Empty(t, ur.Calls())
+}
+
 func TestAddUserNegativePath(t *testing.T) {
+	t.Parallel()
+
 	user := models.User{
 		Name:   "James Bond",
 		Gender: "male",
@@ -161,7 +397,82 @@ func TestAddUserNegativePath(t *testing.T) {
 	assert.Equal(t, "503 Service Unavailable", resp.Status)
 }
 
+func TestAddUserTimeout(t *testing.T) {
+	t.Parallel()
+
+	user := models.User{
+		Name:   "James Bond",
+		Gender: "male",
+		Age:    44,
+	}
+
+	bs, _ := json.Marshal(&user)
+	r := httptest.NewRequest(http.MethodPost, "/users", bytes.NewReader(bs))
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+
+	uc := NewUserController(mocks.NewMockTimeoutUserRepository(), WithTimeout(10*time.Millisecond))
+	uc.AddUser(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	assert.Equal(t, "504 Gateway Timeout", resp.Status)
+}
+
+func TestAddUserConflict(t *testing.T) {
+	t.Parallel()
+
+	user := models.User{
+		Name:   "James Bond",
+		Gender: "male",
+		Age:    44,
+	}
+
+	bs, _ := json.Marshal(&user)
+	r := httptest.NewRequest(http.MethodPost, "/users", bytes.NewReader(bs))
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+
+	uc := NewUserController(mocks.NewMockErroringUserRepositoryWithError(models.ConflictError{Message: "duplicate"}))
+	uc.AddUser(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, "409 Conflict", resp.Status)
+}
+
+func TestAddUserRejectedByRepository(t *testing.T) {
+	t.Parallel()
+
+	user := models.User{
+		Name:   "James Bond",
+		Gender: "male",
+		Age:    44,
+	}
+
+	bs, _ := json.Marshal(&user)
+	r := httptest.NewRequest(http.MethodPost, "/users", bytes.NewReader(bs))
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+
+	err := models.ValidationError{Message: "data too long", Fields: map[string]string{"name": "is too long"}}
+	uc := NewUserController(mocks.NewMockErroringUserRepositoryWithError(err))
+	uc.AddUser(w, r, p)
+	resp := w.Result()
+
+	var problem Problem
+	json.NewDecoder(resp.Body).Decode(&problem)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, "422 Unprocessable Entity", resp.Status)
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, map[string]string{"name": "is too long"}, problem.Errors)
+}
+
 func TestDeleteUser(t *testing.T) {
+	t.Parallel()
+
 	r := httptest.NewRequest(http.MethodDelete, "/users/1", nil)
 	w := httptest.NewRecorder()
 	p := httprouter.Params{}
@@ -169,15 +480,22 @@ func TestDeleteUser(t *testing.T) {
 		Key:   "id",
 		Value: "1",
 	})
-	uc := NewUserController(mocks.NewMockUserRepository())
+	ur := mocks.NewMockUserRepository()
+	uc := NewUserController(ur)
 	uc.DeleteUser(w, r, p)
 	resp := w.Result()
 
 	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
 	assert.Equal(t, "204 No Content", resp.Status)
+	assert.Len(t, ur.CallsTo("WithTx"), 1)
+	if deletes := ur.CallsTo("Delete"); assert.Len(t, deletes, 1) {
+		assert.Equal(t, "1", deletes[0].Args[0].(models.User).ID)
+	}
 }
 
 func TestDeleteUserNotFound(t *testing.T) {
+	t.Parallel()
+
 	r := httptest.NewRequest(http.MethodDelete, "/users/99", nil)
 	w := httptest.NewRecorder()
 	p := httprouter.Params{}
@@ -193,7 +511,28 @@ func TestDeleteUserNotFound(t *testing.T) {
 	assert.Equal(t, "404 Not Found", resp.Status)
 }
 
+func TestDeleteUserWrappedNotFound(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodDelete, "/users/99", nil)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+	p = append(p, httprouter.Param{
+		Key:   "id",
+		Value: "99",
+	})
+	err := fmt.Errorf("lookup failed: %w", models.UserNotFoundError{Message: "not found"})
+	uc := NewUserController(mocks.NewMockErroringUserRepositoryWithError(err))
+	uc.DeleteUser(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "404 Not Found", resp.Status)
+}
+
 func TestDeleteUserNegativePath(t *testing.T) {
+	t.Parallel()
+
 	r := httptest.NewRequest(http.MethodDelete, "/users/1", nil)
 	w := httptest.NewRecorder()
 	p := httprouter.Params{}
@@ -208,3 +547,724 @@ func TestDeleteUserNegativePath(t *testing.T) {
 	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
 	assert.Equal(t, "503 Service Unavailable", resp.Status)
 }
+
+func TestUpdateUser(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	id, _ := ur.Create(context.Background(), models.User{
+		Name:   "Felix Leiter",
+		Gender: "male",
+		Age:    40,
+	})
+
+	user := models.User{
+		Name:   "Felix Leiter",
+		Gender: "male",
+		Age:    41,
+	}
+
+	bs, _ := json.Marshal(&user)
+	r := httptest.NewRequest(http.MethodPut, "/users/"+id, bytes.NewReader(bs))
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+	p = append(p, httprouter.Param{
+		Key:   "id",
+		Value: id,
+	})
+
+	uc := NewUserController(ur)
+	uc.UpdateUser(w, r, p)
+	resp := w.Result()
+
+	bs, _ = ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "{\"name\":\"Felix Leiter\",\"gender\":\"male\",\"age\":41,\"id\":\""+id+"\"}\n", string(bs))
+
+	updated, _ := ur.GetByID(context.Background(), id)
+	assert.Equal(t, 41, updated.Age)
+}
+
+func TestUpdateUserBadRequest(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodPut, "/users/1", bytes.NewReader([]byte("{}")))
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+	p = append(p, httprouter.Param{
+		Key:   "id",
+		Value: "1",
+	})
+
+	uc := NewUserController(mocks.NewMockUserRepository())
+	uc.UpdateUser(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "400 Bad Request", resp.Status)
+}
+
+func TestUpdateUserInvalid(t *testing.T) {
+	t.Parallel()
+
+	user := models.User{
+		Name:   "James Bond",
+		Gender: "spy",
+		Age:    44,
+	}
+
+	bs, _ := json.Marshal(&user)
+	r := httptest.NewRequest(http.MethodPut, "/users/1", bytes.NewReader(bs))
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+	p = append(p, httprouter.Param{
+		Key:   "id",
+		Value: "1",
+	})
+
+	uc := NewUserController(mocks.NewMockUserRepository())
+	uc.UpdateUser(w, r, p)
+	resp := w.Result()
+
+	var problem Problem
+	json.NewDecoder(resp.Body).Decode(&problem)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Contains(t, problem.Errors, "gender")
+}
+
+func TestUpdateUserMismatchedID(t *testing.T) {
+	t.Parallel()
+
+	user := models.User{
+		Name:   "James Bond",
+		Gender: "male",
+		Age:    44,
+		ID:     "2",
+	}
+
+	bs, _ := json.Marshal(&user)
+	r := httptest.NewRequest(http.MethodPut, "/users/1", bytes.NewReader(bs))
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+	p = append(p, httprouter.Param{
+		Key:   "id",
+		Value: "1",
+	})
+
+	uc := NewUserController(mocks.NewMockUserRepository())
+	uc.UpdateUser(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "400 Bad Request", resp.Status)
+}
+
+func TestUpdateUserNotFound(t *testing.T) {
+	t.Parallel()
+
+	user := models.User{
+		Name:   "James Bond",
+		Gender: "male",
+		Age:    44,
+	}
+
+	bs, _ := json.Marshal(&user)
+	r := httptest.NewRequest(http.MethodPut, "/users/99", bytes.NewReader(bs))
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+	p = append(p, httprouter.Param{
+		Key:   "id",
+		Value: "99",
+	})
+
+	uc := NewUserController(mocks.NewMockUserRepository())
+	uc.UpdateUser(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "404 Not Found", resp.Status)
+}
+
+func TestUpdateUserNegativePath(t *testing.T) {
+	t.Parallel()
+
+	user := models.User{
+		Name:   "James Bond",
+		Gender: "male",
+		Age:    44,
+	}
+
+	bs, _ := json.Marshal(&user)
+	r := httptest.NewRequest(http.MethodPut, "/users/1", bytes.NewReader(bs))
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+	p = append(p, httprouter.Param{
+		Key:   "id",
+		Value: "1",
+	})
+
+	uc := NewUserController(mocks.NewMockErroringUserRepository())
+	uc.UpdateUser(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "503 Service Unavailable", resp.Status)
+}
+
+func TestPatchUser(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	id, _ := ur.Create(context.Background(), models.User{
+		Name:   "Vesper Lynd",
+		Gender: "female",
+		Age:    32,
+	})
+
+	r := httptest.NewRequest(http.MethodPatch, "/users/"+id, bytes.NewReader([]byte(`{"age":33}`)))
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+	p = append(p, httprouter.Param{
+		Key:   "id",
+		Value: id,
+	})
+
+	uc := NewUserController(ur)
+	uc.PatchUser(w, r, p)
+	resp := w.Result()
+
+	bs, _ := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "{\"name\":\"Vesper Lynd\",\"gender\":\"female\",\"age\":33,\"id\":\""+id+"\"}\n", string(bs))
+
+	user, _ := ur.GetByID(context.Background(), id)
+	assert.Equal(t, 33, user.Age)
+}
+
+func TestPatchUserUnsupportedMediaType(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodPatch, "/users/1", bytes.NewReader([]byte(`{"age":45}`)))
+	r.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+	p = append(p, httprouter.Param{
+		Key:   "id",
+		Value: "1",
+	})
+
+	uc := NewUserController(mocks.NewMockUserRepository())
+	uc.PatchUser(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+	assert.Equal(t, "415 Unsupported Media Type", resp.Status)
+}
+
+func TestPatchUserImmutableID(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	id, _ := ur.Create(context.Background(), models.User{
+		Name:   "Eve Moneypenny",
+		Gender: "female",
+		Age:    30,
+	})
+
+	r := httptest.NewRequest(http.MethodPatch, "/users/"+id, bytes.NewReader([]byte(`{"id":"777"}`)))
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+	p = append(p, httprouter.Param{
+		Key:   "id",
+		Value: id,
+	})
+
+	uc := NewUserController(ur)
+	uc.PatchUser(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "400 Bad Request", resp.Status)
+}
+
+func TestPatchUserInvalid(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	id, _ := ur.Create(context.Background(), models.User{
+		Name:   "Mr. White",
+		Gender: "male",
+		Age:    50,
+	})
+
+	r := httptest.NewRequest(http.MethodPatch, "/users/"+id, bytes.NewReader([]byte(`{"name":null}`)))
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+	p = append(p, httprouter.Param{
+		Key:   "id",
+		Value: id,
+	})
+
+	uc := NewUserController(ur)
+	uc.PatchUser(w, r, p)
+	resp := w.Result()
+
+	var problem Problem
+	json.NewDecoder(resp.Body).Decode(&problem)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, map[string]string{"name": "is required"}, problem.Errors)
+
+	user, _ := ur.GetByID(context.Background(), id)
+	assert.Equal(t, "Mr. White", user.Name)
+}
+
+func TestPatchUserNotFound(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodPatch, "/users/99", bytes.NewReader([]byte(`{"age":45}`)))
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+	p = append(p, httprouter.Param{
+		Key:   "id",
+		Value: "99",
+	})
+
+	uc := NewUserController(mocks.NewMockUserRepository())
+	uc.PatchUser(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "404 Not Found", resp.Status)
+}
+
+func TestPatchUserNegativePath(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodPatch, "/users/1", bytes.NewReader([]byte(`{"age":45}`)))
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	w := httptest.NewRecorder()
+	p := httprouter.Params{}
+	p = append(p, httprouter.Param{
+		Key:   "id",
+		Value: "1",
+	})
+
+	uc := NewUserController(mocks.NewMockErroringUserRepository())
+	uc.PatchUser(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "503 Service Unavailable", resp.Status)
+}
+
+func TestGetUserByIDETag(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{httprouter.Param{Key: "id", Value: "1"}}
+
+	uc := NewUserController(mocks.NewMockUserRepository())
+	uc.GetUserByID(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"1"`, resp.Header.Get("ETag"))
+}
+
+func TestGetUserByIDNotModified(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	r.Header.Set("If-None-Match", `"1"`)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{httprouter.Param{Key: "id", Value: "1"}}
+
+	uc := NewUserController(mocks.NewMockUserRepository())
+	uc.GetUserByID(w, r, p)
+	resp := w.Result()
+
+	bs, _ := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Equal(t, `"1"`, resp.Header.Get("ETag"))
+	assert.Empty(t, bs)
+}
+
+func TestGetUserByIDModified(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	ur.Update(context.Background(), models.User{ID: "1", Name: "James Bond", Gender: "male", Age: 45})
+
+	r := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	r.Header.Set("If-None-Match", `"1"`)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{httprouter.Param{Key: "id", Value: "1"}}
+
+	uc := NewUserController(ur)
+	uc.GetUserByID(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+}
+
+func TestAddUserETag(t *testing.T) {
+	t.Parallel()
+
+	bs, _ := json.Marshal(models.User{Name: "Q", Gender: "male", Age: 30})
+	r := httptest.NewRequest(http.MethodPost, "/users", bytes.NewReader(bs))
+	w := httptest.NewRecorder()
+
+	uc := NewUserController(mocks.NewMockUserRepository())
+	uc.AddUser(w, r, httprouter.Params{})
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, `"1"`, resp.Header.Get("ETag"))
+}
+
+func TestUpdateUserIfMatch(t *testing.T) {
+	t.Parallel()
+
+	bs, _ := json.Marshal(models.User{Name: "James Bond", Gender: "male", Age: 45})
+	r := httptest.NewRequest(http.MethodPut, "/users/1", bytes.NewReader(bs))
+	r.Header.Set("If-Match", `"1"`)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{httprouter.Param{Key: "id", Value: "1"}}
+
+	ur := mocks.NewMockUserRepository()
+	uc := NewUserController(ur)
+	uc.UpdateUser(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+	user, _ := ur.GetByID(context.Background(), "1")
+	assert.Equal(t, 45, user.Age)
+	assert.Equal(t, 2, user.Version)
+}
+
+func TestUpdateUserPreconditionFailed(t *testing.T) {
+	t.Parallel()
+
+	bs, _ := json.Marshal(models.User{Name: "James Bond", Gender: "male", Age: 45})
+	r := httptest.NewRequest(http.MethodPut, "/users/1", bytes.NewReader(bs))
+	r.Header.Set("If-Match", `"7"`)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{httprouter.Param{Key: "id", Value: "1"}}
+
+	ur := mocks.NewMockUserRepository()
+	uc := NewUserController(ur)
+	uc.UpdateUser(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	assert.Empty(t, ur.CallsTo("Update"))
+}
+
+func TestUpdateUserConcurrentModification(t *testing.T) {
+	t.Parallel()
+
+	err := fmt.Errorf("update failed: %w", models.VersionMismatchError{Message: "version mismatch"})
+	bs, _ := json.Marshal(models.User{Name: "James Bond", Gender: "male", Age: 45})
+	r := httptest.NewRequest(http.MethodPut, "/users/1", bytes.NewReader(bs))
+	w := httptest.NewRecorder()
+	p := httprouter.Params{httprouter.Param{Key: "id", Value: "1"}}
+
+	uc := NewUserController(mocks.NewMockErroringUserRepositoryWithError(err))
+	uc.UpdateUser(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+}
+
+func TestPatchUserIfMatch(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	p := httprouter.Params{httprouter.Param{Key: "id", Value: "1"}}
+	uc := NewUserController(ur)
+
+	r := httptest.NewRequest(http.MethodPatch, "/users/1", bytes.NewReader([]byte(`{"age":45}`)))
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	r.Header.Set("If-Match", `"1"`)
+	w := httptest.NewRecorder()
+	uc.PatchUser(w, r, p)
+	resp := w.Result()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+
+	r = httptest.NewRequest(http.MethodPatch, "/users/1", bytes.NewReader([]byte(`{"age":46}`)))
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	r.Header.Set("If-Match", `"1"`)
+	w = httptest.NewRecorder()
+	uc.PatchUser(w, r, p)
+	resp = w.Result()
+
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	user, _ := ur.GetByID(context.Background(), "1")
+	assert.Equal(t, 45, user.Age)
+}
+
+func TestDeleteUserIfMatch(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	p := httprouter.Params{httprouter.Param{Key: "id", Value: "1"}}
+	uc := NewUserController(ur)
+
+	r := httptest.NewRequest(http.MethodDelete, "/users/1", nil)
+	r.Header.Set("If-Match", `"2"`)
+	w := httptest.NewRecorder()
+	uc.DeleteUser(w, r, p)
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Result().StatusCode)
+	assert.Empty(t, ur.CallsTo("Delete"))
+
+	r = httptest.NewRequest(http.MethodDelete, "/users/1", nil)
+	r.Header.Set("If-Match", `"1"`)
+	w = httptest.NewRecorder()
+	uc.DeleteUser(w, r, p)
+
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+}
+
+// deletedUserRepository returns a mock repository in which James Bond, ID
+// "1", has been soft deleted and is at version 2
+func deletedUserRepository(t *testing.T) *mocks.MockUserRepository {
+	ur := mocks.NewMockUserRepository()
+	if err := ur.Delete(context.Background(), models.User{ID: "1"}); err != nil {
+		t.Fatalf("unable to delete user due to: %v", err)
+	}
+	return ur
+}
+
+func TestGetUserByIDDeleted(t *testing.T) {
+	t.Parallel()
+
+	ur := deletedUserRepository(t)
+	p := httprouter.Params{httprouter.Param{Key: "id", Value: "1"}}
+	uc := NewUserController(ur)
+
+	w := httptest.NewRecorder()
+	uc.GetUserByID(w, httptest.NewRequest(http.MethodGet, "/users/1", nil), p)
+
+	assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+
+	w = httptest.NewRecorder()
+	uc.GetUserByID(w, httptest.NewRequest(http.MethodGet, "/users/1?include_deleted=true", nil), p)
+	resp := w.Result()
+
+	bs, _ := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+
+	var user models.User
+	json.Unmarshal(bs, &user)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+	assert.NotNil(t, user.DeletedAt)
+}
+
+func TestGetUserByIDIncludeDeletedBadRequest(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/users/1?include_deleted=maybe", nil)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{httprouter.Param{Key: "id", Value: "1"}}
+
+	uc := NewUserController(mocks.NewMockUserRepository())
+	uc.GetUserByID(w, r, p)
+
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
+
+func TestGetUsersIncludeDeleted(t *testing.T) {
+	t.Parallel()
+
+	uc := NewUserController(deletedUserRepository(t))
+
+	w := httptest.NewRecorder()
+	uc.GetUsers(w, httptest.NewRequest(http.MethodGet, "/users", nil), httprouter.Params{})
+	bs, _ := ioutil.ReadAll(w.Result().Body)
+
+	assert.Equal(t, "[]\n", string(bs))
+
+	w = httptest.NewRecorder()
+	uc.GetUsers(w, httptest.NewRequest(http.MethodGet, "/users?include_deleted=true", nil), httprouter.Params{})
+	bs, _ = ioutil.ReadAll(w.Result().Body)
+
+	var users []models.User
+	json.Unmarshal(bs, &users)
+	if assert.Len(t, users, 1) {
+		assert.NotNil(t, users[0].DeletedAt)
+	}
+}
+
+func TestRestoreUser(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodPost, "/users/1/restore", nil)
+	r.Header.Set("If-Match", `"2"`)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{httprouter.Param{Key: "id", Value: "1"}}
+
+	ur := deletedUserRepository(t)
+	uc := NewUserController(ur)
+	uc.RestoreUser(w, r, p)
+	resp := w.Result()
+
+	bs, _ := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"3"`, resp.Header.Get("ETag"))
+	assert.Equal(t, "{\"name\":\"James Bond\",\"gender\":\"male\",\"age\":44,\"id\":\"1\"}\n", string(bs))
+	_, err := ur.GetByID(context.Background(), "1")
+	assert.Nil(t, err)
+}
+
+func TestRestoreUserFailures(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		id       string
+		deleted  bool
+		ifMatch  string
+		expected int
+	}{
+		"not found":           {"99", true, "", http.StatusNotFound},
+		"not deleted":         {"1", false, "", http.StatusConflict},
+		"precondition failed": {"1", true, `"1"`, http.StatusPreconditionFailed},
+	}
+	for name, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/users/"+tt.id+"/restore", nil)
+		if tt.ifMatch != "" {
+			r.Header.Set("If-Match", tt.ifMatch)
+		}
+		w := httptest.NewRecorder()
+		p := httprouter.Params{httprouter.Param{Key: "id", Value: tt.id}}
+
+		ur := mocks.NewMockUserRepository()
+		if tt.deleted {
+			ur = deletedUserRepository(t)
+		}
+		uc := NewUserController(ur)
+		uc.RestoreUser(w, r, p)
+
+		assert.Equal(t, tt.expected, w.Result().StatusCode, name)
+	}
+}
+
+func TestGetUserHistory(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	uc := NewUserController(ur)
+	update := httptest.NewRequest(http.MethodPut, "/users/1", bytes.NewBufferString(`{"name":"James Bond","gender":"male","age":45}`))
+	update.Header.Set("X-Actor", "M")
+	RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uc.UpdateUser(w, r, httprouter.Params{httprouter.Param{Key: "id", Value: "1"}})
+	})).ServeHTTP(httptest.NewRecorder(), update)
+
+	r := httptest.NewRequest(http.MethodGet, "/users/1/history?limit=1", nil)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{httprouter.Param{Key: "id", Value: "1"}}
+
+	uc.GetUserHistory(w, r, p)
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	var entries []models.AuditEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		t.Fatalf("unable to decode history due to: %v", err)
+	}
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("X-Total-Count"))
+	assert.Equal(t, `</users/1/history?limit=1&offset=0>; rel="first", </users/1/history?limit=1&offset=0>; rel="last"`, resp.Header.Get("Link"))
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, models.AuditUpdate, entries[0].Operation)
+		assert.Equal(t, "M", entries[0].Actor)
+		assert.NotEmpty(t, entries[0].RequestID)
+		assert.Equal(t, 44, entries[0].Before.Age)
+		assert.Equal(t, 45, entries[0].After.Age)
+	}
+	assert.Equal(t, []interface{}{"1", repository.HistoryOptions{Limit: 1}}, ur.CallsTo("History")[0].Args)
+}
+
+func TestGetUserHistoryAnonymous(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	uc := NewUserController(ur)
+	uc.DeleteUser(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/users/1", nil),
+		httprouter.Params{httprouter.Param{Key: "id", Value: "1"}})
+
+	page, err := ur.History(context.Background(), "1", repository.HistoryOptions{})
+
+	assert.Nil(t, err)
+	if assert.Len(t, page.Entries, 1) {
+		assert.Equal(t, DefaultActor, page.Entries[0].Actor)
+	}
+}
+
+func TestGetUserHistoryWithoutEntries(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/users/1/history", nil)
+	w := httptest.NewRecorder()
+	p := httprouter.Params{httprouter.Param{Key: "id", Value: "1"}}
+
+	uc := NewUserController(mocks.NewMockUserRepository())
+	uc.GetUserHistory(w, r, p)
+	resp := w.Result()
+
+	bs, _ := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get("X-Total-Count"))
+	assert.Equal(t, "[]\n", string(bs))
+}
+
+func TestGetUserHistoryFailures(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		path     string
+		ur       repository.UserRepository
+		expected int
+	}{
+		"not found":   {"/users/99/history", mocks.NewMockUserRepository(), http.StatusNotFound},
+		"bad limit":   {"/users/1/history?limit=0", mocks.NewMockUserRepository(), http.StatusBadRequest},
+		"unavailable": {"/users/1/history", mocks.NewMockErroringUserRepository(), http.StatusServiceUnavailable},
+	}
+	for name, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		w := httptest.NewRecorder()
+		p := httprouter.Params{httprouter.Param{Key: "id", Value: strings.Split(tt.path, "/")[2]}}
+
+		uc := NewUserController(tt.ur)
+		uc.GetUserHistory(w, r, p)
+
+		assert.Equal(t, tt.expected, w.Result().StatusCode, name)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+	"github.com/ChrisTheShark/golang-mysql-api/transfer"
+)
+
+const exportUsage = "usage: export [csv|ndjson], e.g. export csv > users.csv"
+
+// exportFormats maps the formats accepted by the export subcommand onto
+// their media types
+var exportFormats = map[string]string{
+	"csv":    transfer.CSV,
+	"ndjson": transfer.NDJSON,
+}
+
+// export runs the export subcommand, streaming every user to out as CSV or
+// newline delimited JSON
+func export(store *storage, args []string, out io.Writer) error {
+	format := transfer.CSV
+	switch len(args) {
+	case 0:
+	case 1:
+		var ok bool
+		if format, ok = exportFormats[args[0]]; !ok {
+			return fmt.Errorf("unsupported format %q\n%s", args[0], exportUsage)
+		}
+	default:
+		return errors.New(exportUsage)
+	}
+
+	_, err := transfer.Export(context.Background(), store.users, repository.ListOptions{}, out, format)
+	return err
+}
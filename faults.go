@@ -0,0 +1,30 @@
+//go:build chaos
+// +build chaos
+
+package main
+
+import (
+	"log"
+
+	"github.com/ChrisTheShark/golang-mysql-api/controllers"
+	"github.com/ChrisTheShark/golang-mysql-api/logging"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+	"github.com/ChrisTheShark/golang-mysql-api/repository/chaos"
+)
+
+// withFaults wraps the repository in a chaos.FaultyUserRepository, injecting
+// nothing until faults are configured through /admin/faults. It is only
+// compiled into builds made with the chaos tag and must never reach
+// production.
+func withFaults(users repository.UserRepository, r routes) repository.UserRepository {
+	faults, err := chaos.NewFaultyUserRepository(users, chaos.Config{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fc := controllers.NewFaultController(faults)
+	r.GET("/admin/faults", fc.GetFaults)
+	r.PUT("/admin/faults", fc.SetFaults)
+	r.DELETE("/admin/faults", fc.ClearFaults)
+	logging.Warnf("fault injection is enabled, configure it at /admin/faults")
+	return faults
+}
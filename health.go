@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/ChrisTheShark/golang-mysql-api/controllers"
+	"github.com/ChrisTheShark/golang-mysql-api/migrations"
+)
+
+// healthChecks lists the dependencies readiness checks for a storage
+// backend; backends that are not SQL databases have none
+func healthChecks(store *storage) ([]controllers.Check, error) {
+	if store.db == nil {
+		return nil, nil
+	}
+	m, err := migrations.New(store.db, *store.dialect)
+	if err != nil {
+		return nil, err
+	}
+	return []controllers.Check{
+		{Name: "pool", Run: poolCheck(store.db)},
+		{Name: "database", Run: store.db.PingContext},
+		{Name: "migrations", Run: migrationsCheck(m)},
+	}, nil
+}
+
+// poolCheck fails while the connection pool is saturated: every connection
+// it may open is in use and callers have had to wait for one since the
+// previous check
+func poolCheck(db *sql.DB) func(context.Context) error {
+	var waited int64
+	return func(context.Context) error {
+		stats := db.Stats()
+		previous := atomic.SwapInt64(&waited, stats.WaitCount)
+		if stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections && stats.WaitCount > previous {
+			return fmt.Errorf("all %d connections are in use and %d callers waited for one", stats.InUse, stats.WaitCount-previous)
+		}
+		return nil
+	}
+}
+
+// migrationsCheck fails while the schema lacks migrations known to the
+// binary, as after a deploy that skipped migrate up
+func migrationsCheck(m *migrations.Migrator) func(context.Context) error {
+	return func(ctx context.Context) error {
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		pending := 0
+		for _, s := range statuses {
+			if !s.Applied {
+				pending++
+			}
+		}
+		if pending > 0 {
+			return fmt.Errorf("%d of %d migrations are pending, run migrate up", pending, len(statuses))
+		}
+		return nil
+	}
+}
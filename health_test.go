@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ChrisTheShark/golang-mysql-api/controllers"
+	"github.com/ChrisTheShark/golang-mysql-api/migrations"
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+// getReadiness answers a readiness probe running the health checks of store
+func getReadiness(t *testing.T, store *storage) controllers.Health {
+	checks, err := healthChecks(store)
+	if err != nil {
+		t.Fatalf("unable to create health checks due to: %v", err)
+	}
+	h := controllers.NewHealthController(controllers.DefaultCheckTimeout, checks...)
+	w := httptest.NewRecorder()
+	h.GetReadiness(w, httptest.NewRequest(http.MethodGet, "/readyz", nil), httprouter.Params{})
+
+	var health controllers.Health
+	json.NewDecoder(w.Body).Decode(&health)
+	return health
+}
+
+func newMockStorage(t *testing.T) (*storage, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unable to create mock DB object: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &storage{db: db, dialect: &migrations.MySQL}, mock
+}
+
+// TestReadinessIssuesNoDDL relies on sqlmock failing every statement it was
+// not told to expect, such as a create table
+func TestReadinessIssuesNoDDL(t *testing.T) {
+	store, mock := newMockStorage(t)
+	latest, err := migrations.Load(migrations.MySQL)
+	if err != nil {
+		t.Fatalf("unable to load migrations due to: %v", err)
+	}
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, migration := range latest {
+		rows.AddRow(migration.Version, "2019-01-02 03:04:05")
+	}
+	mock.ExpectQuery("select count\\(\\*\\) from information_schema.tables").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("select version, applied_at from schema_migrations").WillReturnRows(rows)
+
+	health := getReadiness(t, store)
+
+	assert.Equal(t, controllers.StatusOK, health.Status, health)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestReadinessWithoutSchemaMigrations(t *testing.T) {
+	store, mock := newMockStorage(t)
+	mock.ExpectQuery("select count\\(\\*\\) from information_schema.tables").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	health := getReadiness(t, store)
+
+	assert.Equal(t, controllers.StatusFailing, health.Status)
+	assert.Contains(t, health.Checks["migrations"].Error, "migrations are pending")
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
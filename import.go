@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+	"github.com/ChrisTheShark/golang-mysql-api/transfer"
+)
+
+const importUsage = "usage: import [-dry-run] [-map FIELD=HEADER]... FILE, where FILE is a CSV or - for standard input"
+
+// columnMapping collects the -map flags of the import subcommand
+type columnMapping map[string]string
+
+func (m columnMapping) String() string {
+	return fmt.Sprint(map[string]string(m))
+}
+
+func (m columnMapping) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i <= 0 || i == len(v)-1 {
+		return fmt.Errorf("invalid mapping %q, expected FIELD=HEADER", v)
+	}
+	m[v[:i]] = v[i+1:]
+	return nil
+}
+
+// importUsers runs the import subcommand, creating the users held by a CSV
+// or, when any row is invalid, none of them
+func importUsers(store *storage, args []string, stdin io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	opts := transfer.Options{Columns: columnMapping{}}
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "validate the rows without importing them")
+	flags.Var(columnMapping(opts.Columns), "map", "read FIELD from the column headed HEADER")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%v\n%s", err, importUsage)
+	}
+	if flags.NArg() != 1 {
+		return errors.New(importUsage)
+	}
+
+	in := stdin
+	if path := flags.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	ctx := repository.WithActor(context.Background(), cliActor)
+	report, err := transfer.Import(ctx, store.users, in, opts)
+	if err != nil {
+		return err
+	}
+	if len(report.Errors) > 0 {
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ROW\tFIELD\tMESSAGE")
+		for _, e := range report.Errors {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", e.Row, e.Field, e.Message)
+		}
+		w.Flush()
+		return fmt.Errorf("rejected %d of %d rows, nothing was imported", rejectedRows(report), report.Rows)
+	}
+	if report.DryRun {
+		fmt.Fprintf(out, "all %d rows are valid, nothing was imported\n", report.Rows)
+		return nil
+	}
+	fmt.Fprintf(out, "imported %d users\n", report.Imported)
+	return nil
+}
+
+// rejectedRows counts the rows with at least one error
+func rejectedRows(report *transfer.Report) int {
+	rows := map[int]bool{}
+	for _, e := range report.Errors {
+		rows[e.Row] = true
+	}
+	return len(rows)
+}
@@ -0,0 +1,79 @@
+// Package logging writes leveled log lines through the standard logger,
+// dropping those below the configured level
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Level orders log lines by severity
+type Level int32
+
+// Levels from the most to the least verbose
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses the name of a level: debug, info, warn or error
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("unknown log level %q, expected one of %s", name, strings.Join(levelNames, ", "))
+}
+
+var level = int32(Info)
+
+// SetLevel drops the log lines below l from then on
+func SetLevel(l Level) {
+	atomic.StoreInt32(&level, int32(l))
+}
+
+// Enabled reports whether lines of level l are written
+func Enabled(l Level) bool {
+	return int32(l) >= atomic.LoadInt32(&level)
+}
+
+func output(l Level, format string, v ...interface{}) {
+	if !Enabled(l) {
+		return
+	}
+	log.Output(3, strings.ToUpper(l.String())+" "+fmt.Sprintf(format, v...))
+}
+
+// Debugf logs a line describing the inner workings of the service
+func Debugf(format string, v ...interface{}) {
+	output(Debug, format, v...)
+}
+
+// Infof logs a line describing normal operation
+func Infof(format string, v ...interface{}) {
+	output(Info, format, v...)
+}
+
+// Warnf logs a line describing something unusual that the service copes with
+func Warnf(format string, v ...interface{}) {
+	output(Warn, format, v...)
+}
+
+// Errorf logs a line describing a failure
+func Errorf(format string, v ...interface{}) {
+	output(Error, format, v...)
+}
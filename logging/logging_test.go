@@ -0,0 +1,38 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLevel(t *testing.T) {
+	l, err := ParseLevel("WARN")
+	assert.Nil(t, err)
+	assert.Equal(t, Warn, l)
+
+	_, err = ParseLevel("verbose")
+	assert.NotNil(t, err)
+}
+
+func TestLevelsBelowTheConfiguredOneAreDropped(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+		SetLevel(Info)
+	}()
+
+	SetLevel(Warn)
+	Infof("dropped")
+	Warnf("kept %d", 1)
+	Errorf("kept %d", 2)
+
+	assert.Equal(t, []string{"WARN kept 1", "ERROR kept 2"}, strings.Split(strings.TrimSpace(buf.String()), "\n"))
+}
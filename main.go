@@ -1,35 +1,148 @@
 package main
 
 import (
-	"database/sql"
+	"bytes"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
-	"github.com/ChrisTheShark/golang-mysql-api/repository"
-
+	"github.com/ChrisTheShark/golang-mysql-api/config"
 	"github.com/ChrisTheShark/golang-mysql-api/controllers"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/ChrisTheShark/golang-mysql-api/logging"
+	"github.com/ChrisTheShark/golang-mysql-api/repository/instrument"
 	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
-	r := httprouter.New()
+	cfg, args, err := config.Load(os.Args[1:], os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	level, _ := logging.ParseLevel(cfg.Log.Level)
+	logging.SetLevel(level)
+
+	if len(args) > 0 && args[0] == "config" {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	backend, dsn, err := getStorage(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, err := openStorage(backend, dsn, cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := migrate(store, args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(args) > 0 && args[0] == "purge" {
+		if err := purge(store, args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(args) > 0 && args[0] == "export" {
+		if err := export(store, args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(args) > 0 && args[0] == "import" {
+		if err := importUsers(store, args[1:], os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	router := httprouter.New()
+	router.NotFound = http.HandlerFunc(controllers.NotFound)
+	router.MethodNotAllowed = http.HandlerFunc(controllers.MethodNotAllowed)
+	router.PanicHandler = controllers.Panic
+	r := routes{router}
 
-	ur := repository.NewUserRepository(getDatabase())
-	uc := controllers.NewUserController(ur)
+	if store.db != nil {
+		registerDBStats(prometheus.DefaultRegisterer, store.db)
+	}
+	instrumented := instrument.NewInstrumentedUserRepository(withFaults(store.users, r), prometheus.DefaultRegisterer)
+	users, err := withCache(instrumented, r, cfg.Cache)
+	if err != nil {
+		log.Fatal(err)
+	}
+	uc := controllers.NewUserController(users,
+		controllers.WithTimeout(time.Duration(cfg.Server.RequestTimeout)),
+		controllers.WithSeeOtherOnCreate(cfg.Server.CreateRedirect))
 
 	r.GET("/users", uc.GetUsers)
 	r.POST("/users", uc.AddUser)
 	r.GET("/users/:id", uc.GetUserByID)
+	r.PUT("/users/:id", uc.UpdateUser)
+	r.PATCH("/users/:id", uc.PatchUser)
 	r.DELETE("/users/:id", uc.DeleteUser)
-	http.ListenAndServe(":8080", r)
-}
+	r.POST("/users/:id/restore", uc.RestoreUser)
+	r.GET("/users/:id/history", uc.GetUserHistory)
 
-func getDatabase() *sql.DB {
-	db, err := sql.Open("mysql", os.Getenv("MYSQL_HOST"))
+	checks, err := healthChecks(store)
 	if err != nil {
-		log.Panic(err)
+		log.Fatal(err)
+	}
+	health := controllers.NewHealthController(time.Duration(cfg.Server.CheckTimeout), checks...)
+	r.GET("/healthz", health.GetLiveness)
+	r.GET("/readyz", health.GetReadiness)
+	metrics := promhttp.Handler()
+	r.GET("/metrics", func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		metrics.ServeHTTP(w, req)
+	})
+	httpMetrics := controllers.NewHTTPMetrics(prometheus.DefaultRegisterer)
+
+	server := &http.Server{
+		Addr:         cfg.Server.Addr,
+		Handler:      controllers.RequestID(controllers.LogRequests(httpMetrics.Middleware(uc.BatchRoutes(uc.ImportRoutes(router))))),
+		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.Server.WriteTimeout),
+		IdleTimeout:  time.Duration(cfg.Server.IdleTimeout),
+	}
+	var effective bytes.Buffer
+	cfg.Print(&effective)
+	logging.Infof("effective configuration:\n%s", effective.String())
+
+	if err := serve(server, cfg.Server, health); err != nil {
+		log.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		log.Fatal(err)
+	}
+	logging.Infof("shut down")
+}
+
+// getStorage selects the storage backend by the scheme of the database URL
+// when it is set, falling back to the configured storage otherwise
+func getStorage(db config.Database) (string, string, error) {
+	if db.URL != "" {
+		return parseDatabaseURL(db.URL)
+	}
+	switch db.Storage {
+	case "sqlite":
+		return db.Storage, db.SQLitePath, nil
+	case "postgres":
+		// An empty DSN leaves the driver to the standard PGHOST, PGUSER,
+		// PGDATABASE, ... environment variables.
+		return db.Storage, "", nil
 	}
-	return db
+	return db.Storage, db.MySQLDSN, nil
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/ChrisTheShark/golang-mysql-api/controllers"
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// routes registers handles with a router, labelling the requests each one
+// serves with its path pattern for the HTTP metrics
+type routes struct {
+	*httprouter.Router
+}
+
+func (rs routes) GET(path string, h httprouter.Handle) {
+	rs.Handle(http.MethodGet, path, controllers.Route(path, h))
+}
+
+func (rs routes) POST(path string, h httprouter.Handle) {
+	rs.Handle(http.MethodPost, path, controllers.Route(path, h))
+}
+
+func (rs routes) PUT(path string, h httprouter.Handle) {
+	rs.Handle(http.MethodPut, path, controllers.Route(path, h))
+}
+
+func (rs routes) PATCH(path string, h httprouter.Handle) {
+	rs.Handle(http.MethodPatch, path, controllers.Route(path, h))
+}
+
+func (rs routes) DELETE(path string, h httprouter.Handle) {
+	rs.Handle(http.MethodDelete, path, controllers.Route(path, h))
+}
+
+// registerDBStats exposes the statistics of the connection pool of db, read
+// on every scrape
+func registerDBStats(registerer prometheus.Registerer, db *sql.DB) {
+	gauge := func(name, help string, fn func(sql.DBStats) float64) {
+		registerer.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help},
+			func() float64 { return fn(db.Stats()) }))
+	}
+	counter := func(name, help string, fn func(sql.DBStats) float64) {
+		registerer.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help},
+			func() float64 { return fn(db.Stats()) }))
+	}
+	gauge("db_max_open_connections", "Most connections the pool may open, zero for no limit.",
+		func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	gauge("db_open_connections", "Connections open, in use or idle.",
+		func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	gauge("db_in_use_connections", "Connections in use.",
+		func(s sql.DBStats) float64 { return float64(s.InUse) })
+	gauge("db_idle_connections", "Idle connections.",
+		func(s sql.DBStats) float64 { return float64(s.Idle) })
+	counter("db_wait_count_total", "Times a caller waited for a connection.",
+		func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	counter("db_wait_duration_seconds_total", "Time spent waiting for a connection.",
+		func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+	counter("db_max_idle_closed_total", "Connections closed because the pool held too many idle ones.",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) })
+	counter("db_max_idle_time_closed_total", "Connections closed for having been idle too long.",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) })
+	counter("db_max_lifetime_closed_total", "Connections closed for having been open too long.",
+		func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) })
+}
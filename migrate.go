@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/ChrisTheShark/golang-mysql-api/migrations"
+)
+
+const migrateUsage = "usage: migrate up|down|status|to N"
+
+// migrate runs the migrate subcommand against a storage backend
+func migrate(store *storage, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	if store.db == nil {
+		return errors.New("the storage backend does not support migrations")
+	}
+	m, err := migrations.New(store.db, *store.dialect)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		err = m.Up(ctx)
+	case args[0] == "down" && len(args) == 1:
+		err = m.Down(ctx)
+	case args[0] == "to" && len(args) == 2:
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil || version < 0 {
+			return fmt.Errorf("invalid version %q\n%s", args[1], migrateUsage)
+		}
+		err = m.To(ctx, version)
+	case args[0] == "status" && len(args) == 1:
+		// Status is reported below, after every other command too.
+	default:
+		return errors.New(migrateUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+	for _, s := range statuses {
+		status := "pending"
+		if s.Applied {
+			status = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, status)
+	}
+	return w.Flush()
+}
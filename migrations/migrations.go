@@ -0,0 +1,469 @@
+// Package migrations evolves the database schema through versioned up and
+// down migrations embedded in the binary. Applied versions are recorded in a
+// schema_migrations table and a database level lock keeps concurrent
+// migrators from interleaving.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// LockTimeout bounds how long a Migrator waits for another migrator to
+// release the migration lock
+const LockTimeout = 30 * time.Second
+
+// Migration is a single versioned schema change. A migration without a down
+// script, such as the baseline adopting a users table created before
+// migrations existed, cannot be reverted.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status reports whether a migration has been applied and when
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Dialect holds the SQL a Migrator needs to track and lock migrations on a
+// particular database
+type Dialect struct {
+	// Name is also the directory holding the dialect's migration files
+	Name           string
+	createTable    string
+	tableExists    string
+	selectVersions string
+	insertVersion  string
+	deleteVersion  string
+	lock           func(context.Context, *sql.Conn) error
+	unlock         func(context.Context, *sql.Conn) error
+}
+
+// MySQL tracks migrations in MySQL, serializing migrators with a named lock
+var MySQL = Dialect{
+	Name: "mysql",
+	createTable: `create table if not exists schema_migrations (
+		version bigint not null primary key,
+		name varchar(255) not null,
+		applied_at timestamp not null default current_timestamp
+	)`,
+	tableExists:    "select count(*) from information_schema.tables where table_schema = database() and table_name = 'schema_migrations'",
+	selectVersions: "select version, applied_at from schema_migrations",
+	insertVersion:  "insert into schema_migrations (version, name) values (?, ?)",
+	deleteVersion:  "delete from schema_migrations where version = ?",
+	lock: func(ctx context.Context, conn *sql.Conn) error {
+		var acquired sql.NullInt64
+		row := conn.QueryRowContext(ctx, "select get_lock('schema_migrations', ?)", int(LockTimeout.Seconds()))
+		if err := row.Scan(&acquired); err != nil {
+			return err
+		}
+		if acquired.Int64 != 1 {
+			return fmt.Errorf("timed out after %v waiting for another migrator", LockTimeout)
+		}
+		return nil
+	},
+	unlock: func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, "select release_lock('schema_migrations')")
+		return err
+	},
+}
+
+// postgresLockKey identifies the advisory lock serializing Postgres
+// migrators
+const postgresLockKey = 7307102
+
+// Postgres tracks migrations in PostgreSQL, serializing migrators with an
+// advisory lock. Postgres runs DDL transactionally, so a failed migration
+// leaves no partial changes behind.
+var Postgres = Dialect{
+	Name: "postgres",
+	createTable: `create table if not exists schema_migrations (
+		version bigint not null primary key,
+		name varchar(255) not null,
+		applied_at timestamptz not null default now()
+	)`,
+	tableExists:    "select count(*) from information_schema.tables where table_schema = current_schema() and table_name = 'schema_migrations'",
+	selectVersions: "select version, applied_at from schema_migrations",
+	insertVersion:  "insert into schema_migrations (version, name) values ($1, $2)",
+	deleteVersion:  "delete from schema_migrations where version = $1",
+	lock: func(ctx context.Context, conn *sql.Conn) error {
+		// pg_advisory_lock waits indefinitely, so the lock is polled instead
+		// to honor LockTimeout.
+		deadline := time.Now().Add(LockTimeout)
+		for {
+			var acquired bool
+			row := conn.QueryRowContext(ctx, "select pg_try_advisory_lock($1)", postgresLockKey)
+			if err := row.Scan(&acquired); err != nil {
+				return err
+			}
+			if acquired {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("timed out after %v waiting for another migrator", LockTimeout)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+		}
+	},
+	unlock: func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, "select pg_advisory_unlock($1)", postgresLockKey)
+		return err
+	},
+}
+
+// SQLite tracks migrations in SQLite. SQLite has no session level locks; a
+// second migrator racing to apply the same migration fails on the
+// schema_migrations primary key when it commits instead.
+var SQLite = Dialect{
+	Name: "sqlite",
+	createTable: `create table if not exists schema_migrations (
+		version integer not null primary key,
+		name varchar(255) not null,
+		applied_at timestamp not null default current_timestamp
+	)`,
+	tableExists:    "select count(*) from sqlite_master where type = 'table' and name = 'schema_migrations'",
+	selectVersions: "select version, applied_at from schema_migrations",
+	insertVersion:  "insert into schema_migrations (version, name) values (?, ?)",
+	deleteVersion:  "delete from schema_migrations where version = ?",
+	lock:           func(context.Context, *sql.Conn) error { return nil },
+	unlock:         func(context.Context, *sql.Conn) error { return nil },
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load returns the migrations embedded for a dialect ordered by version
+func Load(d Dialect) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, d.Name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load %s migrations due to: %w", d.Name, err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		m := fileName.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unable to load migration with malformed name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		bs, err := files.ReadFile(path.Join(d.Name, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("unable to load migration %q due to: %w", entry.Name(), err)
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != m[2] {
+			return nil, fmt.Errorf("unable to load migration %d, it has conflicting names %q and %q", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(bs)
+		} else {
+			migration.Down = string(bs)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("unable to load migration %d, it has no up script", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Migrator applies and reverts migrations against a database
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+// New is a convenience function to create a Migrator for the migrations
+// embedded for a dialect
+func New(db *sql.DB, d Dialect) (*Migrator, error) {
+	migrations, err := Load(d)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db, d, migrations}, nil
+}
+
+// Latest returns the version of the newest known migration
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status lists every known migration and whether it has been applied. It
+// only reads the database, reporting every migration as pending when the
+// schema_migrations table has not been created yet.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.withConn(ctx, false, func(conn *sql.Conn) error {
+		applied, err := m.appliedIfTracked(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			at, ok := applied[migration.Version]
+			statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: at})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// Version returns the highest applied migration version, or zero when no
+// migration has been applied. Like Status it only reads the database.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	version := 0
+	err := m.withConn(ctx, false, func(conn *sql.Conn) error {
+		applied, err := m.appliedIfTracked(ctx, conn)
+		for v := range applied {
+			if v > version {
+				version = v
+			}
+		}
+		return err
+	})
+	return version, err
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, m.Latest())
+}
+
+// Down reverts the most recently applied migration
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.withConn(ctx, true, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			if _, ok := applied[m.migrations[i].Version]; ok {
+				return m.revert(ctx, conn, m.migrations[i])
+			}
+		}
+		return nil
+	})
+}
+
+// To applies or reverts migrations until version is the latest applied one.
+// Version zero reverts every migration. Nothing is reverted when any of the
+// migrations to revert cannot be.
+func (m *Migrator) To(ctx context.Context, version int) error {
+	if version != 0 && m.find(version) < 0 {
+		return fmt.Errorf("unable to migrate to unknown version %d", version)
+	}
+	return m.withConn(ctx, true, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok && migration.Version > version && migration.Down == "" {
+				return fmt.Errorf("unable to migrate to version %d, migration %d has no down script", version, migration.Version)
+			}
+		}
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; ok && migration.Version > version {
+				if err := m.revert(ctx, conn, migration); err != nil {
+					return err
+				}
+			}
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+				if err := m.apply(ctx, conn, migration); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (m *Migrator) find(version int) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// withConn runs fn on a single connection, so session scoped locks remain
+// held throughout. When lock is set, fn is about to migrate, so the tracking
+// table is created first; otherwise fn must only read.
+func (m *Migrator) withConn(ctx context.Context, lock bool, fn func(*sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to connect due to: %w", err)
+	}
+	defer conn.Close()
+
+	if lock {
+		if err := m.dialect.lock(ctx, conn); err != nil {
+			return fmt.Errorf("unable to acquire migration lock due to: %w", err)
+		}
+		// Release with a fresh context so the lock is freed even when ctx
+		// has been canceled.
+		defer m.dialect.unlock(context.Background(), conn)
+
+		if _, err := conn.ExecContext(ctx, m.dialect.createTable); err != nil {
+			return fmt.Errorf("unable to create schema_migrations due to: %w", err)
+		}
+	}
+	return fn(conn)
+}
+
+// appliedIfTracked returns the applied versions like applied, or none when
+// the schema_migrations table does not exist
+func (m *Migrator) appliedIfTracked(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	var tables int
+	if err := conn.QueryRowContext(ctx, m.dialect.tableExists).Scan(&tables); err != nil {
+		return nil, fmt.Errorf("unable to look up schema_migrations due to: %w", err)
+	}
+	if tables == 0 {
+		return map[int]time.Time{}, nil
+	}
+	return m.applied(ctx, conn)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, m.dialect.selectVersions)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read schema_migrations due to: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at timestamp
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("unable to read schema_migrations due to: %w", err)
+		}
+		applied[version] = time.Time(at)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read schema_migrations due to: %w", err)
+	}
+	return applied, nil
+}
+
+// timestamp scans a column holding a time, whether or not the driver has
+// been configured to parse times (parseTime=true for MySQL)
+type timestamp time.Time
+
+func (t *timestamp) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case time.Time:
+		*t = timestamp(v)
+	case []byte:
+		return t.Scan(string(v))
+	case string:
+		for _, layout := range []string{"2006-01-02 15:04:05.999999999", time.RFC3339Nano} {
+			if parsed, err := time.Parse(layout, v); err == nil {
+				*t = timestamp(parsed)
+				return nil
+			}
+		}
+		return fmt.Errorf("unable to parse time %q", v)
+	case nil:
+		*t = timestamp(time.Time{})
+	default:
+		return fmt.Errorf("unable to scan %T into a time", src)
+	}
+	return nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	return m.run(ctx, conn, migration, migration.Up, m.dialect.insertVersion, migration.Version, migration.Name)
+}
+
+func (m *Migrator) revert(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	if migration.Down == "" {
+		return fmt.Errorf("unable to revert migration %d, it has no down script", migration.Version)
+	}
+	return m.run(ctx, conn, migration, migration.Down, m.dialect.deleteVersion, migration.Version)
+}
+
+// run executes a migration script and records the outcome in a single
+// transaction. Databases that commit DDL implicitly, such as MySQL, cannot
+// roll back a partially applied script.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, migration Migration, script, track string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to run migration %d due to: %w", migration.Version, err)
+	}
+	defer tx.Rollback()
+
+	for _, statement := range Statements(script) {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("unable to run migration %d due to: %w", migration.Version, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, track, args...); err != nil {
+		return fmt.Errorf("unable to record migration %d due to: %w", migration.Version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("unable to run migration %d due to: %w", migration.Version, err)
+	}
+	return nil
+}
+
+// Statements splits a script into its statements. Statements end with a
+// semicolon at the end of a line and lines starting with -- are comments.
+func Statements(script string) []string {
+	var statements []string
+	var current []string
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current = append(current, line)
+		if strings.HasSuffix(trimmed, ";") {
+			statement := strings.TrimSuffix(strings.TrimSpace(strings.Join(current, "\n")), ";")
+			statements = append(statements, statement)
+			current = nil
+		}
+	}
+	if len(current) > 0 {
+		statements = append(statements, strings.TrimSpace(strings.Join(current, "\n")))
+	}
+	return statements
+}
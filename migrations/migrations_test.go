@@ -0,0 +1,278 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var testMigrations = []Migration{
+	{Version: 1, Name: "create_users", Up: "create table users (id int);"},
+	{Version: 2, Name: "add_email", Up: "alter table users add email text;", Down: "alter table users drop email;"},
+}
+
+func newTestMigrator(t *testing.T) (*Migrator, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unable to create mock DB object: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &Migrator{db, MySQL, testMigrations}, mock
+}
+
+func expectLock(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("select get_lock").
+		WithArgs(30).
+		WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectExec("create table if not exists schema_migrations").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// expectTracked expects the lookup of the schema_migrations table, which
+// exists when tables is 1
+func expectTracked(mock sqlmock.Sqlmock, tables int) {
+	mock.ExpectQuery("select count\\(\\*\\) from information_schema.tables").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tables))
+}
+
+func appliedRows(versions ...int) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, version := range versions {
+		rows.AddRow(version, "2019-01-02 03:04:05")
+	}
+	return rows
+}
+
+func TestLoad(t *testing.T) {
+	for _, dialect := range []Dialect{MySQL, Postgres, SQLite} {
+		testLoad(t, dialect)
+	}
+}
+
+func testLoad(t *testing.T, dialect Dialect) {
+	migrations, err := Load(dialect)
+	if err != nil {
+		t.Fatalf("unable to load %s migrations due to: %v", dialect.Name, err)
+	}
+
+	assert.NotEmpty(t, migrations)
+	for i, migration := range migrations {
+		assert.NotEmpty(t, migration.Up)
+		if i > 0 {
+			assert.NotEmpty(t, migration.Down)
+			assert.True(t, migration.Version > migrations[i-1].Version)
+		}
+	}
+	assert.Equal(t, 1, migrations[0].Version)
+	assert.Equal(t, "create_users", migrations[0].Name)
+	assert.Empty(t, migrations[0].Down, "the baseline may have adopted an existing users table")
+}
+
+func TestUp(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	expectLock(mock)
+	mock.ExpectQuery("select version, applied_at from schema_migrations").
+		WillReturnRows(appliedRows(1))
+	mock.ExpectBegin()
+	mock.ExpectExec("alter table users add email text").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("insert into schema_migrations").
+		WithArgs(2, "add_email").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("select release_lock").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := m.Up(context.Background())
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestUpFailureRollsBack(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	expectLock(mock)
+	mock.ExpectQuery("select version, applied_at from schema_migrations").
+		WillReturnRows(appliedRows())
+	mock.ExpectBegin()
+	mock.ExpectExec("create table users").
+		WillReturnError(errors.New("blamo"))
+	mock.ExpectRollback()
+	mock.ExpectExec("select release_lock").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := m.Up(context.Background())
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "unable to run migration 1 due to: blamo", err.Error())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestUpLockTimeout(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	mock.ExpectQuery("select get_lock").
+		WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(0))
+
+	err := m.Up(context.Background())
+
+	assert.NotNil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestUpPostgres(t *testing.T) {
+	m, mock := newTestMigrator(t)
+	m.dialect = Postgres
+
+	mock.ExpectQuery("select pg_try_advisory_lock").
+		WithArgs(postgresLockKey).
+		WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(true))
+	mock.ExpectExec("create table if not exists schema_migrations").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("select version, applied_at from schema_migrations").
+		WillReturnRows(appliedRows(1))
+	mock.ExpectBegin()
+	mock.ExpectExec("alter table users add email text").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("insert into schema_migrations \\(version, name\\) values \\(\\$1, \\$2\\)").
+		WithArgs(2, "add_email").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("select pg_advisory_unlock").
+		WithArgs(postgresLockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := m.Up(context.Background())
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDown(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	expectLock(mock)
+	mock.ExpectQuery("select version, applied_at from schema_migrations").
+		WillReturnRows(appliedRows(1, 2))
+	mock.ExpectBegin()
+	mock.ExpectExec("alter table users drop email").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("delete from schema_migrations").
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("select release_lock").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := m.Down(context.Background())
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestToZeroRefusesToRevertTheBaseline(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	expectLock(mock)
+	mock.ExpectQuery("select version, applied_at from schema_migrations").
+		WillReturnRows(appliedRows(1, 2))
+	mock.ExpectExec("select release_lock").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := m.To(context.Background(), 0)
+
+	assert.EqualError(t, err, "unable to migrate to version 0, migration 1 has no down script")
+	assert.Nil(t, mock.ExpectationsWereMet(), "migration 2 is left applied")
+}
+
+func TestDownRefusesToRevertTheBaseline(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	expectLock(mock)
+	mock.ExpectQuery("select version, applied_at from schema_migrations").
+		WillReturnRows(appliedRows(1))
+	mock.ExpectExec("select release_lock").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := m.Down(context.Background())
+
+	assert.EqualError(t, err, "unable to revert migration 1, it has no down script")
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestToUnknownVersion(t *testing.T) {
+	m, _ := newTestMigrator(t)
+
+	err := m.To(context.Background(), 7)
+
+	assert.NotNil(t, err)
+}
+
+func TestStatus(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	expectTracked(mock, 1)
+	mock.ExpectQuery("select version, applied_at from schema_migrations").
+		WillReturnRows(appliedRows(1))
+
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("unable to get status due to: %v", err)
+	}
+
+	assert.Len(t, statuses, 2)
+	assert.True(t, statuses[0].Applied)
+	assert.Equal(t, time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC), statuses[0].AppliedAt)
+	assert.False(t, statuses[1].Applied)
+	assert.True(t, statuses[1].AppliedAt.IsZero())
+}
+
+func TestStatusIsReadOnly(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	// No create table is expected: sqlmock fails any statement it was not
+	// told to expect.
+	expectTracked(mock, 0)
+
+	statuses, err := m.Status(context.Background())
+
+	assert.Nil(t, err)
+	assert.Len(t, statuses, 2)
+	assert.False(t, statuses[0].Applied)
+	assert.False(t, statuses[1].Applied)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestVersionIsReadOnly(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	expectTracked(mock, 1)
+	mock.ExpectQuery("select version, applied_at from schema_migrations").
+		WillReturnRows(appliedRows(1, 2))
+
+	version, err := m.Version(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, version)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestStatements(t *testing.T) {
+	script := `-- a comment
+CREATE TABLE users (
+    id INT NOT NULL
+);
+
+INSERT INTO users (id) VALUES (1);
+`
+	assert.Equal(t, []string{
+		"CREATE TABLE users (\n    id INT NOT NULL\n)",
+		"INSERT INTO users (id) VALUES (1)",
+	}, Statements(script))
+}
@@ -0,0 +1,9 @@
+-- Databases bootstrapped from the original schema.sql already contain this
+-- table, so it is only created when missing.
+CREATE TABLE IF NOT EXISTS users (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(100) NOT NULL,
+    age INT NOT NULL,
+    gender VARCHAR(25) NOT NULL,
+    PRIMARY KEY (id)
+);
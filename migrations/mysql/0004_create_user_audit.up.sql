@@ -0,0 +1,14 @@
+-- Every write to users records an entry here in the same transaction. The
+-- entries outlive the users they describe, so there is no foreign key.
+CREATE TABLE user_audit (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    operation VARCHAR(16) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    request_id VARCHAR(128) NOT NULL,
+    before_snapshot JSON NULL,
+    after_snapshot JSON NULL,
+    created_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (id),
+    INDEX user_audit_user_id (user_id, id)
+);
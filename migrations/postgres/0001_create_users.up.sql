@@ -0,0 +1,8 @@
+-- SERIAL draws IDs from a sequence, so like AUTO_INCREMENT they are never
+-- reused after a delete.
+CREATE TABLE users (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    age INT NOT NULL,
+    gender VARCHAR(25) NOT NULL
+);
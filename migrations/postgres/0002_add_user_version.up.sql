@@ -0,0 +1,2 @@
+-- Counts the writes made to each user; existing users start at version 1.
+ALTER TABLE users ADD COLUMN version INT NOT NULL DEFAULT 1;
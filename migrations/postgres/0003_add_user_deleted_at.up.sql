@@ -0,0 +1,3 @@
+-- Soft deleted users keep their row, stamped with the time of the delete,
+-- until they are purged.
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMPTZ NULL;
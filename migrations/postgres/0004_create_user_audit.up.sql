@@ -0,0 +1,13 @@
+-- Every write to users records an entry here in the same transaction. The
+-- entries outlive the users they describe, so there is no foreign key.
+CREATE TABLE user_audit (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    operation VARCHAR(16) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    request_id VARCHAR(128) NOT NULL,
+    before_snapshot JSONB NULL,
+    after_snapshot JSONB NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX user_audit_user_id ON user_audit (user_id, id);
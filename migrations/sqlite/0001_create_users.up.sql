@@ -0,0 +1,9 @@
+-- SQLite does not enforce VARCHAR lengths, so the limits of the MySQL schema
+-- are expressed as CHECK constraints. AUTOINCREMENT keeps IDs from being
+-- reused after a delete, matching MySQL.
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(100) NOT NULL CHECK (length(name) <= 100),
+    age INT NOT NULL,
+    gender VARCHAR(25) NOT NULL CHECK (length(gender) <= 25)
+);
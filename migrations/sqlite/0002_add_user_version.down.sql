@@ -0,0 +1,16 @@
+-- Older SQLite releases cannot drop a column, so the table is rebuilt
+-- without it. The AUTOINCREMENT sequence is carried over so IDs are still
+-- not reused.
+CREATE TABLE users_without_version (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(100) NOT NULL CHECK (length(name) <= 100),
+    age INT NOT NULL,
+    gender VARCHAR(25) NOT NULL CHECK (length(gender) <= 25)
+);
+INSERT INTO users_without_version (id, name, age, gender)
+    SELECT id, name, age, gender FROM users;
+DELETE FROM sqlite_sequence WHERE name = 'users_without_version';
+INSERT INTO sqlite_sequence (name, seq)
+    SELECT 'users_without_version', seq FROM sqlite_sequence WHERE name = 'users';
+DROP TABLE users;
+ALTER TABLE users_without_version RENAME TO users;
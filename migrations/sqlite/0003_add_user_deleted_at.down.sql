@@ -0,0 +1,17 @@
+-- Older SQLite releases cannot drop a column, so the table is rebuilt
+-- without it. The AUTOINCREMENT sequence is carried over so IDs are still
+-- not reused.
+CREATE TABLE users_without_deleted_at (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(100) NOT NULL CHECK (length(name) <= 100),
+    age INT NOT NULL,
+    gender VARCHAR(25) NOT NULL CHECK (length(gender) <= 25),
+    version INT NOT NULL DEFAULT 1
+);
+INSERT INTO users_without_deleted_at (id, name, age, gender, version)
+    SELECT id, name, age, gender, version FROM users;
+DELETE FROM sqlite_sequence WHERE name = 'users_without_deleted_at';
+INSERT INTO sqlite_sequence (name, seq)
+    SELECT 'users_without_deleted_at', seq FROM sqlite_sequence WHERE name = 'users';
+DROP TABLE users;
+ALTER TABLE users_without_deleted_at RENAME TO users;
@@ -0,0 +1,13 @@
+-- Every write to users records an entry here in the same transaction. The
+-- entries outlive the users they describe, so there is no foreign key.
+CREATE TABLE user_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INT NOT NULL,
+    operation VARCHAR(16) NOT NULL,
+    actor VARCHAR(255) NOT NULL CHECK (length(actor) <= 255),
+    request_id VARCHAR(128) NOT NULL CHECK (length(request_id) <= 128),
+    before_snapshot TEXT NULL,
+    after_snapshot TEXT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX user_audit_user_id ON user_audit (user_id, id);
@@ -0,0 +1,116 @@
+package models
+
+import "errors"
+
+// Sentinel errors classifying repository failures. Every error type below
+// matches exactly one of them with errors.Is, so callers may branch on the
+// class of a failure without knowing its concrete type.
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrValidation  = errors.New("validation failed")
+	ErrUnavailable = errors.New("unavailable")
+	// ErrVersionMismatch reports a write conditioned on a version that is no
+	// longer current
+	ErrVersionMismatch = errors.New("version mismatch")
+)
+
+// UserNotFoundError identifies when a user is not found
+type UserNotFoundError struct {
+	Message string
+}
+
+func (u UserNotFoundError) Error() string {
+	return u.Message
+}
+
+// Is reports whether the target is ErrNotFound
+func (u UserNotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// VersionMismatchError identifies when a user was modified after the version
+// a write was conditioned on
+type VersionMismatchError struct {
+	Message string
+}
+
+func (v VersionMismatchError) Error() string {
+	return v.Message
+}
+
+// Is reports whether the target is ErrVersionMismatch
+func (v VersionMismatchError) Is(target error) bool {
+	return target == ErrVersionMismatch
+}
+
+// ConflictError identifies when a write collides with existing data, such as
+// a duplicate unique key
+type ConflictError struct {
+	Message string
+	Err     error
+}
+
+func (c ConflictError) Error() string {
+	return describe(c.Message, c.Err)
+}
+
+// Unwrap returns the underlying cause
+func (c ConflictError) Unwrap() error {
+	return c.Err
+}
+
+// Is reports whether the target is ErrConflict
+func (c ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// ValidationError identifies when data is rejected as invalid. Fields maps
+// the JSON name of each offending field to a description of the problem.
+type ValidationError struct {
+	Message string
+	Fields  map[string]string
+	Err     error
+}
+
+func (v ValidationError) Error() string {
+	return describe(v.Message, v.Err)
+}
+
+// Unwrap returns the underlying cause
+func (v ValidationError) Unwrap() error {
+	return v.Err
+}
+
+// Is reports whether the target is ErrValidation
+func (v ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// UnavailableError identifies when the backing store cannot complete an
+// operation, e.g. because it is unreachable or the operation timed out
+type UnavailableError struct {
+	Message string
+	Err     error
+}
+
+func (u UnavailableError) Error() string {
+	return describe(u.Message, u.Err)
+}
+
+// Unwrap returns the underlying cause
+func (u UnavailableError) Unwrap() error {
+	return u.Err
+}
+
+// Is reports whether the target is ErrUnavailable
+func (u UnavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+func describe(message string, err error) string {
+	if err == nil {
+		return message
+	}
+	return message + " due to: " + err.Error()
+}
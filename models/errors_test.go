@@ -0,0 +1,54 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserNotFoundError(t *testing.T) {
+	err := fmt.Errorf("lookup failed: %w", UserNotFoundError{Message: "not found"})
+
+	var notFound UserNotFoundError
+	assert.True(t, errors.As(err, &notFound))
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.False(t, errors.Is(err, ErrUnavailable))
+	assert.Equal(t, "lookup failed: not found", err.Error())
+}
+
+func TestVersionMismatchError(t *testing.T) {
+	err := fmt.Errorf("update failed: %w", VersionMismatchError{Message: "version mismatch"})
+
+	assert.True(t, errors.Is(err, ErrVersionMismatch))
+	assert.False(t, errors.Is(err, ErrConflict))
+	assert.Equal(t, "update failed: version mismatch", err.Error())
+}
+
+func TestConflictError(t *testing.T) {
+	err := ConflictError{Message: "unable to create user", Err: errors.New("duplicate")}
+
+	assert.True(t, errors.Is(err, ErrConflict))
+	assert.False(t, errors.Is(err, ErrNotFound))
+	assert.Equal(t, "unable to create user due to: duplicate", err.Error())
+}
+
+func TestValidationError(t *testing.T) {
+	err := ValidationError{Message: "invalid user", Fields: map[string]string{"age": "must not be negative"}}
+
+	var validation ValidationError
+	assert.True(t, errors.As(err, &validation))
+	assert.True(t, errors.Is(err, ErrValidation))
+	assert.Equal(t, "must not be negative", validation.Fields["age"])
+	assert.Equal(t, "invalid user", err.Error())
+}
+
+func TestUnavailableError(t *testing.T) {
+	err := UnavailableError{Message: "unable to locate users", Err: context.DeadlineExceeded}
+
+	assert.True(t, errors.Is(err, ErrUnavailable))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, "unable to locate users due to: context deadline exceeded", err.Error())
+}
@@ -1,11 +1,18 @@
 package models
 
-// User type represents a person using the system.
+import "time"
+
+// User type represents a person using the system. Validation rules mirror
+// the limits of the users table. Version counts the writes to a stored user,
+// starting at 1, and is exchanged over HTTP as an ETag rather than in the
+// body. DeletedAt is set once the user has been soft deleted.
 type User struct {
-	Name   string `json:"name" bson:"name"`
-	Gender string `json:"gender" bson:"gender"`
-	Age    int    `json:"age" bson:"age"`
-	ID     string `json:"id" bson:"_id"`
+	Name      string     `json:"name" bson:"name" validate:"required,max=100"`
+	Gender    string     `json:"gender" bson:"gender" validate:"required,max=25,oneof=female male non-binary other undisclosed"`
+	Age       int        `json:"age" bson:"age" validate:"min=0,max=150"`
+	ID        string     `json:"id" bson:"_id"`
+	Version   int        `json:"-" bson:"version"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
 }
 
 // IsEmpty returns a boolean value representing if the object is empty.
@@ -13,11 +20,31 @@ func (u User) IsEmpty() bool {
 	return u.Name == "" && u.Gender == "" && u.Age == 0 && u.ID == ""
 }
 
-// UserNotFoundError identifies when a user is not found
-type UserNotFoundError struct {
-	Message string
+// Validate returns a ValidationError describing every field of the user that
+// breaks its validation rules, or nil when the user is valid.
+func (u User) Validate() error {
+	return Validate(u)
 }
 
-func (u UserNotFoundError) Error() string {
-	return u.Message
+// Operations recorded by an AuditEntry
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
+
+// AuditEntry records a single mutation of a user: who made it, on behalf of
+// which request, and the user before and after the change. Before is nil for
+// creations and After for purges.
+type AuditEntry struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Operation string    `json:"operation"`
+	Actor     string    `json:"actor"`
+	RequestID string    `json:"request_id"`
+	Before    *User     `json:"before"`
+	After     *User     `json:"after"`
+	At        time.Time `json:"at"`
 }
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validate checks a struct against the rules declared in the validate tags
+// of its fields and returns a ValidationError describing every violation, or
+// nil when the struct is valid. Rules are separated by commas:
+//
+//	required     string must contain a non-whitespace character
+//	min=N        number must be at least N; string must have N characters
+//	max=N        number must be at most N; string must have at most N characters
+//	oneof=A B C  value must be one of the space separated options
+//
+// Violations are keyed by the JSON name of the field.
+func Validate(v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("unable to validate %T, a struct is required", v)
+	}
+
+	fields := map[string]string{}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		if msg := checkRules(rv.Field(i), tag); msg != "" {
+			fields[jsonName(field)] = msg
+		}
+	}
+	if len(fields) > 0 {
+		return ValidationError{Message: "invalid " + strings.ToLower(rt.Name()), Fields: fields}
+	}
+	return nil
+}
+
+// checkRules returns a description of the first rule the value violates, or
+// an empty string when it satisfies them all.
+func checkRules(v reflect.Value, tag string) string {
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, arg = rule[:i], rule[i+1:]
+		}
+		if msg := checkRule(v, name, arg); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+func checkRule(v reflect.Value, name, arg string) string {
+	switch name {
+	case "required":
+		if v.Kind() == reflect.String && strings.TrimSpace(v.String()) == "" {
+			return "is required"
+		}
+	case "min", "max":
+		limit, err := strconv.Atoi(arg)
+		if err != nil {
+			panic(fmt.Sprintf("invalid %s rule %q", name, arg))
+		}
+		switch v.Kind() {
+		case reflect.String:
+			n := utf8.RuneCountInString(v.String())
+			if name == "min" && n < limit {
+				return fmt.Sprintf("must be at least %d characters", limit)
+			}
+			if name == "max" && n > limit {
+				return fmt.Sprintf("must be at most %d characters", limit)
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n := v.Int()
+			if name == "min" && n < int64(limit) {
+				return fmt.Sprintf("must be at least %d", limit)
+			}
+			if name == "max" && n > int64(limit) {
+				return fmt.Sprintf("must be at most %d", limit)
+			}
+		}
+	case "oneof":
+		options := strings.Fields(arg)
+		actual := fmt.Sprint(v.Interface())
+		for _, option := range options {
+			if actual == option {
+				return ""
+			}
+		}
+		return "must be one of: " + strings.Join(options, ", ")
+	default:
+		panic(fmt.Sprintf("unknown validation rule %q", name))
+	}
+	return ""
+}
+
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
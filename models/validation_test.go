@@ -0,0 +1,65 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateUser(t *testing.T) {
+	user := User{
+		Name:   "James Bond",
+		Gender: "male",
+		Age:    44,
+	}
+	assert.Nil(t, user.Validate())
+}
+
+func TestValidateUserInvalid(t *testing.T) {
+	user := User{
+		Name:   strings.Repeat("a", 101),
+		Gender: "spy",
+		Age:    -5,
+	}
+	err := user.Validate()
+
+	var validation ValidationError
+	assert.True(t, errors.As(err, &validation))
+	assert.True(t, errors.Is(err, ErrValidation))
+	assert.Equal(t, map[string]string{
+		"name":   "must be at most 100 characters",
+		"gender": "must be one of: female, male, non-binary, other, undisclosed",
+		"age":    "must be at least 0",
+	}, validation.Fields)
+}
+
+func TestValidateUserRequired(t *testing.T) {
+	user := User{
+		Name: "   ",
+		Age:  151,
+	}
+	err := user.Validate()
+
+	var validation ValidationError
+	errors.As(err, &validation)
+	assert.Equal(t, map[string]string{
+		"name":   "is required",
+		"gender": "is required",
+		"age":    "must be at most 150",
+	}, validation.Fields)
+}
+
+func TestValidateCountsCharacters(t *testing.T) {
+	user := User{
+		Name:   strings.Repeat("é", 100),
+		Gender: "female",
+		Age:    30,
+	}
+	assert.Nil(t, user.Validate())
+}
+
+func TestValidateRequiresStruct(t *testing.T) {
+	assert.NotNil(t, Validate("James Bond"))
+}
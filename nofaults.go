@@ -0,0 +1,12 @@
+//go:build !chaos
+// +build !chaos
+
+package main
+
+import "github.com/ChrisTheShark/golang-mysql-api/repository"
+
+// withFaults returns the repository unchanged; fault injection is only
+// available in builds made with the chaos tag
+func withFaults(users repository.UserRepository, _ routes) repository.UserRepository {
+	return users
+}
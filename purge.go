@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+)
+
+// defaultRetention is how long soft deleted users are kept when the purge
+// subcommand is given no retention window
+const defaultRetention = 30 * 24 * time.Hour
+
+const purgeUsage = "usage: purge [RETENTION], e.g. purge 720h to remove users deleted more than 30 days ago"
+
+// cliActor is recorded in the audit trail as the actor of writes made by
+// subcommands
+const cliActor = "cli"
+
+// purge runs the purge subcommand, permanently removing the users soft
+// deleted longer ago than the retention window
+func purge(store *storage, args []string, out io.Writer) error {
+	retention := defaultRetention
+	switch len(args) {
+	case 0:
+	case 1:
+		d, err := time.ParseDuration(args[0])
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid retention %q\n%s", args[0], purgeUsage)
+		}
+		retention = d
+	default:
+		return errors.New(purgeUsage)
+	}
+
+	before := time.Now().Add(-retention)
+	ctx := repository.WithActor(context.Background(), cliActor)
+	purged, err := store.users.Purge(ctx, before)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "purged %d users deleted before %s\n", purged, before.UTC().Format("2006-01-02 15:04:05"))
+	return nil
+}
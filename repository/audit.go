@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+)
+
+type auditContextKey int
+
+const (
+	actorKey auditContextKey = iota
+	requestIDKey
+)
+
+// WithActor returns a context attributing the writes made with it to actor
+// in the audit trail
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// WithRequestID returns a context recording in the audit trail the request
+// on whose behalf writes are made
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// NewAuditEntry describes a mutation of a user made with ctx. It lets every
+// implementation record the same audit trail as UserRepositoryImpl.
+func NewAuditEntry(ctx context.Context, operation string, before, after *models.User) models.AuditEntry {
+	entry := models.AuditEntry{Operation: operation, Before: before, After: after, At: time.Now().UTC()}
+	entry.Actor, _ = ctx.Value(actorKey).(string)
+	entry.RequestID, _ = ctx.Value(requestIDKey).(string)
+	if after != nil {
+		entry.UserID = after.ID
+	} else if before != nil {
+		entry.UserID = before.ID
+	}
+	return entry
+}
+
+// HistoryOptions describes which page of a user's audit trail a History
+// call returns
+type HistoryOptions struct {
+	Limit  int
+	Offset int
+}
+
+func (o HistoryOptions) normalize() HistoryOptions {
+	if o.Limit <= 0 {
+		o.Limit = DefaultLimit
+	}
+	if o.Limit > MaxLimit {
+		o.Limit = MaxLimit
+	}
+	if o.Offset < 0 {
+		o.Offset = 0
+	}
+	return o
+}
+
+// AuditPage is a single page of audit entries, oldest first, along with the
+// total number of entries recorded for the user
+type AuditPage struct {
+	Entries []models.AuditEntry
+	Total   int
+}
+
+// PageAudit applies HistoryOptions to the audit trail of a user held in
+// memory, oldest entry first
+func PageAudit(entries []models.AuditEntry, opts HistoryOptions) *AuditPage {
+	opts = opts.normalize()
+	page := &AuditPage{Entries: []models.AuditEntry{}, Total: len(entries)}
+	if opts.Offset >= len(entries) {
+		return page
+	}
+	end := opts.Offset + opts.Limit
+	if end > len(entries) {
+		end = len(entries)
+	}
+	page.Entries = append(page.Entries, entries[opts.Offset:end]...)
+	return page
+}
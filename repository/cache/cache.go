@@ -0,0 +1,383 @@
+// Package cache decorates a UserRepository with a read-through cache of
+// GetByID, so users read over and over are served from memory rather than
+// from the database.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+)
+
+// Config sizes the cache of a CachingUserRepository
+type Config struct {
+	// Size bounds the number of cached lookups; the least recently used
+	// lookup is evicted to make room for another
+	Size int `json:"size"`
+	// TTL bounds how long a user is served from the cache
+	TTL time.Duration `json:"ttl"`
+	// NegativeTTL bounds how long a user is remembered as not found. Zero
+	// disables negative caching.
+	NegativeTTL time.Duration `json:"negative_ttl"`
+}
+
+// Validate reports the first problem with the configuration
+func (c Config) Validate() error {
+	switch {
+	case c.Size <= 0:
+		return errors.New("cache size must be positive")
+	case c.TTL <= 0:
+		return errors.New("cache ttl must be positive")
+	case c.NegativeTTL < 0:
+		return errors.New("cache negative ttl must not be negative")
+	}
+	return nil
+}
+
+// Stats counts how the lookups of a CachingUserRepository were served.
+// Hits and NegativeHits were answered from the cache, with a user and with
+// not found respectively; Misses were passed on to the wrapped repository.
+// Evictions counts the lookups dropped to make room for others,
+// Expirations those dropped once their TTL passed and Invalidations those
+// dropped because the user was written.
+type Stats struct {
+	Hits          uint64 `json:"hits"`
+	NegativeHits  uint64 `json:"negative_hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Expirations   uint64 `json:"expirations"`
+	Invalidations uint64 `json:"invalidations"`
+	Entries       int    `json:"entries"`
+	Size          int    `json:"size"`
+}
+
+// CachingUserRepository caches the users read with GetByID, as well as the
+// IDs found not to exist, in front of the UserRepository it wraps. Writes
+// made through it invalidate the users they touch, so within a process a
+// read never returns a user older than the last write; writes made by other
+// processes are only seen once the cached lookup expires. Every other read
+// is passed on uncached, and reads made within WithTx bypass the cache so
+// they lock and see the rows the transaction writes. It is safe for
+// concurrent use.
+type CachingUserRepository struct {
+	next repository.UserRepository
+	*lru
+	// written is set within WithTx, recording the writes to invalidate
+	// again once the transaction ends
+	written *writeSet
+}
+
+// NewCachingUserRepository convenience function to create a
+// CachingUserRepository wrapping next
+func NewCachingUserRepository(next repository.UserRepository, config Config) (*CachingUserRepository, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &CachingUserRepository{next: next, lru: newLRU(config)}, nil
+}
+
+// entry is a cached lookup: the user found or the error reporting it was
+// not found
+type entry struct {
+	id      string
+	user    models.User
+	err     error
+	expires time.Time
+}
+
+// lru holds the cached lookups, most recently used first
+type lru struct {
+	mu      sync.Mutex
+	config  Config
+	entries map[string]*list.Element
+	order   *list.List
+	// generation is advanced by every invalidation, so a lookup racing a
+	// write does not cache what it read before the write
+	generation uint64
+	stats      Stats
+	now        func() time.Time
+}
+
+func newLRU(config Config) *lru {
+	return &lru{
+		config:  config,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// get returns the unexpired lookup of id, if cached
+func (c *lru) get(id string) (entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[id]
+	if !ok {
+		c.stats.Misses++
+		return entry{}, false
+	}
+	e := el.Value.(entry)
+	if !c.now().Before(e.expires) {
+		c.remove(el)
+		c.stats.Expirations++
+		c.stats.Misses++
+		return entry{}, false
+	}
+	c.order.MoveToFront(el)
+	if e.err != nil {
+		c.stats.NegativeHits++
+	} else {
+		c.stats.Hits++
+	}
+	return e, true
+}
+
+// current returns the generation a lookup about to be passed on should be
+// cached under
+func (c *lru) current() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// put caches a lookup unless the cache was invalidated since generation
+func (c *lru) put(e entry, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	ttl := c.config.TTL
+	if e.err != nil {
+		ttl = c.config.NegativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	e.expires = c.now().Add(ttl)
+	if el, ok := c.entries[e.id]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[e.id] = c.order.PushFront(e)
+	for c.order.Len() > c.config.Size {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// invalidate drops the lookups of the given IDs and, when negative is set,
+// every lookup that found no user, as after a write whose outcome is
+// unknown may have created one
+func (c *lru) invalidate(negative bool, ids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for _, id := range ids {
+		if el, ok := c.entries[id]; ok {
+			c.remove(el)
+			c.stats.Invalidations++
+		}
+	}
+	if !negative {
+		return
+	}
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(entry).err != nil {
+			c.remove(el)
+			c.stats.Invalidations++
+		}
+		el = next
+	}
+}
+
+func (c *lru) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(entry).id)
+}
+
+// Stats returns the counters of the cache
+func (c *lru) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	stats.Size = c.config.Size
+	return stats
+}
+
+// Clear drops every cached lookup
+func (c *lru) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entries = map[string]*list.Element{}
+	c.order.Init()
+}
+
+// writeSet records the users written within a transaction
+type writeSet struct {
+	mu       sync.Mutex
+	ids      []string
+	negative bool
+}
+
+func (s *writeSet) add(negative bool, ids ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ids = append(s.ids, ids...)
+	s.negative = s.negative || negative
+}
+
+// invalidate drops the lookups of the users written from c
+func (s *writeSet) invalidate(c *lru) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.invalidate(s.negative, s.ids...)
+}
+
+// invalidate drops the lookups of users written through r
+func (r *CachingUserRepository) invalidate(negative bool, ids ...string) {
+	if r.written != nil {
+		r.written.add(negative, ids...)
+	}
+	r.lru.invalidate(negative, ids...)
+}
+
+// cacheKey returns the key caching the lookup of id. Only IDs in the
+// canonical form of a stored ID are cached, so the lookups invalidated by a
+// write are the only ones that could have found its user.
+func cacheKey(id string) (string, bool) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != id {
+		return "", false
+	}
+	return id, true
+}
+
+// GetAll get all users from the repository
+func (r *CachingUserRepository) GetAll(ctx context.Context) ([]models.User, error) {
+	return r.next.GetAll(ctx)
+}
+
+// GetByID get a user by string identifier, from the cache when it holds an
+// unexpired lookup of it
+func (r *CachingUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
+	key, ok := cacheKey(id)
+	if !ok || r.written != nil {
+		return r.next.GetByID(ctx, id)
+	}
+	if e, ok := r.get(key); ok {
+		if e.err != nil {
+			return nil, e.err
+		}
+		user := e.user
+		return &user, nil
+	}
+
+	generation := r.current()
+	user, err := r.next.GetByID(ctx, id)
+	switch {
+	case err == nil:
+		r.put(entry{id: key, user: *user}, generation)
+	case errors.Is(err, models.ErrNotFound):
+		r.put(entry{id: key, err: err}, generation)
+	}
+	return user, err
+}
+
+// List get a page of users from the repository
+func (r *CachingUserRepository) List(ctx context.Context, opts repository.ListOptions) (*repository.UserPage, error) {
+	return r.next.List(ctx, opts)
+}
+
+// Each calls fn with every user matching opts
+func (r *CachingUserRepository) Each(ctx context.Context, opts repository.ListOptions, fn func(models.User) error) error {
+	return r.next.Each(ctx, opts, fn)
+}
+
+// Create a User to the repository
+func (r *CachingUserRepository) Create(ctx context.Context, user models.User) (string, error) {
+	id, err := r.next.Create(ctx, user)
+	if err != nil {
+		r.invalidate(true)
+		return id, err
+	}
+	r.invalidate(false, id)
+	return id, nil
+}
+
+// CreateMany creates every user or, failing that, none of them
+func (r *CachingUserRepository) CreateMany(ctx context.Context, users []models.User) ([]string, error) {
+	ids, err := r.next.CreateMany(ctx, users)
+	if err != nil {
+		r.invalidate(true)
+		return ids, err
+	}
+	r.invalidate(false, ids...)
+	return ids, nil
+}
+
+// Update replaces all mutable fields of an existing User in the repository
+func (r *CachingUserRepository) Update(ctx context.Context, user models.User) error {
+	defer r.invalidate(false, user.ID)
+	return r.next.Update(ctx, user)
+}
+
+// Delete a User from the repository
+func (r *CachingUserRepository) Delete(ctx context.Context, user models.User) error {
+	defer r.invalidate(false, user.ID)
+	return r.next.Delete(ctx, user)
+}
+
+// Restore undoes the soft delete of a User
+func (r *CachingUserRepository) Restore(ctx context.Context, user models.User) error {
+	defer r.invalidate(false, user.ID)
+	return r.next.Restore(ctx, user)
+}
+
+// Purge permanently removes the users soft deleted before the given time.
+// Soft deleted users are only ever cached as not found, so when any was
+// removed the lookups that found no user are dropped, as their IDs may be
+// reused, and the lookups racing the purge are discarded.
+func (r *CachingUserRepository) Purge(ctx context.Context, before time.Time) (int, error) {
+	purged, err := r.next.Purge(ctx, before)
+	if purged > 0 {
+		r.invalidate(true)
+	}
+	return purged, err
+}
+
+// History get a page of the audit trail of a user
+func (r *CachingUserRepository) History(ctx context.Context, id string, opts repository.HistoryOptions) (*repository.AuditPage, error) {
+	return r.next.History(ctx, id, opts)
+}
+
+// WithTx runs fn in a transaction of the wrapped repository. The users
+// written by fn are invalidated as they are written and once more when the
+// transaction ends, as a lookup made in between may have cached them as
+// they were before the commit.
+func (r *CachingUserRepository) WithTx(ctx context.Context, fn func(repository.UserRepository) error) error {
+	if r.written != nil {
+		return r.next.WithTx(ctx, func(tx repository.UserRepository) error {
+			return fn(&CachingUserRepository{next: tx, lru: r.lru, written: r.written})
+		})
+	}
+
+	written := &writeSet{}
+	defer written.invalidate(r.lru)
+	return r.next.WithTx(ctx, func(tx repository.UserRepository) error {
+		return fn(&CachingUserRepository{next: tx, lru: r.lru, written: written})
+	})
+}
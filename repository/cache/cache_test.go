@@ -0,0 +1,251 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+	mocks "github.com/ChrisTheShark/golang-mysql-api/repository/mocks"
+	"github.com/ChrisTheShark/golang-mysql-api/repository/repositorytest"
+	"github.com/stretchr/testify/assert"
+)
+
+var testConfig = Config{Size: 2, TTL: time.Minute, NegativeTTL: time.Second}
+
+// newCachingRepository wraps a mock repository holding James Bond as user 1
+func newCachingRepository(t *testing.T, config Config) (*CachingUserRepository, *mocks.MockUserRepository) {
+	next := mocks.NewMockUserRepository()
+	r, err := NewCachingUserRepository(next, config)
+	if err != nil {
+		t.Fatalf("unable to create CachingUserRepository due to: %v", err)
+	}
+	return r, next
+}
+
+func TestCachingUserRepositoryConformance(t *testing.T) {
+	repositorytest.RunConformance(t, func(t *testing.T) repository.UserRepository {
+		r, err := NewCachingUserRepository(repository.NewMemoryUserRepository(), Config{Size: 100, TTL: time.Minute, NegativeTTL: time.Minute})
+		if err != nil {
+			t.Fatalf("unable to create CachingUserRepository due to: %v", err)
+		}
+		return r
+	})
+}
+
+func TestGetByIDIsCached(t *testing.T) {
+	r, next := newCachingRepository(t, testConfig)
+	ctx := context.Background()
+
+	first, err := r.GetByID(ctx, "1")
+	assert.Nil(t, err)
+	first.Name = "Q"
+	second, err := r.GetByID(ctx, "1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "James Bond", second.Name)
+	assert.Len(t, next.CallsTo("GetByID"), 1)
+	assert.Equal(t, Stats{Hits: 1, Misses: 1, Entries: 1, Size: 2}, r.Stats())
+}
+
+func TestGetByIDNegativeCaching(t *testing.T) {
+	r, next := newCachingRepository(t, testConfig)
+	ctx := context.Background()
+
+	_, err := r.GetByID(ctx, "2")
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+	_, err = r.GetByID(ctx, "2")
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+
+	assert.Len(t, next.CallsTo("GetByID"), 1)
+	assert.Equal(t, uint64(1), r.Stats().NegativeHits)
+
+	id, err := r.Create(ctx, models.User{Name: "Q", Gender: "male", Age: 30})
+	assert.Nil(t, err)
+	assert.Equal(t, "2", id)
+	user, err := r.GetByID(ctx, "2")
+	assert.Nil(t, err)
+	assert.Equal(t, "Q", user.Name)
+}
+
+func TestGetByIDWithoutNegativeCaching(t *testing.T) {
+	r, next := newCachingRepository(t, Config{Size: 2, TTL: time.Minute})
+
+	r.GetByID(context.Background(), "2")
+	r.GetByID(context.Background(), "2")
+
+	assert.Len(t, next.CallsTo("GetByID"), 2)
+}
+
+func TestGetByIDDoesNotCacheErrors(t *testing.T) {
+	r, err := NewCachingUserRepository(mocks.NewMockErroringUserRepository(), testConfig)
+	assert.Nil(t, err)
+
+	r.GetByID(context.Background(), "1")
+
+	assert.Equal(t, 0, r.Stats().Entries)
+}
+
+func TestGetByIDSkipsNonCanonicalIDs(t *testing.T) {
+	r, next := newCachingRepository(t, testConfig)
+
+	r.GetByID(context.Background(), "01")
+	r.GetByID(context.Background(), "01")
+
+	assert.Len(t, next.CallsTo("GetByID"), 2)
+	assert.Equal(t, 0, r.Stats().Entries)
+}
+
+func TestEviction(t *testing.T) {
+	r, next := newCachingRepository(t, testConfig)
+	next.Seed(models.User{Name: "Q", Gender: "male", Age: 30}, models.User{Name: "M", Gender: "female", Age: 60})
+	ctx := context.Background()
+
+	r.GetByID(ctx, "1")
+	r.GetByID(ctx, "2")
+	r.GetByID(ctx, "1")
+	r.GetByID(ctx, "3")
+	next.Reset()
+	_, errOne := r.GetByID(ctx, "1")
+	_, errTwo := r.GetByID(ctx, "2")
+
+	assert.Nil(t, errOne)
+	assert.True(t, errors.Is(errTwo, models.ErrNotFound))
+	stats := r.Stats()
+	assert.Equal(t, uint64(2), stats.Evictions)
+	assert.Equal(t, 2, stats.Entries)
+}
+
+func TestExpiration(t *testing.T) {
+	r, next := newCachingRepository(t, testConfig)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	r.GetByID(ctx, "1")
+	now = now.Add(testConfig.TTL - time.Nanosecond)
+	r.GetByID(ctx, "1")
+	now = now.Add(time.Nanosecond)
+	r.GetByID(ctx, "1")
+
+	assert.Len(t, next.CallsTo("GetByID"), 2)
+	assert.Equal(t, uint64(1), r.Stats().Expirations)
+}
+
+func TestWritesInvalidate(t *testing.T) {
+	r, _ := newCachingRepository(t, testConfig)
+	ctx := context.Background()
+
+	user, _ := r.GetByID(ctx, "1")
+	user.Age = 45
+	assert.Nil(t, r.Update(ctx, *user))
+	user, _ = r.GetByID(ctx, "1")
+	assert.Equal(t, 45, user.Age)
+
+	assert.Nil(t, r.Delete(ctx, *user))
+	_, err := r.GetByID(ctx, "1")
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+
+	user.Version = 0
+	assert.Nil(t, r.Restore(ctx, *user))
+	_, err = r.GetByID(ctx, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), r.Stats().Invalidations)
+}
+
+func TestFailedCreateInvalidatesNegativeLookups(t *testing.T) {
+	r, err := NewCachingUserRepository(mocks.NewMockErroringUserRepositoryWithError(models.ErrNotFound), testConfig)
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	r.GetByID(ctx, "2")
+	assert.Equal(t, 1, r.Stats().Entries)
+	r.Create(ctx, models.User{Name: "Q", Gender: "male", Age: 30})
+
+	assert.Equal(t, 0, r.Stats().Entries)
+}
+
+func TestWithTxBypassesCacheAndInvalidates(t *testing.T) {
+	r, next := newCachingRepository(t, testConfig)
+	ctx := context.Background()
+	r.GetByID(ctx, "1")
+
+	err := r.WithTx(ctx, func(tx repository.UserRepository) error {
+		user, err := tx.GetByID(ctx, "1")
+		if err != nil {
+			return err
+		}
+		user.Age = 45
+		if err := tx.Update(ctx, *user); err != nil {
+			return err
+		}
+		// A lookup outside the transaction, made before it commits, caches
+		// the user as it was.
+		r.put(entry{id: "1", user: models.User{ID: "1", Age: 44}}, r.current())
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Len(t, next.CallsTo("GetByID"), 2)
+	assert.Equal(t, 0, r.Stats().Entries)
+	user, _ := r.GetByID(ctx, "1")
+	assert.Equal(t, 45, user.Age)
+}
+
+func TestStaleLookupIsNotCached(t *testing.T) {
+	r, _ := newCachingRepository(t, testConfig)
+
+	generation := r.current()
+	r.invalidate(false, "2")
+	r.put(entry{id: "1", user: models.User{ID: "1"}}, generation)
+
+	assert.Equal(t, 0, r.Stats().Entries)
+}
+
+func TestPurgeInvalidates(t *testing.T) {
+	r, _ := newCachingRepository(t, testConfig)
+	ctx := context.Background()
+
+	r.GetByID(ctx, "1")
+	purged, err := r.Purge(ctx, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, purged)
+	assert.Equal(t, 1, r.Stats().Entries, "a purge removing nothing leaves the cache alone")
+
+	assert.Nil(t, r.Delete(ctx, models.User{ID: "1"}))
+	r.GetByID(ctx, "1")
+	generation := r.current()
+	purged, err = r.Purge(ctx, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, purged)
+	r.put(entry{id: "1", user: models.User{ID: "1"}}, generation)
+
+	assert.Equal(t, 0, r.Stats().Entries)
+}
+
+func TestClear(t *testing.T) {
+	r, next := newCachingRepository(t, testConfig)
+	ctx := context.Background()
+
+	r.GetByID(ctx, "1")
+	r.Clear()
+	r.GetByID(ctx, "1")
+
+	assert.Len(t, next.CallsTo("GetByID"), 2)
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := map[string]Config{
+		"size":         {TTL: time.Minute},
+		"ttl":          {Size: 1},
+		"negative ttl": {Size: 1, TTL: time.Minute, NegativeTTL: -1},
+	}
+	for name, config := range tests {
+		assert.NotNil(t, config.Validate(), name)
+		_, err := NewCachingUserRepository(repository.NewMemoryUserRepository(), config)
+		assert.NotNil(t, err, name)
+	}
+	assert.Nil(t, testConfig.Validate())
+}
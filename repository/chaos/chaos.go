@@ -0,0 +1,464 @@
+// Package chaos decorates a UserRepository with configurable faults, so the
+// service and its clients can be exercised against a degraded database.
+// Faults are injected per method, either with a probability or by a scripted
+// sequence consumed one call at a time.
+package chaos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+)
+
+// ErrInjected is the cause of every error produced by a Fault
+var ErrInjected = errors.New("injected fault")
+
+// AnyMethod keys the Rule applied to methods without a Rule of their own
+const AnyMethod = "*"
+
+// Methods lists the UserRepository methods a Rule may be keyed by
+var Methods = []string{"GetAll", "GetByID", "List", "Each", "Create", "CreateMany", "Update", "Delete", "Restore", "Purge", "History", "WithTx"}
+
+// errorKinds maps the names accepted by Fault.Error onto the error the fault
+// produces
+var errorKinds = map[string]func() error{
+	"unavailable": func() error { return models.UnavailableError{Message: "unavailable", Err: ErrInjected} },
+	"not_found":   func() error { return models.UserNotFoundError{Message: ErrInjected.Error()} },
+	"conflict":    func() error { return models.ConflictError{Message: "conflict", Err: ErrInjected} },
+	"validation":  func() error { return models.ValidationError{Message: "invalid", Err: ErrInjected} },
+}
+
+// Duration is a time.Duration encoded in JSON as a Go duration string such
+// as "250ms"
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes a duration string
+func (d *Duration) UnmarshalJSON(bs []byte) error {
+	var s string
+	if err := json.Unmarshal(bs, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Fault describes what happens to a single call. The zero Fault lets the
+// call through untouched.
+type Fault struct {
+	// Latency delays the call before it proceeds
+	Latency Duration `json:"latency,omitempty"`
+	// Timeout blocks the call until its context is done, as an unresponsive
+	// database would
+	Timeout bool `json:"timeout,omitempty"`
+	// Error fails the call with an error of the named kind: unavailable,
+	// not_found, conflict or validation
+	Error string `json:"error,omitempty"`
+	// Partial returns only the first half of the users read by GetAll or
+	// List, or of the entries read by History, and cuts Each off after its
+	// first user with Error or else as unavailable. Writes are carried out
+	// but still reported as failed, with Error or else as unavailable, as
+	// when a connection drops before the commit is acknowledged. Within a
+	// transaction the failure is reported by WithTx once the transaction
+	// has committed, so the write is not rolled back.
+	Partial bool `json:"partial,omitempty"`
+}
+
+func (f Fault) validate() error {
+	if f.Latency < 0 {
+		return fmt.Errorf("latency must not be negative")
+	}
+	if _, ok := errorKinds[f.Error]; f.Error != "" && !ok {
+		return fmt.Errorf("unknown error %q, expected unavailable, not_found, conflict or validation", f.Error)
+	}
+	return nil
+}
+
+func (f Fault) err() error {
+	if f.Error == "" {
+		return errorKinds["unavailable"]()
+	}
+	return errorKinds[f.Error]()
+}
+
+// Rule configures the faults injected into calls of a method. Calls first
+// consume Script, one Fault per call; once it is exhausted each call
+// suffers the embedded Fault with the given Probability.
+type Rule struct {
+	Fault
+	Probability float64 `json:"probability,omitempty"`
+	Script      []Fault `json:"script,omitempty"`
+}
+
+// Config maps method names, or AnyMethod, onto their Rule. Seed makes the
+// probabilistic faults repeatable; zero seeds from the clock.
+type Config struct {
+	Seed  int64           `json:"seed,omitempty"`
+	Rules map[string]Rule `json:"rules"`
+}
+
+// Validate reports the first problem with the configuration
+func (c Config) Validate() error {
+	for method, rule := range c.Rules {
+		if !knownMethod(method) {
+			return fmt.Errorf("unknown method %q", method)
+		}
+		if rule.Probability < 0 || rule.Probability > 1 {
+			return fmt.Errorf("rule %s: probability must be between 0 and 1", method)
+		}
+		if err := rule.Fault.validate(); err != nil {
+			return fmt.Errorf("rule %s: %v", method, err)
+		}
+		for i, fault := range rule.Script {
+			if err := fault.validate(); err != nil {
+				return fmt.Errorf("rule %s: script step %d: %v", method, i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+func knownMethod(method string) bool {
+	if method == AnyMethod {
+		return true
+	}
+	for _, m := range Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// FaultyUserRepository injects faults into the calls it passes on to the
+// UserRepository it wraps. It is safe for concurrent use and injects nothing
+// until configured.
+type FaultyUserRepository struct {
+	next repository.UserRepository
+	*injector
+	// committed is set on the views handed to transactions and receives the
+	// first failure of a partial write, reported once the transaction
+	// commits
+	committed *error
+}
+
+// injector holds the fault configuration, shared with the views of the
+// repository handed to transactions
+type injector struct {
+	mu     sync.Mutex
+	config Config
+	rand   *rand.Rand
+}
+
+// NewFaultyUserRepository convenience function to create a
+// FaultyUserRepository wrapping next
+func NewFaultyUserRepository(next repository.UserRepository, config Config) (*FaultyUserRepository, error) {
+	r := &FaultyUserRepository{next: next, injector: &injector{}}
+	if err := r.Configure(config); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Configure replaces the configuration, restarting every script. The zero
+// Config disables fault injection.
+func (r *FaultyUserRepository) Configure(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.config = copyConfig(config)
+	r.rand = rand.New(rand.NewSource(seed))
+	return nil
+}
+
+// Config returns the current configuration, with each script reduced to
+// the faults not yet injected
+func (r *FaultyUserRepository) Config() Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return copyConfig(r.config)
+}
+
+func copyConfig(config Config) Config {
+	rules := make(map[string]Rule, len(config.Rules))
+	for method, rule := range config.Rules {
+		rule.Script = append([]Fault(nil), rule.Script...)
+		rules[method] = rule
+	}
+	config.Rules = rules
+	return config
+}
+
+// pick chooses the fault for the next call of a method
+func (r *FaultyUserRepository) pick(method string) Fault {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := method
+	rule, ok := r.config.Rules[key]
+	if !ok {
+		key = AnyMethod
+		if rule, ok = r.config.Rules[key]; !ok {
+			return Fault{}
+		}
+	}
+	if len(rule.Script) > 0 {
+		fault := rule.Script[0]
+		rule.Script = rule.Script[1:]
+		r.config.Rules[key] = rule
+		return fault
+	}
+	if rule.Probability > 0 && r.rand.Float64() < rule.Probability {
+		return rule.Fault
+	}
+	return Fault{}
+}
+
+// inject picks the fault for a call and applies its latency, timeout and
+// error. The fault is returned so partial results can be applied once the
+// call has been passed on.
+func (r *FaultyUserRepository) inject(ctx context.Context, method string) (Fault, error) {
+	fault := r.pick(method)
+	if fault.Latency > 0 {
+		timer := time.NewTimer(time.Duration(fault.Latency))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return fault, models.UnavailableError{Message: "unavailable", Err: ctx.Err()}
+		}
+	}
+	if fault.Timeout {
+		<-ctx.Done()
+		return fault, models.UnavailableError{Message: "unavailable", Err: ctx.Err()}
+	}
+	if fault.Error != "" && !fault.Partial {
+		return fault, fault.err()
+	}
+	return fault, nil
+}
+
+func half(users []models.User) []models.User {
+	return users[:len(users)/2]
+}
+
+// GetAll get all users from the repository
+func (r *FaultyUserRepository) GetAll(ctx context.Context) ([]models.User, error) {
+	fault, err := r.inject(ctx, "GetAll")
+	if err != nil {
+		return nil, err
+	}
+	users, err := r.next.GetAll(ctx)
+	if err == nil && fault.Partial {
+		users = half(users)
+	}
+	return users, err
+}
+
+// GetByID get a user by string identifier
+func (r *FaultyUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
+	if _, err := r.inject(ctx, "GetByID"); err != nil {
+		return nil, err
+	}
+	return r.next.GetByID(ctx, id)
+}
+
+// List get a page of users from the repository
+func (r *FaultyUserRepository) List(ctx context.Context, opts repository.ListOptions) (*repository.UserPage, error) {
+	fault, err := r.inject(ctx, "List")
+	if err != nil {
+		return nil, err
+	}
+	page, err := r.next.List(ctx, opts)
+	if err == nil && fault.Partial {
+		page.Users = half(page.Users)
+	}
+	return page, err
+}
+
+// errCutOff stops the iteration of a partial Each after its first user
+var errCutOff = errors.New("cut off")
+
+// Each calls fn with every user matching opts
+func (r *FaultyUserRepository) Each(ctx context.Context, opts repository.ListOptions, fn func(models.User) error) error {
+	fault, err := r.inject(ctx, "Each")
+	if err != nil {
+		return err
+	}
+	if !fault.Partial {
+		return r.next.Each(ctx, opts, fn)
+	}
+	err = r.next.Each(ctx, opts, func(user models.User) error {
+		if err := fn(user); err != nil {
+			return err
+		}
+		return errCutOff
+	})
+	if err == errCutOff {
+		return fault.err()
+	}
+	return err
+}
+
+// Create a User to the repository
+func (r *FaultyUserRepository) Create(ctx context.Context, user models.User) (string, error) {
+	fault, err := r.inject(ctx, "Create")
+	if err != nil {
+		return "", err
+	}
+	id, err := r.next.Create(ctx, user)
+	if err == nil && fault.Partial {
+		if err := r.fail(fault); err != nil {
+			return "", err
+		}
+	}
+	return id, err
+}
+
+// CreateMany creates every user or, failing that, none of them
+func (r *FaultyUserRepository) CreateMany(ctx context.Context, users []models.User) ([]string, error) {
+	fault, err := r.inject(ctx, "CreateMany")
+	if err != nil {
+		return nil, err
+	}
+	ids, err := r.next.CreateMany(ctx, users)
+	if err == nil && fault.Partial {
+		if err := r.fail(fault); err != nil {
+			return nil, err
+		}
+	}
+	return ids, err
+}
+
+// Update replaces all mutable fields of an existing User in the repository
+func (r *FaultyUserRepository) Update(ctx context.Context, user models.User) error {
+	fault, err := r.inject(ctx, "Update")
+	if err != nil {
+		return err
+	}
+	err = r.next.Update(ctx, user)
+	if err == nil && fault.Partial {
+		return r.fail(fault)
+	}
+	return err
+}
+
+// Delete a User from the repository
+func (r *FaultyUserRepository) Delete(ctx context.Context, user models.User) error {
+	fault, err := r.inject(ctx, "Delete")
+	if err != nil {
+		return err
+	}
+	err = r.next.Delete(ctx, user)
+	if err == nil && fault.Partial {
+		return r.fail(fault)
+	}
+	return err
+}
+
+// Restore undoes the soft delete of a User
+func (r *FaultyUserRepository) Restore(ctx context.Context, user models.User) error {
+	fault, err := r.inject(ctx, "Restore")
+	if err != nil {
+		return err
+	}
+	err = r.next.Restore(ctx, user)
+	if err == nil && fault.Partial {
+		return r.fail(fault)
+	}
+	return err
+}
+
+// Purge permanently removes the users soft deleted before the given time
+func (r *FaultyUserRepository) Purge(ctx context.Context, before time.Time) (int, error) {
+	fault, err := r.inject(ctx, "Purge")
+	if err != nil {
+		return 0, err
+	}
+	purged, err := r.next.Purge(ctx, before)
+	if err == nil && fault.Partial {
+		if err := r.fail(fault); err != nil {
+			return 0, err
+		}
+	}
+	return purged, err
+}
+
+// History get a page of the audit trail of a user
+func (r *FaultyUserRepository) History(ctx context.Context, id string, opts repository.HistoryOptions) (*repository.AuditPage, error) {
+	fault, err := r.inject(ctx, "History")
+	if err != nil {
+		return nil, err
+	}
+	page, err := r.next.History(ctx, id, opts)
+	if err == nil && fault.Partial {
+		page.Entries = page.Entries[:len(page.Entries)/2]
+	}
+	return page, err
+}
+
+// WithTx runs fn in a transaction of the wrapped repository. Faults are
+// injected into beginning the transaction as well as into the operations
+// fn performs. The partial writes fn performs fail the transaction once it
+// has committed.
+func (r *FaultyUserRepository) WithTx(ctx context.Context, fn func(repository.UserRepository) error) error {
+	fault, err := r.inject(ctx, "WithTx")
+	if err != nil {
+		return err
+	}
+	var failed error
+	committed := r.committed
+	if committed == nil {
+		committed = &failed
+	}
+	// a fault recorded by an attempt the repository rolled back and retried
+	// did not commit, so each attempt starts from the faults recorded before
+	// it
+	prior := *committed
+	err = r.next.WithTx(ctx, func(tx repository.UserRepository) error {
+		*committed = prior
+		return fn(&FaultyUserRepository{next: tx, injector: r.injector, committed: committed})
+	})
+	if err == nil && fault.Partial {
+		return r.fail(fault)
+	}
+	if err == nil {
+		return failed
+	}
+	return err
+}
+
+// fail reports a partial write, which was carried out, as failed. Within a
+// transaction the failure is held back until the transaction commits, as
+// returning it would roll the write back.
+func (r *FaultyUserRepository) fail(fault Fault) error {
+	if r.committed == nil {
+		return fault.err()
+	}
+	if *r.committed == nil {
+		*r.committed = fault.err()
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package chaos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+	"github.com/ChrisTheShark/golang-mysql-api/repository/repositorytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFaultyRepository wraps a memory repository holding the named users
+func newFaultyRepository(t *testing.T, config Config, names ...string) *FaultyUserRepository {
+	next := repository.NewMemoryUserRepository()
+	for _, name := range names {
+		if _, err := next.Create(context.Background(), models.User{Name: name, Age: 43, Gender: "male"}); err != nil {
+			t.Fatalf("unable to create user due to: %v", err)
+		}
+	}
+	r, err := NewFaultyUserRepository(next, config)
+	if err != nil {
+		t.Fatalf("unable to create FaultyUserRepository due to: %v", err)
+	}
+	return r
+}
+
+func TestFaultyUserRepositoryConformance(t *testing.T) {
+	repositorytest.RunConformance(t, func(t *testing.T) repository.UserRepository {
+		return newFaultyRepository(t, Config{})
+	})
+}
+
+func TestScript(t *testing.T) {
+	r := newFaultyRepository(t, Config{Rules: map[string]Rule{
+		"GetByID": {Script: []Fault{{Error: "unavailable"}, {}, {Error: "not_found"}}},
+	}}, "James Bond")
+	ctx := context.Background()
+
+	_, err := r.GetByID(ctx, "1")
+	assert.True(t, errors.Is(err, models.ErrUnavailable))
+	assert.True(t, errors.Is(err, ErrInjected))
+	_, err = r.GetByID(ctx, "1")
+	assert.Nil(t, err)
+	_, err = r.GetByID(ctx, "1")
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+	_, err = r.GetByID(ctx, "1")
+	assert.Nil(t, err)
+
+	_, err = r.GetAll(ctx)
+	assert.Nil(t, err)
+}
+
+func TestAnyMethod(t *testing.T) {
+	r := newFaultyRepository(t, Config{Rules: map[string]Rule{
+		AnyMethod: {Fault: Fault{Error: "conflict"}, Probability: 1},
+		"GetAll":  {},
+	}}, "James Bond")
+	ctx := context.Background()
+
+	_, err := r.Create(ctx, models.User{Name: "Q", Age: 30, Gender: "male"})
+	assert.True(t, errors.Is(err, models.ErrConflict))
+	err = r.Delete(ctx, models.User{ID: "1"})
+	assert.True(t, errors.Is(err, models.ErrConflict))
+	users, err := r.GetAll(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, users, 1)
+}
+
+func TestProbability(t *testing.T) {
+	r := newFaultyRepository(t, Config{Seed: 7, Rules: map[string]Rule{
+		"GetByID": {Fault: Fault{Error: "unavailable"}, Probability: 0.5},
+	}}, "James Bond")
+
+	failures := 0
+	for i := 0; i < 200; i++ {
+		if _, err := r.GetByID(context.Background(), "1"); err != nil {
+			failures++
+		}
+	}
+
+	assert.True(t, failures > 50 && failures < 150, "expected about half of the calls to fail, got %d", failures)
+}
+
+func TestLatency(t *testing.T) {
+	r := newFaultyRepository(t, Config{Rules: map[string]Rule{
+		"GetAll": {Fault: Fault{Latency: Duration(20 * time.Millisecond)}, Probability: 1},
+	}})
+
+	start := time.Now()
+	_, err := r.GetAll(context.Background())
+
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+}
+
+func TestLatencyHonorsContext(t *testing.T) {
+	r := newFaultyRepository(t, Config{Rules: map[string]Rule{
+		"GetAll": {Fault: Fault{Latency: Duration(time.Minute)}, Probability: 1},
+	}})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := r.GetAll(ctx)
+
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestTimeout(t *testing.T) {
+	r := newFaultyRepository(t, Config{Rules: map[string]Rule{
+		"List": {Script: []Fault{{Timeout: true}}},
+	}})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	page, err := r.List(ctx, repository.ListOptions{})
+
+	assert.Nil(t, page)
+	assert.True(t, errors.Is(err, models.ErrUnavailable))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestPartialReads(t *testing.T) {
+	r := newFaultyRepository(t, Config{Rules: map[string]Rule{
+		AnyMethod: {Fault: Fault{Partial: true}, Probability: 1},
+	}}, "James Bond", "Felix Leiter", "Vesper Lynd", "Q")
+	ctx := context.Background()
+
+	users, err := r.GetAll(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, users, 2)
+
+	page, err := r.List(ctx, repository.ListOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, page.Users, 2)
+	assert.Equal(t, 4, page.Total)
+}
+
+func TestPartialEach(t *testing.T) {
+	r := newFaultyRepository(t, Config{Rules: map[string]Rule{
+		"Each": {Script: []Fault{{Partial: true}}},
+	}}, "James Bond", "Felix Leiter")
+
+	var names []string
+	err := r.Each(context.Background(), repository.ListOptions{}, func(user models.User) error {
+		names = append(names, user.Name)
+		return nil
+	})
+
+	assert.True(t, errors.Is(err, models.ErrUnavailable))
+	assert.Equal(t, []string{"James Bond"}, names)
+}
+
+func TestPartialWrites(t *testing.T) {
+	r := newFaultyRepository(t, Config{Rules: map[string]Rule{
+		"Create": {Script: []Fault{{Partial: true}, {Partial: true, Error: "conflict"}}},
+	}})
+	ctx := context.Background()
+
+	_, err := r.Create(ctx, models.User{Name: "James Bond", Age: 43, Gender: "male"})
+	assert.True(t, errors.Is(err, models.ErrUnavailable))
+	_, err = r.Create(ctx, models.User{Name: "Felix Leiter", Age: 40, Gender: "male"})
+	assert.True(t, errors.Is(err, models.ErrConflict))
+
+	users, _ := r.GetAll(ctx)
+	assert.Len(t, users, 2)
+}
+
+func TestConfigReportsRemainingScript(t *testing.T) {
+	r := newFaultyRepository(t, Config{Rules: map[string]Rule{
+		"GetByID": {Script: []Fault{{Error: "unavailable"}, {Error: "not_found"}}},
+	}})
+
+	r.GetByID(context.Background(), "1")
+
+	assert.Equal(t, []Fault{{Error: "not_found"}}, r.Config().Rules["GetByID"].Script)
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := map[string]Config{
+		"unknown method": {Rules: map[string]Rule{"Truncate": {}}},
+		"probability":    {Rules: map[string]Rule{"GetAll": {Probability: 1.5}}},
+		"error":          {Rules: map[string]Rule{"GetAll": {Fault: Fault{Error: "blamo"}}}},
+		"script":         {Rules: map[string]Rule{"GetAll": {Script: []Fault{{Latency: -1}}}}},
+	}
+	for name, config := range tests {
+		assert.NotNil(t, config.Validate(), name)
+		_, err := NewFaultyUserRepository(repository.NewMemoryUserRepository(), config)
+		assert.NotNil(t, err, name)
+	}
+	assert.Nil(t, Config{}.Validate())
+}
+
+func TestConfigJSON(t *testing.T) {
+	var config Config
+	err := json.Unmarshal([]byte(`{"rules": {"GetByID": {
+		"error": "unavailable", "probability": 0.25,
+		"script": [{"latency": "150ms"}, {"timeout": true}]
+	}}}`), &config)
+	if err != nil {
+		t.Fatalf("unable to decode config due to: %v", err)
+	}
+
+	expected := Config{Rules: map[string]Rule{
+		"GetByID": {
+			Fault:       Fault{Error: "unavailable"},
+			Probability: 0.25,
+			Script:      []Fault{{Latency: Duration(150 * time.Millisecond)}, {Timeout: true}},
+		},
+	}}
+	assert.Equal(t, expected, config)
+
+	bs, _ := json.Marshal(Fault{Latency: Duration(time.Second)})
+	assert.Equal(t, `{"latency":"1s"}`, string(bs))
+}
+
+func TestWithTx(t *testing.T) {
+	r := newFaultyRepository(t, Config{Rules: map[string]Rule{
+		"WithTx": {Script: []Fault{{Error: "unavailable"}}},
+		"Delete": {Script: []Fault{{Error: "conflict"}}},
+	}}, "James Bond")
+	ctx := context.Background()
+	deleteUser := func(tx repository.UserRepository) error {
+		return tx.Delete(ctx, models.User{ID: "1"})
+	}
+
+	err := r.WithTx(ctx, deleteUser)
+	assert.True(t, errors.Is(err, models.ErrUnavailable))
+	err = r.WithTx(ctx, deleteUser)
+	assert.True(t, errors.Is(err, models.ErrConflict))
+	err = r.WithTx(ctx, deleteUser)
+	assert.Nil(t, err)
+}
+
+func TestPartialWritesWithinTxAreCommitted(t *testing.T) {
+	r := newFaultyRepository(t, Config{Rules: map[string]Rule{
+		"Create": {Script: []Fault{{Partial: true, Error: "conflict"}}},
+	}})
+	ctx := context.Background()
+
+	created := 0
+	err := r.WithTx(ctx, func(tx repository.UserRepository) error {
+		for _, name := range []string{"James Bond", "Felix Leiter"} {
+			if _, err := tx.Create(ctx, models.User{Name: name, Age: 43, Gender: "male"}); err != nil {
+				return err
+			}
+			created++
+		}
+		return nil
+	})
+
+	assert.True(t, errors.Is(err, models.ErrConflict))
+	assert.Equal(t, 2, created)
+	users, _ := r.GetAll(ctx)
+	assert.Len(t, users, 2)
+}
+
+// retryingRepository rolls back the first attempt of every transaction and
+// retries it, as the SQL repository does on a deadlock
+type retryingRepository struct {
+	repository.UserRepository
+}
+
+var errDeadlock = errors.New("deadlock")
+
+func (r retryingRepository) WithTx(ctx context.Context, fn func(repository.UserRepository) error) error {
+	err := r.UserRepository.WithTx(ctx, func(tx repository.UserRepository) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return errDeadlock
+	})
+	if err != errDeadlock {
+		return err
+	}
+	return r.UserRepository.WithTx(ctx, fn)
+}
+
+func TestPartialWritesWithinRetriedTxAreForgotten(t *testing.T) {
+	r, err := NewFaultyUserRepository(retryingRepository{repository.NewMemoryUserRepository()}, Config{Rules: map[string]Rule{
+		"Create": {Script: []Fault{{Partial: true, Error: "conflict"}}},
+	}})
+	if err != nil {
+		t.Fatalf("unable to create FaultyUserRepository due to: %v", err)
+	}
+	ctx := context.Background()
+
+	err = r.WithTx(ctx, func(tx repository.UserRepository) error {
+		_, err := tx.Create(ctx, models.User{Name: "James Bond", Age: 43, Gender: "male"})
+		return err
+	})
+
+	assert.Nil(t, err)
+	users, _ := r.GetAll(ctx)
+	assert.Len(t, users, 1)
+}
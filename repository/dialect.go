@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// dialect captures the differences between the SQL databases backing
+// UserRepositoryImpl
+type dialect struct {
+	name string
+	// classify returns the models sentinel error describing a driver error,
+	// or nil when the error does not belong to a known class
+	classify func(error) error
+	// numbered reports whether bind parameters are written $1, $2, ...
+	// rather than ?
+	numbered bool
+	// returning reports whether inserts report the generated id through a
+	// RETURNING clause rather than LastInsertId
+	returning bool
+	// lastInsertIsLast reports whether LastInsertId identifies the last row
+	// of a multi-row insert rather than the first
+	lastInsertIsLast bool
+	// idStep, when set, queries the increment between the IDs generated for
+	// consecutive rows, which is otherwise 1
+	idStep string
+	// lockRows is appended to a select to lock the rows it reads until the
+	// end of the transaction
+	lockRows string
+	// retryable reports whether a transaction failed only because it lost a
+	// deadlock or a serialization conflict and may succeed if run again
+	retryable func(error) bool
+}
+
+var mysqlDialect = dialect{
+	name:      "mysql",
+	classify:  classifyMySQL,
+	idStep:    "select @@auto_increment_increment",
+	lockRows:  " for update",
+	retryable: retryableMySQL,
+}
+
+// SQLite locks the whole database for the duration of a write transaction,
+// so rows need no explicit lock.
+var sqliteDialect = dialect{
+	name:             "sqlite",
+	classify:         classifySQLite,
+	lastInsertIsLast: true,
+	retryable:        retryableSQLite,
+}
+
+var postgresDialect = dialect{
+	name:      "postgres",
+	classify:  classifyPostgres,
+	numbered:  true,
+	returning: true,
+	lockRows:  " for update",
+	retryable: retryablePostgres,
+}
+
+// rebind rewrites the ? bind parameters of a query into the dialect's
+// placeholder syntax. Question marks inside quoted literals are left alone.
+func (d dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// MySQL server error numbers mapped onto the models error taxonomy, see
+// https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+var mysqlErrorKinds = map[uint16]error{
+	1048: models.ErrValidation, // ER_BAD_NULL_ERROR
+	1062: models.ErrConflict,   // ER_DUP_ENTRY
+	1264: models.ErrValidation, // ER_WARN_DATA_OUT_OF_RANGE
+	1366: models.ErrValidation, // ER_TRUNCATED_WRONG_VALUE_FOR_FIELD
+	1406: models.ErrValidation, // ER_DATA_TOO_LONG
+	1451: models.ErrConflict,   // ER_ROW_IS_REFERENCED_2
+	1452: models.ErrConflict,   // ER_NO_REFERENCED_ROW_2
+}
+
+func classifyMySQL(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErrorKinds[mysqlErr.Number]
+	}
+	return nil
+}
+
+func retryableMySQL(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		// ER_LOCK_DEADLOCK and ER_LOCK_WAIT_TIMEOUT
+		return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
+	}
+	return false
+}
+
+// SQLite constraint failures are recognized by message rather than by the
+// driver's error type so the repository does not depend on a cgo driver.
+var sqliteErrorKinds = map[string]error{
+	"UNIQUE constraint failed":      models.ErrConflict,
+	"PRIMARY KEY constraint failed": models.ErrConflict,
+	"FOREIGN KEY constraint failed": models.ErrConflict,
+	"NOT NULL constraint failed":    models.ErrValidation,
+	"CHECK constraint failed":       models.ErrValidation,
+}
+
+func classifySQLite(err error) error {
+	for message, kind := range sqliteErrorKinds {
+		if strings.Contains(err.Error(), message) {
+			return kind
+		}
+	}
+	return nil
+}
+
+// retryableSQLite recognizes SQLITE_BUSY, reported when another connection
+// holds the database lock
+func retryableSQLite(err error) bool {
+	return strings.Contains(err.Error(), "database is locked")
+}
+
+// Postgres SQLSTATE codes mapped onto the models error taxonomy, see
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+var postgresErrorKinds = map[pq.ErrorCode]error{
+	"22001": models.ErrValidation, // string_data_right_truncation
+	"22003": models.ErrValidation, // numeric_value_out_of_range
+	"23502": models.ErrValidation, // not_null_violation
+	"23503": models.ErrConflict,   // foreign_key_violation
+	"23505": models.ErrConflict,   // unique_violation
+	"23514": models.ErrValidation, // check_violation
+}
+
+func classifyPostgres(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return postgresErrorKinds[pqErr.Code]
+	}
+	return nil
+}
+
+func retryablePostgres(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// serialization_failure and deadlock_detected
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRebind(t *testing.T) {
+	query := "select id from users where name like ? escape '!' and age in (?, ?) limit ? offset ?"
+
+	assert.Equal(t, query, mysqlDialect.rebind(query))
+	assert.Equal(t,
+		"select id from users where name like $1 escape '!' and age in ($2, $3) limit $4 offset $5",
+		postgresDialect.rebind(query))
+	assert.Equal(t, "select '?' from users where id = $1", postgresDialect.rebind("select '?' from users where id = ?"))
+}
+
+func TestClassifyPostgres(t *testing.T) {
+	assert.Equal(t, models.ErrConflict, classifyPostgres(&pq.Error{Code: "23505"}))
+	assert.Equal(t, models.ErrValidation, classifyPostgres(&pq.Error{Code: "23514"}))
+	assert.Nil(t, classifyPostgres(&pq.Error{Code: "57014"}))
+	assert.Nil(t, classifyPostgres(errors.New("blamo")))
+}
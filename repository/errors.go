@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+)
+
+// wrapError classifies a database error into the models error taxonomy,
+// prefixing it with a description of the failed operation. Errors that are
+// already classified, such as not found errors, are returned unchanged.
+func (d dialect) wrapError(message string, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFound()
+	}
+	if errors.Is(err, models.ErrNotFound) || errors.Is(err, models.ErrConflict) ||
+		errors.Is(err, models.ErrValidation) || errors.Is(err, models.ErrUnavailable) ||
+		errors.Is(err, models.ErrVersionMismatch) {
+		return err
+	}
+
+	switch d.classify(err) {
+	case models.ErrConflict:
+		return models.ConflictError{Message: message, Err: err}
+	case models.ErrValidation:
+		return models.ValidationError{Message: message, Err: err}
+	}
+	return models.UnavailableError{Message: message, Err: err}
+}
+
+func notFound() error {
+	return models.UserNotFoundError{
+		Message: "not found",
+	}
+}
+
+func notDeleted() error {
+	return models.ConflictError{
+		Message: "user is not deleted",
+	}
+}
+
+func versionMismatch() error {
+	return models.VersionMismatchError{
+		Message: "version mismatch",
+	}
+}
@@ -0,0 +1,264 @@
+package repository
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+)
+
+// FilterOp is a comparison applied by a filter Condition
+type FilterOp string
+
+// Supported filter operators
+const (
+	OpEq     FilterOp = "eq"
+	OpNe     FilterOp = "ne"
+	OpGt     FilterOp = "gt"
+	OpGte    FilterOp = "gte"
+	OpLt     FilterOp = "lt"
+	OpLte    FilterOp = "lte"
+	OpPrefix FilterOp = "prefix" // ignoring case
+	OpIn     FilterOp = "in"
+)
+
+const (
+	// maxFilterValueLength bounds the length of a single filter value
+	maxFilterValueLength = 255
+	// maxFilterInValues bounds the number of values accepted by OpIn
+	maxFilterInValues = 100
+)
+
+// Condition restricts a listing to users whose field compares to Value
+// using Op. Value holds an integer for numeric fields and a string
+// otherwise; for OpIn it holds a slice of int or string.
+type Condition struct {
+	Field string
+	Op    FilterOp
+	Value interface{}
+}
+
+// Filter is a conjunction of conditions; a user must satisfy all of them to
+// be listed. The zero value matches every user.
+type Filter struct {
+	Conditions []Condition
+}
+
+// filterField describes a field users may be filtered on
+type filterField struct {
+	numeric bool
+	ops     map[FilterOp]bool
+}
+
+func ops(list ...FilterOp) map[FilterOp]bool {
+	m := map[FilterOp]bool{}
+	for _, op := range list {
+		m[op] = true
+	}
+	return m
+}
+
+// filterFields whitelists the fields and operators accepted by ParseFilter.
+// Keys double as column names so they may be interpolated into SQL safely.
+var filterFields = map[string]filterField{
+	"id":     {true, ops(OpEq, OpNe, OpIn)},
+	"name":   {false, ops(OpEq, OpNe, OpPrefix, OpIn)},
+	"age":    {true, ops(OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpIn)},
+	"gender": {false, ops(OpEq, OpNe, OpIn)},
+}
+
+var filterKey = regexp.MustCompile(`^([a-z_]+)(?:\[([a-z]+)\])?$`)
+
+// ParseFilter parses query parameters of the form field=value or
+// field[op]=value, e.g. age[gte]=30&gender=female&name[prefix]=J, into a
+// Filter. Unknown fields, unsupported operators and malformed values are
+// rejected. Callers are expected to remove parameters unrelated to
+// filtering, such as paging, before calling ParseFilter.
+func ParseFilter(q url.Values) (Filter, error) {
+	var f Filter
+
+	// Sort keys so conditions, and the SQL compiled from them, are stable.
+	keys := make([]string, 0, len(q))
+	for key := range q {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		m := filterKey.FindStringSubmatch(key)
+		if m == nil {
+			return Filter{}, fmt.Errorf("unable to filter by malformed parameter %q", key)
+		}
+		name, op := m[1], FilterOp(m[2])
+		if op == "" {
+			op = OpEq
+		}
+		field, ok := filterFields[name]
+		if !ok {
+			return Filter{}, fmt.Errorf("unable to filter by unknown field %q", name)
+		}
+		if !field.ops[op] {
+			return Filter{}, fmt.Errorf("unable to filter field %q with operator %q", name, op)
+		}
+		for _, raw := range q[key] {
+			value, err := parseFilterValue(field, op, raw)
+			if err != nil {
+				return Filter{}, fmt.Errorf("unable to filter field %q: %v", name, err)
+			}
+			f.Conditions = append(f.Conditions, Condition{Field: name, Op: op, Value: value})
+		}
+	}
+	return f, nil
+}
+
+func parseFilterValue(field filterField, op FilterOp, raw string) (interface{}, error) {
+	if op != OpIn {
+		return parseFilterScalar(field, raw)
+	}
+	parts := strings.Split(raw, ",")
+	if len(parts) > maxFilterInValues {
+		return nil, fmt.Errorf("at most %d values may be listed", maxFilterInValues)
+	}
+	if field.numeric {
+		values := make([]int, len(parts))
+		for i, part := range parts {
+			v, err := parseFilterScalar(field, part)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v.(int)
+		}
+		return values, nil
+	}
+	for _, part := range parts {
+		if _, err := parseFilterScalar(field, part); err != nil {
+			return nil, err
+		}
+	}
+	return parts, nil
+}
+
+func parseFilterScalar(field filterField, raw string) (interface{}, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("value must not be empty")
+	}
+	if len(raw) > maxFilterValueLength {
+		return nil, fmt.Errorf("value must not exceed %d characters", maxFilterValueLength)
+	}
+	for _, r := range raw {
+		if unicode.IsControl(r) {
+			return nil, fmt.Errorf("value must not contain control characters")
+		}
+	}
+	if !field.numeric {
+		return raw, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return nil, fmt.Errorf("value %q is not an integer", raw)
+	}
+	return v, nil
+}
+
+// likeEscaper escapes LIKE wildcards using '!', which unlike backslash is
+// treated identically by every SQL dialect.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+var sqlOps = map[FilterOp]string{
+	OpEq:  " = ?",
+	OpNe:  " <> ?",
+	OpGt:  " > ?",
+	OpGte: " >= ?",
+	OpLt:  " < ?",
+	OpLte: " <= ?",
+}
+
+// clause compiles the filter to a parameterized SQL predicate. It returns an
+// empty string when the filter has no conditions.
+func (f Filter) clause() (string, []interface{}) {
+	var parts []string
+	var args []interface{}
+	for _, c := range f.Conditions {
+		switch c.Op {
+		case OpPrefix:
+			// LIKE is case-insensitive in MySQL and SQLite but not in
+			// Postgres, so case is folded explicitly.
+			parts = append(parts, "lower("+c.Field+") like lower(?) escape '!'")
+			args = append(args, likeEscaper.Replace(c.Value.(string))+"%")
+		case OpIn:
+			var values []interface{}
+			switch v := c.Value.(type) {
+			case []int:
+				for _, n := range v {
+					values = append(values, n)
+				}
+			case []string:
+				for _, s := range v {
+					values = append(values, s)
+				}
+			}
+			marks := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+			parts = append(parts, c.Field+" in ("+marks+")")
+			args = append(args, values...)
+		default:
+			parts = append(parts, c.Field+sqlOps[c.Op])
+			args = append(args, c.Value)
+		}
+	}
+	return strings.Join(parts, " and "), args
+}
+
+// Matches reports whether a user satisfies every condition of the filter
+func (f Filter) Matches(user models.User) bool {
+	for _, c := range f.Conditions {
+		if !c.matches(user) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Condition) matches(user models.User) bool {
+	column := sortColumns[c.Field]
+	actual := column.value(user)
+	compare := func(expected interface{}) int {
+		return compareValues(column.numeric, actual, fmt.Sprint(expected))
+	}
+	switch c.Op {
+	case OpEq:
+		return compare(c.Value) == 0
+	case OpNe:
+		return compare(c.Value) != 0
+	case OpGt:
+		return compare(c.Value) > 0
+	case OpGte:
+		return compare(c.Value) >= 0
+	case OpLt:
+		return compare(c.Value) < 0
+	case OpLte:
+		return compare(c.Value) <= 0
+	case OpPrefix:
+		return strings.HasPrefix(strings.ToLower(actual), strings.ToLower(c.Value.(string)))
+	case OpIn:
+		switch v := c.Value.(type) {
+		case []int:
+			for _, n := range v {
+				if compare(n) == 0 {
+					return true
+				}
+			}
+		case []string:
+			for _, s := range v {
+				if compare(s) == 0 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
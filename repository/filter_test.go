@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFilter(t *testing.T) {
+	q, _ := url.ParseQuery("age[gte]=30&gender=female&name[prefix]=J&id[in]=1,2")
+	f, err := ParseFilter(q)
+	if err != nil {
+		t.Fatalf("unable to parse filter due to: %v", err)
+	}
+
+	assert.Equal(t, []Condition{
+		{Field: "age", Op: OpGte, Value: 30},
+		{Field: "gender", Op: OpEq, Value: "female"},
+		{Field: "id", Op: OpIn, Value: []int{1, 2}},
+		{Field: "name", Op: OpPrefix, Value: "J"},
+	}, f.Conditions)
+}
+
+func TestParseFilterInvalid(t *testing.T) {
+	for _, query := range []string{
+		"password=secret",
+		"age[like]=3",
+		"gender[gt]=female",
+		"age=thirty",
+		"age[in]=30,abc",
+		"name=",
+		"name[prefix]=%00",
+		"name%3Bdrop+table+users=1",
+		"name+or+1%3D1=1",
+		"name[prefix]]=J",
+	} {
+		q, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatalf("unable to parse query %q due to: %v", query, err)
+		}
+		_, err = ParseFilter(q)
+		assert.NotNil(t, err, query)
+	}
+}
+
+func TestFilterClause(t *testing.T) {
+	f := Filter{Conditions: []Condition{
+		{Field: "age", Op: OpGte, Value: 30},
+		{Field: "age", Op: OpLt, Value: 40},
+		{Field: "gender", Op: OpIn, Value: []string{"female", "male"}},
+		{Field: "name", Op: OpPrefix, Value: "50%_off!"},
+	}}
+	clause, args := f.clause()
+
+	assert.Equal(t, "age >= ? and age < ? and gender in (?, ?) and lower(name) like lower(?) escape '!'", clause)
+	assert.Equal(t, []interface{}{30, 40, "female", "male", "50!%!_off!!%"}, args)
+}
+
+func TestFilterClauseEmpty(t *testing.T) {
+	clause, args := Filter{}.clause()
+
+	assert.Empty(t, clause)
+	assert.Empty(t, args)
+}
+
+func TestFilterMatches(t *testing.T) {
+	q, _ := url.ParseQuery("age[gte]=40&gender[ne]=female&name[prefix]=J")
+	f, _ := ParseFilter(q)
+
+	page, err := PageUsers(listUsers, ListOptions{Filter: f})
+	if err != nil {
+		t.Fatalf("unable to page users due to: %v", err)
+	}
+
+	assert.Equal(t, []string{"1"}, userIDs(page.Users))
+	assert.Equal(t, 1, page.Total)
+}
+
+func TestFilterMatchesIn(t *testing.T) {
+	q, _ := url.ParseQuery("id[in]=2,10&age[ne]=30")
+	f, _ := ParseFilter(q)
+
+	page, err := PageUsers(listUsers, ListOptions{Filter: f})
+	if err != nil {
+		t.Fatalf("unable to page users due to: %v", err)
+	}
+
+	assert.Equal(t, []string{"2"}, userIDs(page.Users))
+}
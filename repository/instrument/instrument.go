@@ -0,0 +1,174 @@
+// Package instrument decorates a UserRepository with metrics timing every
+// call and counting the calls that fail, by method and class of error.
+package instrument
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// InstrumentedUserRepository records the duration of every call to the
+// UserRepository it wraps in repository_call_duration_seconds and counts
+// those that fail in repository_errors_total. Calls made within WithTx are
+// recorded too. It is safe for concurrent use.
+type InstrumentedUserRepository struct {
+	next     repository.UserRepository
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+// NewInstrumentedUserRepository convenience function to create an
+// InstrumentedUserRepository wrapping next, registering its metrics with
+// registerer
+func NewInstrumentedUserRepository(next repository.UserRepository, registerer prometheus.Registerer) *InstrumentedUserRepository {
+	r := &InstrumentedUserRepository{
+		next: next,
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "repository_call_duration_seconds",
+			Help:    "Duration of UserRepository calls by method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "repository_errors_total",
+			Help: "UserRepository calls that failed, by method and kind of error.",
+		}, []string{"method", "kind"}),
+	}
+	registerer.MustRegister(r.duration, r.errors)
+	return r
+}
+
+// ErrorKind names the class of a repository error as recorded in the kind
+// label: timeout, canceled, not_found, version_mismatch, conflict,
+// validation, unavailable or, for anything else, other
+func ErrorKind(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, models.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, models.ErrVersionMismatch):
+		return "version_mismatch"
+	case errors.Is(err, models.ErrConflict):
+		return "conflict"
+	case errors.Is(err, models.ErrValidation):
+		return "validation"
+	case errors.Is(err, models.ErrUnavailable):
+		return "unavailable"
+	}
+	return "other"
+}
+
+// observe records a call to method that began at start and ended with err
+func (r *InstrumentedUserRepository) observe(method string, start time.Time, err error) {
+	r.duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		r.errors.WithLabelValues(method, ErrorKind(err)).Inc()
+	}
+}
+
+// GetAll get all users from the repository
+func (r *InstrumentedUserRepository) GetAll(ctx context.Context) ([]models.User, error) {
+	start := time.Now()
+	users, err := r.next.GetAll(ctx)
+	r.observe("GetAll", start, err)
+	return users, err
+}
+
+// GetByID get a user by string identifier
+func (r *InstrumentedUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
+	start := time.Now()
+	user, err := r.next.GetByID(ctx, id)
+	r.observe("GetByID", start, err)
+	return user, err
+}
+
+// List get a page of users from the repository
+func (r *InstrumentedUserRepository) List(ctx context.Context, opts repository.ListOptions) (*repository.UserPage, error) {
+	start := time.Now()
+	page, err := r.next.List(ctx, opts)
+	r.observe("List", start, err)
+	return page, err
+}
+
+// Each calls fn with every user matching opts. The duration recorded spans
+// the whole iteration, fn included.
+func (r *InstrumentedUserRepository) Each(ctx context.Context, opts repository.ListOptions, fn func(models.User) error) error {
+	start := time.Now()
+	err := r.next.Each(ctx, opts, fn)
+	r.observe("Each", start, err)
+	return err
+}
+
+// Create a User to the repository
+func (r *InstrumentedUserRepository) Create(ctx context.Context, user models.User) (string, error) {
+	start := time.Now()
+	id, err := r.next.Create(ctx, user)
+	r.observe("Create", start, err)
+	return id, err
+}
+
+// CreateMany creates every user or, failing that, none of them
+func (r *InstrumentedUserRepository) CreateMany(ctx context.Context, users []models.User) ([]string, error) {
+	start := time.Now()
+	ids, err := r.next.CreateMany(ctx, users)
+	r.observe("CreateMany", start, err)
+	return ids, err
+}
+
+// Update replaces all mutable fields of an existing User in the repository
+func (r *InstrumentedUserRepository) Update(ctx context.Context, user models.User) error {
+	start := time.Now()
+	err := r.next.Update(ctx, user)
+	r.observe("Update", start, err)
+	return err
+}
+
+// Delete a User from the repository
+func (r *InstrumentedUserRepository) Delete(ctx context.Context, user models.User) error {
+	start := time.Now()
+	err := r.next.Delete(ctx, user)
+	r.observe("Delete", start, err)
+	return err
+}
+
+// Restore undoes the soft delete of a User
+func (r *InstrumentedUserRepository) Restore(ctx context.Context, user models.User) error {
+	start := time.Now()
+	err := r.next.Restore(ctx, user)
+	r.observe("Restore", start, err)
+	return err
+}
+
+// Purge permanently removes the users soft deleted before the given time
+func (r *InstrumentedUserRepository) Purge(ctx context.Context, before time.Time) (int, error) {
+	start := time.Now()
+	purged, err := r.next.Purge(ctx, before)
+	r.observe("Purge", start, err)
+	return purged, err
+}
+
+// History get a page of the audit trail of a user
+func (r *InstrumentedUserRepository) History(ctx context.Context, id string, opts repository.HistoryOptions) (*repository.AuditPage, error) {
+	start := time.Now()
+	page, err := r.next.History(ctx, id, opts)
+	r.observe("History", start, err)
+	return page, err
+}
+
+// WithTx runs fn in a transaction of the wrapped repository, recording the
+// calls fn makes as well as the transaction as a whole
+func (r *InstrumentedUserRepository) WithTx(ctx context.Context, fn func(repository.UserRepository) error) error {
+	start := time.Now()
+	err := r.next.WithTx(ctx, func(tx repository.UserRepository) error {
+		return fn(&InstrumentedUserRepository{next: tx, duration: r.duration, errors: r.errors})
+	})
+	r.observe("WithTx", start, err)
+	return err
+}
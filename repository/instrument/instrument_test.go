@@ -0,0 +1,98 @@
+package instrument
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+	mocks "github.com/ChrisTheShark/golang-mysql-api/repository/mocks"
+	"github.com/ChrisTheShark/golang-mysql-api/repository/repositorytest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// callCount returns the number of calls to method timed by r
+func callCount(t *testing.T, r *InstrumentedUserRepository, method string) int {
+	t.Helper()
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(r.duration)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unable to gather metrics due to: %v", err)
+	}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			if m.GetLabel()[0].GetValue() == method {
+				return int(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return 0
+}
+
+func TestInstrumentedUserRepositoryConformance(t *testing.T) {
+	repositorytest.RunConformance(t, func(t *testing.T) repository.UserRepository {
+		return NewInstrumentedUserRepository(repository.NewMemoryUserRepository(), prometheus.NewRegistry())
+	})
+}
+
+func TestCallsAreTimed(t *testing.T) {
+	r := NewInstrumentedUserRepository(mocks.NewMockUserRepository(), prometheus.NewRegistry())
+	ctx := context.Background()
+
+	r.GetByID(ctx, "1")
+	r.GetByID(ctx, "2")
+	r.GetAll(ctx)
+
+	assert.Equal(t, 2, callCount(t, r, "GetByID"))
+	assert.Equal(t, 1, callCount(t, r, "GetAll"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(r.errors.WithLabelValues("GetByID", "not_found")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(r.errors.WithLabelValues("GetAll", "not_found")))
+}
+
+func TestErrorsAreCountedByKind(t *testing.T) {
+	r := NewInstrumentedUserRepository(mocks.NewMockTimeoutUserRepository(), prometheus.NewRegistry())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r.List(ctx, repository.ListOptions{})
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(r.errors.WithLabelValues("List", "canceled")))
+}
+
+func TestCallsWithinTxAreTimed(t *testing.T) {
+	r := NewInstrumentedUserRepository(repository.NewMemoryUserRepository(), prometheus.NewRegistry())
+	ctx := context.Background()
+
+	err := r.WithTx(ctx, func(tx repository.UserRepository) error {
+		_, err := tx.Create(ctx, models.User{Name: "Q", Gender: "male", Age: 30})
+		return err
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, callCount(t, r, "Create"))
+	assert.Equal(t, 1, callCount(t, r, "WithTx"))
+}
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind string
+	}{
+		{context.DeadlineExceeded, "timeout"},
+		{models.UnavailableError{Message: "x", Err: context.Canceled}, "canceled"},
+		{models.UserNotFoundError{Message: "x"}, "not_found"},
+		{models.VersionMismatchError{Message: "x"}, "version_mismatch"},
+		{models.ConflictError{Message: "x"}, "conflict"},
+		{models.ValidationError{Message: "x"}, "validation"},
+		{models.UnavailableError{Message: "x", Err: errors.New("reset")}, "unavailable"},
+		{fmt.Errorf("wrapped: %w", errors.New("boom")), "other"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.kind, ErrorKind(test.err), test.err.Error())
+	}
+}
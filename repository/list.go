@@ -0,0 +1,330 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+)
+
+const (
+	// DefaultLimit is the page size used when ListOptions does not specify one
+	DefaultLimit = 100
+	// MaxLimit is the largest page size a single List call will return
+	MaxLimit = 1000
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded or
+// was issued for a different sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SortField orders a listing by a single column
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+func (s SortField) String() string {
+	if s.Descending {
+		return "-" + s.Field
+	}
+	return s.Field
+}
+
+// ListOptions describes which page of users a List call returns. Offset and
+// Cursor are mutually exclusive; Cursor is an opaque value taken from a
+// previous UserPage. Only users matching Filter are listed or counted, and
+// soft deleted users only when IncludeDeleted is set.
+type ListOptions struct {
+	Limit          int
+	Offset         int
+	Cursor         string
+	Sort           []SortField
+	Filter         Filter
+	IncludeDeleted bool
+}
+
+// UserPage is a single page of users along with the total number of users
+// matching the listing and, when more remain, a cursor to the next page.
+type UserPage struct {
+	Users      []models.User
+	Total      int
+	NextCursor string
+}
+
+// sortColumn describes a column users may be ordered by
+type sortColumn struct {
+	numeric bool
+	value   func(models.User) string
+}
+
+// sortColumns whitelists the fields accepted by ParseSort. Keys double as
+// column names so they may be interpolated into SQL safely.
+var sortColumns = map[string]sortColumn{
+	"id":     {true, func(u models.User) string { return u.ID }},
+	"name":   {false, func(u models.User) string { return u.Name }},
+	"age":    {true, func(u models.User) string { return strconv.Itoa(u.Age) }},
+	"gender": {false, func(u models.User) string { return u.Gender }},
+}
+
+// ParseSort parses a comma separated sort specification such as
+// "name,-age", where a leading minus sign requests descending order.
+func ParseSort(spec string) ([]SortField, error) {
+	var fields []SortField
+	seen := map[string]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		field := SortField{Field: strings.TrimPrefix(part, "-"), Descending: strings.HasPrefix(part, "-")}
+		if _, ok := sortColumns[field.Field]; !ok {
+			return nil, fmt.Errorf("unable to sort by unknown field %q", field.Field)
+		}
+		if seen[field.Field] {
+			return nil, fmt.Errorf("unable to sort by field %q more than once", field.Field)
+		}
+		seen[field.Field] = true
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// normalize applies default and maximum limits and makes the sort order
+// total by appending the id column when it is not already present.
+func (o ListOptions) normalize() ListOptions {
+	if o.Limit <= 0 {
+		o.Limit = DefaultLimit
+	}
+	if o.Limit > MaxLimit {
+		o.Limit = MaxLimit
+	}
+	if o.Offset < 0 {
+		o.Offset = 0
+	}
+	fields := make([]SortField, 0, len(o.Sort)+1)
+	for _, field := range o.Sort {
+		fields = append(fields, field)
+		if field.Field == "id" {
+			o.Sort = fields
+			return o
+		}
+	}
+	o.Sort = append(fields, SortField{Field: "id"})
+	return o
+}
+
+func sortSpec(fields []SortField) string {
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		parts[i] = field.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// cursor is the decoded form of an opaque keyset pagination cursor. It holds
+// the sort key values of the last user on the previous page.
+type cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+func encodeCursor(fields []SortField, user models.User) string {
+	c := cursor{Sort: sortSpec(fields)}
+	for _, field := range fields {
+		c.Values = append(c.Values, sortColumns[field.Field].value(user))
+	}
+	bs, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+// decodeCursor returns the sort key values held by an opaque cursor
+func decodeCursor(fields []SortField, token string) ([]string, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(bs, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != sortSpec(fields) || len(c.Values) != len(fields) {
+		return nil, ErrInvalidCursor
+	}
+	for i, field := range fields {
+		if !sortColumns[field.Field].numeric {
+			continue
+		}
+		if _, err := strconv.ParseInt(c.Values[i], 10, 64); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return c.Values, nil
+}
+
+// compareUsers orders two users by the supplied sort fields
+func compareUsers(fields []SortField, a, b models.User) int {
+	for _, field := range fields {
+		column := sortColumns[field.Field]
+		c := compareValues(column.numeric, column.value(a), column.value(b))
+		if field.Descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareValues(numeric bool, a, b string) int {
+	if numeric {
+		x, errA := strconv.ParseInt(a, 10, 64)
+		y, errB := strconv.ParseInt(b, 10, 64)
+		if errA == nil && errB == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// sortUsers orders users in place by the supplied sort fields
+func sortUsers(users []models.User, fields []SortField) {
+	sort.SliceStable(users, func(i, j int) bool {
+		return compareUsers(fields, users[i], users[j]) < 0
+	})
+}
+
+// SelectUsers returns the users of an in memory collection matching the
+// Filter and IncludeDeleted of ListOptions, in its sort order. Paging is
+// ignored, as it is by Each.
+func SelectUsers(users []models.User, opts ListOptions) []models.User {
+	opts = opts.normalize()
+	selected := make([]models.User, 0, len(users))
+	for _, user := range users {
+		if (opts.IncludeDeleted || user.DeletedAt == nil) && opts.Filter.Matches(user) {
+			selected = append(selected, user)
+		}
+	}
+	sortUsers(selected, opts.Sort)
+	return selected
+}
+
+// PageUsers applies ListOptions to an in memory collection of users. It lets
+// implementations without a query engine share the paging semantics of
+// UserRepositoryImpl.
+func PageUsers(users []models.User, opts ListOptions) (*UserPage, error) {
+	opts = opts.normalize()
+	sorted := SelectUsers(users, opts)
+
+	page := &UserPage{Users: []models.User{}, Total: len(sorted)}
+	start := opts.Offset
+	if opts.Cursor != "" {
+		values, err := decodeCursor(opts.Sort, opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		start = sort.Search(len(sorted), func(i int) bool {
+			for j, field := range opts.Sort {
+				column := sortColumns[field.Field]
+				c := compareValues(column.numeric, column.value(sorted[i]), values[j])
+				if field.Descending {
+					c = -c
+				}
+				if c != 0 {
+					return c > 0
+				}
+			}
+			return false
+		})
+	}
+	if start > len(sorted) {
+		start = len(sorted)
+	}
+	end := start + opts.Limit
+	if end > len(sorted) {
+		end = len(sorted)
+	}
+	page.Users = append(page.Users, sorted[start:end]...)
+	if end < len(sorted) && len(page.Users) > 0 {
+		page.NextCursor = encodeCursor(opts.Sort, page.Users[len(page.Users)-1])
+	}
+	return page, nil
+}
+
+// orderByClause renders the sort fields as a SQL order by clause. Field
+// names are whitelisted by ParseSort so they are safe to interpolate.
+func orderByClause(fields []SortField) string {
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		parts[i] = field.Field
+		if field.Descending {
+			parts[i] += " desc"
+		}
+	}
+	return " order by " + strings.Join(parts, ", ")
+}
+
+// keysetClause renders a SQL predicate selecting the rows that sort after the
+// supplied cursor values, e.g. for "name,id":
+// (name > ?) or (name = ? and id > ?)
+func keysetClause(fields []SortField, values []string) (string, []interface{}) {
+	var args []interface{}
+	arg := func(i int) interface{} {
+		if sortColumns[fields[i].Field].numeric {
+			n, _ := strconv.ParseInt(values[i], 10, 64)
+			return n
+		}
+		return values[i]
+	}
+
+	disjuncts := make([]string, len(fields))
+	for i, field := range fields {
+		var conjuncts []string
+		for j := 0; j < i; j++ {
+			conjuncts = append(conjuncts, fields[j].Field+" = ?")
+			args = append(args, arg(j))
+		}
+		op := " > ?"
+		if field.Descending {
+			op = " < ?"
+		}
+		conjuncts = append(conjuncts, field.Field+op)
+		args = append(args, arg(i))
+		disjuncts[i] = "(" + strings.Join(conjuncts, " and ") + ")"
+	}
+	return "(" + strings.Join(disjuncts, " or ") + ")", args
+}
+
+// GetIncludingDeleted get a user by string identifier whether or not it has
+// been soft deleted
+func GetIncludingDeleted(ctx context.Context, r UserRepository, id string) (*models.User, error) {
+	if !validID(id) {
+		return nil, notFound()
+	}
+	n, _ := strconv.ParseInt(id, 10, 64)
+	page, err := r.List(ctx, ListOptions{
+		Limit:          1,
+		Filter:         Filter{Conditions: []Condition{{Field: "id", Op: OpEq, Value: n}}},
+		IncludeDeleted: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Users) == 0 {
+		return nil, notFound()
+	}
+	return &page.Users[0], nil
+}
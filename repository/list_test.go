@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+var listUsers = []models.User{
+	{ID: "1", Name: "James Bond", Age: 43, Gender: "male"},
+	{ID: "2", Name: "Vesper Lynd", Age: 32, Gender: "female"},
+	{ID: "3", Name: "Felix Leiter", Age: 43, Gender: "male"},
+	{ID: "10", Name: "Eve Moneypenny", Age: 30, Gender: "female"},
+}
+
+func userIDs(users []models.User) []string {
+	ids := []string{}
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
+func TestParseSort(t *testing.T) {
+	fields, err := ParseSort("name,-age")
+	if err != nil {
+		t.Fatalf("unable to parse sort due to: %v", err)
+	}
+
+	assert.Equal(t, []SortField{{Field: "name"}, {Field: "age", Descending: true}}, fields)
+}
+
+func TestParseSortEmpty(t *testing.T) {
+	fields, err := ParseSort("")
+
+	assert.Nil(t, err)
+	assert.Empty(t, fields)
+}
+
+func TestParseSortUnknownField(t *testing.T) {
+	fields, err := ParseSort("name,password")
+
+	assert.Nil(t, fields)
+	assert.NotNil(t, err)
+}
+
+func TestParseSortDuplicateField(t *testing.T) {
+	fields, err := ParseSort("age,-age")
+
+	assert.Nil(t, fields)
+	assert.NotNil(t, err)
+}
+
+func TestPageUsersDefaultOrder(t *testing.T) {
+	page, err := PageUsers(listUsers, ListOptions{})
+	if err != nil {
+		t.Fatalf("unable to page users due to: %v", err)
+	}
+
+	assert.Equal(t, []string{"1", "2", "3", "10"}, userIDs(page.Users))
+	assert.Equal(t, 4, page.Total)
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestPageUsersSorted(t *testing.T) {
+	page, err := PageUsers(listUsers, ListOptions{Sort: []SortField{{Field: "age", Descending: true}, {Field: "name"}}})
+	if err != nil {
+		t.Fatalf("unable to page users due to: %v", err)
+	}
+
+	assert.Equal(t, []string{"3", "1", "2", "10"}, userIDs(page.Users))
+}
+
+func TestPageUsersOffset(t *testing.T) {
+	page, err := PageUsers(listUsers, ListOptions{Limit: 2, Offset: 1})
+	if err != nil {
+		t.Fatalf("unable to page users due to: %v", err)
+	}
+
+	assert.Equal(t, []string{"2", "3"}, userIDs(page.Users))
+	assert.Equal(t, 4, page.Total)
+}
+
+func TestPageUsersCursor(t *testing.T) {
+	opts := ListOptions{Limit: 2, Sort: []SortField{{Field: "age"}}}
+	var ids []string
+	for {
+		page, err := PageUsers(listUsers, opts)
+		if err != nil {
+			t.Fatalf("unable to page users due to: %v", err)
+		}
+		ids = append(ids, userIDs(page.Users)...)
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+
+	assert.Equal(t, []string{"10", "2", "1", "3"}, ids)
+}
+
+func TestPageUsersCursorSortMismatch(t *testing.T) {
+	page, err := PageUsers(listUsers, ListOptions{Limit: 1})
+	if err != nil {
+		t.Fatalf("unable to page users due to: %v", err)
+	}
+
+	page, err = PageUsers(listUsers, ListOptions{Cursor: page.NextCursor, Sort: []SortField{{Field: "name"}}})
+
+	assert.Nil(t, page)
+	assert.Equal(t, ErrInvalidCursor, err)
+}
+
+func TestPageUsersInvalidCursor(t *testing.T) {
+	page, err := PageUsers(listUsers, ListOptions{Cursor: "not-a-cursor"})
+
+	assert.Nil(t, page)
+	assert.Equal(t, ErrInvalidCursor, err)
+}
+
+func TestKeysetClause(t *testing.T) {
+	fields := []SortField{{Field: "name"}, {Field: "age", Descending: true}, {Field: "id"}}
+	clause, args := keysetClause(fields, []string{"James Bond", "43", "1"})
+
+	assert.Equal(t, "((name > ?) or (name = ? and age < ?) or (name = ? and age = ? and id > ?))", clause)
+	assert.Equal(t, []interface{}{"James Bond", "James Bond", int64(43), "James Bond", int64(43), int64(1)}, args)
+}
+
+func TestGetIncludingDeleted(t *testing.T) {
+	ur := NewMemoryUserRepository().(*MemoryUserRepository)
+	deletedAt := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	ur.Seed(models.User{ID: "4294967296", Name: "Le Chiffre", Age: 45, Gender: "male", DeletedAt: &deletedAt})
+	ctx := context.Background()
+
+	user, err := GetIncludingDeleted(ctx, ur, "4294967296")
+	assert.Nil(t, err)
+	assert.Equal(t, "Le Chiffre", user.Name)
+	for _, id := range []string{"abc", "1.5", ""} {
+		_, err := GetIncludingDeleted(ctx, ur, id)
+		assert.True(t, errors.Is(err, models.ErrNotFound), id)
+	}
+}
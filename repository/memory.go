@@ -0,0 +1,489 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+)
+
+// MemoryUserRepository houses logic to store users in process memory. It is
+// safe for concurrent use and assigns monotonically increasing IDs which,
+// like AUTO_INCREMENT, are never reused after a delete.
+type MemoryUserRepository struct {
+	mu     sync.RWMutex
+	users  map[string]models.User
+	lastID int64
+	audit  []models.AuditEntry
+	undo   *undoLog
+}
+
+// NewMemoryUserRepository convenience function to create a UserRepository
+// backed by process memory
+func NewMemoryUserRepository() UserRepository {
+	return &MemoryUserRepository{users: map[string]models.User{}}
+}
+
+// Seed stores users as they are, without auditing them, and returns their
+// IDs. Users without an ID are assigned the next one and later IDs are
+// assigned past the highest seeded ID. Users without a Version start at
+// version 1.
+func (r *MemoryUserRepository) Seed(users ...models.User) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]string, len(users))
+	for i, user := range users {
+		if user.ID == "" {
+			r.lastID++
+			user.ID = strconv.FormatInt(r.lastID, 10)
+		} else if n, err := strconv.ParseInt(user.ID, 10, 64); err == nil && n > r.lastID {
+			r.lastID = n
+		}
+		if user.Version == 0 {
+			user.Version = 1
+		}
+		r.users[user.ID] = user
+		ids[i] = user.ID
+	}
+	return ids
+}
+
+// GetAll get all users from the repository ordered by ID
+func (r *MemoryUserRepository) GetAll(ctx context.Context) ([]models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.getAll(ctx)
+}
+
+// GetByID get a user by string identifier
+func (r *MemoryUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.getByID(ctx, id)
+}
+
+// List get a page of users from the repository
+func (r *MemoryUserRepository) List(ctx context.Context, opts ListOptions) (*UserPage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.list(ctx, opts)
+}
+
+// Each calls fn with every user matching opts. The users are selected under
+// the lock but passed to fn after it is released, so a slow fn does not
+// hold up writers.
+func (r *MemoryUserRepository) Each(ctx context.Context, opts ListOptions, fn func(models.User) error) error {
+	r.mu.RLock()
+	users, err := r.selectUsers(ctx, opts)
+	r.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return eachUser(ctx, users, fn)
+}
+
+// Create a User to the repository
+func (r *MemoryUserRepository) Create(ctx context.Context, user models.User) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.create(ctx, user)
+}
+
+// CreateMany creates every user or, failing that, none of them
+func (r *MemoryUserRepository) CreateMany(ctx context.Context, users []models.User) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.createMany(ctx, users)
+}
+
+// Update replaces all mutable fields of an existing User in the repository
+// and increments its version, provided a non-zero Version still matches
+func (r *MemoryUserRepository) Update(ctx context.Context, user models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.update(ctx, user)
+}
+
+// Delete soft deletes a User from the repository, provided a non-zero
+// Version still matches
+func (r *MemoryUserRepository) Delete(ctx context.Context, user models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.delete(ctx, user)
+}
+
+// Restore undoes the soft delete of a User, provided a non-zero Version
+// still matches
+func (r *MemoryUserRepository) Restore(ctx context.Context, user models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.restore(ctx, user)
+}
+
+// Purge permanently removes the users soft deleted before the given time
+func (r *MemoryUserRepository) Purge(ctx context.Context, before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.purge(ctx, before)
+}
+
+// History returns a page of the audit trail of a user, oldest entry first
+func (r *MemoryUserRepository) History(ctx context.Context, id string, opts HistoryOptions) (*AuditPage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.history(ctx, id, opts)
+}
+
+// WithTx runs fn while holding the repository's write lock, which makes
+// transactions serializable. Waiting for the lock gives up once ctx is
+// done. The users fn writes are noted in an undo log and restored, along
+// with the audit trail, when fn fails or panics.
+func (r *MemoryUserRepository) WithTx(ctx context.Context, fn func(UserRepository) error) error {
+	if err := r.lock(ctx); err != nil {
+		return models.UnavailableError{Message: "unable to run transaction", Err: err}
+	}
+	defer r.mu.Unlock()
+
+	r.undo = &undoLog{users: map[string]*models.User{}, lastID: r.lastID, audit: len(r.audit)}
+	defer func() { r.undo = nil }()
+	defer func() {
+		if p := recover(); p != nil {
+			r.rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(memoryTx{r}); err != nil {
+		r.rollback()
+		return err
+	}
+	return nil
+}
+
+// lock takes the write lock, unless ctx is done first
+func (r *MemoryUserRepository) lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	locked := make(chan struct{})
+	go func() {
+		r.mu.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		// The lock is still taken eventually, so hand it straight back.
+		go func() {
+			<-locked
+			r.mu.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
+// undoLog holds what a transaction needs to roll back: the users it wrote as
+// they were before their first write, nil for those it created, and the
+// last ID and length of the audit trail when it began
+type undoLog struct {
+	users  map[string]*models.User
+	lastID int64
+	audit  int
+}
+
+// rollback restores the state noted in the undo log
+func (r *MemoryUserRepository) rollback() {
+	for id, user := range r.undo.users {
+		if user == nil {
+			delete(r.users, id)
+		} else {
+			r.users[id] = *user
+		}
+	}
+	r.lastID = r.undo.lastID
+	r.audit = r.audit[:r.undo.audit]
+}
+
+// memoryTx is the view of a MemoryUserRepository passed to WithTx. Its
+// operations skip locking, the lock being held for the whole transaction.
+type memoryTx struct {
+	r *MemoryUserRepository
+}
+
+func (t memoryTx) GetAll(ctx context.Context) ([]models.User, error) {
+	return t.r.getAll(ctx)
+}
+
+func (t memoryTx) GetByID(ctx context.Context, id string) (*models.User, error) {
+	return t.r.getByID(ctx, id)
+}
+
+func (t memoryTx) List(ctx context.Context, opts ListOptions) (*UserPage, error) {
+	return t.r.list(ctx, opts)
+}
+
+func (t memoryTx) Each(ctx context.Context, opts ListOptions, fn func(models.User) error) error {
+	users, err := t.r.selectUsers(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return eachUser(ctx, users, fn)
+}
+
+func (t memoryTx) Create(ctx context.Context, user models.User) (string, error) {
+	return t.r.create(ctx, user)
+}
+
+func (t memoryTx) CreateMany(ctx context.Context, users []models.User) ([]string, error) {
+	return t.r.createMany(ctx, users)
+}
+
+func (t memoryTx) Update(ctx context.Context, user models.User) error {
+	return t.r.update(ctx, user)
+}
+
+func (t memoryTx) Delete(ctx context.Context, user models.User) error {
+	return t.r.delete(ctx, user)
+}
+
+func (t memoryTx) Restore(ctx context.Context, user models.User) error {
+	return t.r.restore(ctx, user)
+}
+
+func (t memoryTx) Purge(ctx context.Context, before time.Time) (int, error) {
+	return t.r.purge(ctx, before)
+}
+
+func (t memoryTx) History(ctx context.Context, id string, opts HistoryOptions) (*AuditPage, error) {
+	return t.r.history(ctx, id, opts)
+}
+
+// WithTx joins the enclosing transaction
+func (t memoryTx) WithTx(ctx context.Context, fn func(UserRepository) error) error {
+	return fn(t)
+}
+
+// The methods below implement the operations; callers must hold the lock.
+
+func (r *MemoryUserRepository) getAll(ctx context.Context) ([]models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, models.UnavailableError{Message: "unable to locate users", Err: err}
+	}
+	users := []models.User{}
+	for _, user := range r.snapshot() {
+		if user.DeletedAt == nil {
+			users = append(users, user)
+		}
+	}
+	sortUsers(users, []SortField{{Field: "id"}})
+	return users, nil
+}
+
+func (r *MemoryUserRepository) getByID(ctx context.Context, id string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, models.UnavailableError{Message: "unable to locate user", Err: err}
+	}
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt != nil {
+		return nil, notFound()
+	}
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) list(ctx context.Context, opts ListOptions) (*UserPage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, models.UnavailableError{Message: "unable to locate users", Err: err}
+	}
+	return PageUsers(r.snapshot(), opts)
+}
+
+func (r *MemoryUserRepository) selectUsers(ctx context.Context, opts ListOptions) ([]models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, models.UnavailableError{Message: "unable to locate users", Err: err}
+	}
+	return SelectUsers(r.snapshot(), opts), nil
+}
+
+// eachUser passes users to fn in order until fn fails or ctx is done
+func eachUser(ctx context.Context, users []models.User, fn func(models.User) error) error {
+	for _, user := range users {
+		if err := ctx.Err(); err != nil {
+			return models.UnavailableError{Message: "unable to locate users", Err: err}
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshot copies every stored user so they can be sorted and paged
+func (r *MemoryUserRepository) snapshot() []models.User {
+	users := make([]models.User, 0, len(r.users))
+	for _, user := range r.users {
+		users = append(users, user)
+	}
+	return users
+}
+
+func (r *MemoryUserRepository) create(ctx context.Context, user models.User) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", models.UnavailableError{Message: "unable to create user", Err: err}
+	}
+	return r.insert(ctx, user), nil
+}
+
+// insert stores a new user, returning its ID
+func (r *MemoryUserRepository) insert(ctx context.Context, user models.User) string {
+	r.lastID++
+	user.ID = strconv.FormatInt(r.lastID, 10)
+	user.Version = 1
+	user.DeletedAt = nil
+	r.put(user)
+	r.record(NewAuditEntry(ctx, models.AuditCreate, nil, &user))
+	return user.ID
+}
+
+func (r *MemoryUserRepository) createMany(ctx context.Context, users []models.User) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, models.UnavailableError{Message: "unable to create users", Err: err}
+	}
+	ids := make([]string, len(users))
+	for i, user := range users {
+		ids[i] = r.insert(ctx, user)
+	}
+	return ids, nil
+}
+
+func (r *MemoryUserRepository) update(ctx context.Context, user models.User) error {
+	if err := ctx.Err(); err != nil {
+		return models.UnavailableError{Message: "unable to update user", Err: err}
+	}
+	stored, ok := r.users[user.ID]
+	if !ok || stored.DeletedAt != nil {
+		return notFound()
+	}
+	if user.Version != 0 && user.Version != stored.Version {
+		return versionMismatch()
+	}
+	user.Version = stored.Version + 1
+	user.DeletedAt = nil
+	r.put(user)
+	r.record(NewAuditEntry(ctx, models.AuditUpdate, &stored, &user))
+	return nil
+}
+
+func (r *MemoryUserRepository) delete(ctx context.Context, user models.User) error {
+	if err := ctx.Err(); err != nil {
+		return models.UnavailableError{Message: "unable to delete user", Err: err}
+	}
+	stored, ok := r.users[user.ID]
+	if !ok || stored.DeletedAt != nil {
+		return notFound()
+	}
+	if user.Version != 0 && user.Version != stored.Version {
+		return versionMismatch()
+	}
+	before := stored
+	now := time.Now().UTC()
+	stored.DeletedAt = &now
+	stored.Version++
+	r.put(stored)
+	r.record(NewAuditEntry(ctx, models.AuditDelete, &before, &stored))
+	return nil
+}
+
+func (r *MemoryUserRepository) restore(ctx context.Context, user models.User) error {
+	if err := ctx.Err(); err != nil {
+		return models.UnavailableError{Message: "unable to restore user", Err: err}
+	}
+	stored, ok := r.users[user.ID]
+	if !ok {
+		return notFound()
+	}
+	if stored.DeletedAt == nil {
+		return notDeleted()
+	}
+	if user.Version != 0 && user.Version != stored.Version {
+		return versionMismatch()
+	}
+	before := stored
+	stored.DeletedAt = nil
+	stored.Version++
+	r.put(stored)
+	r.record(NewAuditEntry(ctx, models.AuditRestore, &before, &stored))
+	return nil
+}
+
+func (r *MemoryUserRepository) purge(ctx context.Context, before time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, models.UnavailableError{Message: "unable to purge users", Err: err}
+	}
+	// Purge in ID order, as the database does, so the audit trail is
+	// recorded in a repeatable order.
+	users := r.snapshot()
+	sortUsers(users, []SortField{{Field: "id"}})
+	purged := 0
+	for i := range users {
+		if users[i].DeletedAt != nil && users[i].DeletedAt.Before(before) {
+			r.remove(users[i].ID)
+			r.record(NewAuditEntry(ctx, models.AuditPurge, &users[i], nil))
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (r *MemoryUserRepository) history(ctx context.Context, id string, opts HistoryOptions) (*AuditPage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, models.UnavailableError{Message: "unable to locate audit entries", Err: err}
+	}
+	entries := []models.AuditEntry{}
+	for _, entry := range r.audit {
+		if entry.UserID == id {
+			entries = append(entries, entry)
+		}
+	}
+	return PageAudit(entries, opts), nil
+}
+
+// put stores user, noting the user it replaces in the undo log of a running
+// transaction
+func (r *MemoryUserRepository) put(user models.User) {
+	r.touch(user.ID)
+	r.users[user.ID] = user
+}
+
+// remove deletes the user with the given ID, noting it in the undo log of a
+// running transaction
+func (r *MemoryUserRepository) remove(id string) {
+	r.touch(id)
+	delete(r.users, id)
+}
+
+// touch notes the user with the given ID as it is before the first write a
+// running transaction makes to it
+func (r *MemoryUserRepository) touch(id string) {
+	if r.undo == nil {
+		return
+	}
+	if _, ok := r.undo.users[id]; ok {
+		return
+	}
+	if user, ok := r.users[id]; ok {
+		r.undo.users[id] = &user
+	} else {
+		r.undo.users[id] = nil
+	}
+}
+
+// record appends an entry to the audit trail, numbering it as the database
+// would
+func (r *MemoryUserRepository) record(entry models.AuditEntry) {
+	entry.ID = strconv.Itoa(len(r.audit) + 1)
+	r.audit = append(r.audit, entry)
+}
@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryCreateAndGet(t *testing.T) {
+	ur := NewMemoryUserRepository()
+	ctx := context.Background()
+
+	id, err := ur.Create(ctx, models.User{Name: "James Bond", Age: 43, Gender: "male"})
+	if err != nil {
+		t.Fatalf("unable to execute Create in TestMemoryCreateAndGet due to: %v", err)
+	}
+	user, err := ur.GetByID(ctx, id)
+	if err != nil {
+		t.Fatalf("unable to execute GetByID in TestMemoryCreateAndGet due to: %v", err)
+	}
+
+	assert.Equal(t, "1", id)
+	assert.Equal(t, models.User{ID: "1", Name: "James Bond", Age: 43, Gender: "male", Version: 1}, *user)
+}
+
+func TestMemoryIDsAreNotReused(t *testing.T) {
+	ur := NewMemoryUserRepository()
+	ctx := context.Background()
+
+	first, _ := ur.Create(ctx, models.User{Name: "James Bond", Age: 43, Gender: "male"})
+	second, _ := ur.Create(ctx, models.User{Name: "Vesper Lynd", Age: 32, Gender: "female"})
+	ur.Delete(ctx, models.User{ID: second})
+	third, _ := ur.Create(ctx, models.User{Name: "Felix Leiter", Age: 40, Gender: "male"})
+
+	assert.Equal(t, []string{"1", "2", "3"}, []string{first, second, third})
+}
+
+func TestMemoryGetAllOrderedByID(t *testing.T) {
+	ur := NewMemoryUserRepository()
+	ctx := context.Background()
+	for i := 0; i < 12; i++ {
+		ur.Create(ctx, models.User{Name: "Agent " + strconv.Itoa(i), Age: 30, Gender: "male"})
+	}
+
+	users, err := ur.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("unable to execute GetAll in TestMemoryGetAllOrderedByID due to: %v", err)
+	}
+
+	assert.Len(t, users, 12)
+	assert.Equal(t, "1", users[0].ID)
+	assert.Equal(t, "2", users[1].ID)
+	assert.Equal(t, "12", users[11].ID)
+}
+
+func TestMemoryUpdate(t *testing.T) {
+	ur := NewMemoryUserRepository()
+	ctx := context.Background()
+	id, _ := ur.Create(ctx, models.User{Name: "James Bond", Age: 43, Gender: "male"})
+
+	err := ur.Update(ctx, models.User{ID: id, Name: "James Bond", Age: 44, Gender: "male"})
+	user, _ := ur.GetByID(ctx, id)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 44, user.Age)
+}
+
+func TestMemoryNotFound(t *testing.T) {
+	ur := NewMemoryUserRepository()
+	ctx := context.Background()
+
+	user, err := ur.GetByID(ctx, "99")
+	assert.Nil(t, user)
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+
+	err = ur.Update(ctx, models.User{ID: "99", Name: "James Bond", Age: 43, Gender: "male"})
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+
+	err = ur.Delete(ctx, models.User{ID: "99"})
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+}
+
+func TestMemoryList(t *testing.T) {
+	ur := NewMemoryUserRepository()
+	ctx := context.Background()
+	for _, user := range listUsers {
+		ur.Create(ctx, user)
+	}
+
+	page, err := ur.List(ctx, ListOptions{Limit: 2, Sort: []SortField{{Field: "name"}}})
+	if err != nil {
+		t.Fatalf("unable to execute List in TestMemoryList due to: %v", err)
+	}
+
+	assert.Equal(t, 4, page.Total)
+	assert.Equal(t, []string{"Eve Moneypenny", "Felix Leiter"}, []string{page.Users[0].Name, page.Users[1].Name})
+	assert.NotEmpty(t, page.NextCursor)
+}
+
+func TestMemoryCanceledContext(t *testing.T) {
+	ur := NewMemoryUserRepository()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ur.Create(ctx, models.User{Name: "James Bond", Age: 43, Gender: "male"})
+
+	assert.True(t, errors.Is(err, models.ErrUnavailable))
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestMemoryConcurrentCreates(t *testing.T) {
+	ur := NewMemoryUserRepository()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ur.Create(ctx, models.User{Name: "James Bond", Age: 43, Gender: "male"})
+		}()
+	}
+	wg.Wait()
+
+	users, _ := ur.GetAll(ctx)
+	assert.Len(t, users, 50)
+}
+
+func TestMemoryWithTxStopsWaitingWhenCanceled(t *testing.T) {
+	ur := NewMemoryUserRepository()
+	held := make(chan struct{})
+	release := make(chan struct{})
+	go ur.WithTx(context.Background(), func(UserRepository) error {
+		close(held)
+		<-release
+		return nil
+	})
+	<-held
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := ur.WithTx(ctx, func(UserRepository) error {
+		t.Error("the transaction should not run")
+		return nil
+	})
+	close(release)
+
+	assert.True(t, errors.Is(err, models.ErrUnavailable))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	_, err = ur.Create(context.Background(), models.User{Name: "James Bond", Age: 43, Gender: "male"})
+	assert.Nil(t, err, "the lock is released once the first transaction ends")
+}
+
+func TestMemoryWithTxRollsBackWhenFnPanics(t *testing.T) {
+	ur := NewMemoryUserRepository()
+	ctx := context.Background()
+
+	assert.PanicsWithValue(t, "boom", func() {
+		ur.WithTx(ctx, func(tx UserRepository) error {
+			tx.Create(ctx, models.User{Name: "James Bond", Age: 43, Gender: "male"})
+			panic("boom")
+		})
+	})
+	users, _ := ur.GetAll(ctx)
+	id, err := ur.Create(ctx, models.User{Name: "Vesper Lynd", Age: 32, Gender: "female"})
+
+	assert.Empty(t, users)
+	assert.Nil(t, err, "the lock is released once the transaction panics")
+	assert.Equal(t, "1", id)
+}
@@ -1,87 +1,384 @@
 package repository
 
 import (
+	"context"
 	"errors"
-	"strconv"
+	"sync"
+	"time"
 
 	"github.com/ChrisTheShark/golang-mysql-api/models"
 	"github.com/ChrisTheShark/golang-mysql-api/repository"
 )
 
-// MockUserRepository houses logic to retrieve users from a mock repository
-type MockUserRepository struct{}
+// Call records a single invocation of a MockUserRepository operation
+type Call struct {
+	Method string
+	Args   []interface{}
+}
 
-// NewMockUserRepository convenience function to create a MockUserRepository
-func NewMockUserRepository() repository.UserRepository {
-	return &MockUserRepository{}
+// MockUserRepository is a MemoryUserRepository that records every call made
+// to it for later assertions and can be told to fail chosen operations. Each
+// instance has its own state and is safe for concurrent use.
+type MockUserRepository struct {
+	*repository.MemoryUserRepository
+	log *callLog
+	// tx is set on the view handed to WithTx and receives its calls
+	tx repository.UserRepository
+}
+
+// callLog holds the calls and injected failures shared by a
+// MockUserRepository and the views it hands to transactions
+type callLog struct {
+	mu       sync.Mutex
+	calls    []Call
+	failures map[string]error
 }
 
-var users = map[string]models.User{
-	"1": models.User{
+// NewMockUserRepository convenience function to create a MockUserRepository
+// seeded with a single user, James Bond, with ID "1"
+func NewMockUserRepository() *MockUserRepository {
+	r := &MockUserRepository{MemoryUserRepository: newMemory(), log: &callLog{}}
+	r.Seed(models.User{
 		Name:   "James Bond",
 		Gender: "male",
 		Age:    44,
 		ID:     "1",
-	},
+	})
+	return r
 }
 
-// GetAll get all users from the repository
-func (r MockUserRepository) GetAll() ([]models.User, error) {
-	userList := []models.User{}
-	for _, user := range users {
-		userList = append(userList, user)
+func newMemory() *repository.MemoryUserRepository {
+	return repository.NewMemoryUserRepository().(*repository.MemoryUserRepository)
+}
+
+// Seed stores users without recording calls, returning their IDs, as
+// MemoryUserRepository.Seed does
+func (r *MockUserRepository) Seed(users ...models.User) []string {
+	return r.memory().Seed(users...)
+}
+
+// Reset removes every user, audit entry, recorded call and injected
+// failure, restarting IDs from one
+func (r *MockUserRepository) Reset() {
+	r.log.mu.Lock()
+	defer r.log.mu.Unlock()
+
+	r.MemoryUserRepository = newMemory()
+	r.log.calls = nil
+	r.log.failures = nil
+}
+
+// Fail makes every later call to the named method return err without
+// reaching the repository, until Fail is called again with a nil err
+func (r *MockUserRepository) Fail(method string, err error) {
+	r.log.mu.Lock()
+	defer r.log.mu.Unlock()
+
+	if r.log.failures == nil {
+		r.log.failures = map[string]error{}
 	}
-	return userList, nil
+	if err == nil {
+		delete(r.log.failures, method)
+		return
+	}
+	r.log.failures[method] = err
 }
 
-// GetByID get a user by string identifier
-func (r MockUserRepository) GetByID(id string) (*models.User, error) {
-	user, ok := users[id]
-	if !ok {
-		return nil, models.UserNotFoundError{
-			Message: "not found",
+// Calls returns every call made to the repository in order
+func (r *MockUserRepository) Calls() []Call {
+	r.log.mu.Lock()
+	defer r.log.mu.Unlock()
+
+	return append([]Call(nil), r.log.calls...)
+}
+
+// CallsTo returns the calls made to the named method in order
+func (r *MockUserRepository) CallsTo(method string) []Call {
+	var calls []Call
+	for _, call := range r.Calls() {
+		if call.Method == method {
+			calls = append(calls, call)
 		}
 	}
-	return &user, nil
+	return calls
+}
+
+// record notes a call, returning the failure injected for the method
+func (r *MockUserRepository) record(method string, args ...interface{}) error {
+	r.log.mu.Lock()
+	defer r.log.mu.Unlock()
+
+	r.log.calls = append(r.log.calls, Call{Method: method, Args: args})
+	return r.log.failures[method]
+}
+
+func (r *MockUserRepository) memory() *repository.MemoryUserRepository {
+	r.log.mu.Lock()
+	defer r.log.mu.Unlock()
+	return r.MemoryUserRepository
+}
+
+// next returns the repository a call is passed on to
+func (r *MockUserRepository) next() repository.UserRepository {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.memory()
+}
+
+// GetAll get all users from the repository ordered by ID
+func (r *MockUserRepository) GetAll(ctx context.Context) ([]models.User, error) {
+	if err := r.record("GetAll"); err != nil {
+		return nil, err
+	}
+	return r.next().GetAll(ctx)
+}
+
+// GetByID get a user by string identifier
+func (r *MockUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
+	if err := r.record("GetByID", id); err != nil {
+		return nil, err
+	}
+	return r.next().GetByID(ctx, id)
+}
+
+// List get a page of users from the repository
+func (r *MockUserRepository) List(ctx context.Context, opts repository.ListOptions) (*repository.UserPage, error) {
+	if err := r.record("List", opts); err != nil {
+		return nil, err
+	}
+	return r.next().List(ctx, opts)
+}
+
+// Each calls fn with every user matching opts
+func (r *MockUserRepository) Each(ctx context.Context, opts repository.ListOptions, fn func(models.User) error) error {
+	if err := r.record("Each", opts); err != nil {
+		return err
+	}
+	return r.next().Each(ctx, opts, fn)
 }
 
 // Create a User to the repository
-func (r MockUserRepository) Create(user models.User) (string, error) {
-	user.ID = strconv.Itoa(len(users) + 1)
-	users[user.ID] = user
-	return user.ID, nil
+func (r *MockUserRepository) Create(ctx context.Context, user models.User) (string, error) {
+	if err := r.record("Create", user); err != nil {
+		return "", err
+	}
+	return r.next().Create(ctx, user)
 }
 
-// Delete a User from the repository
-func (r MockUserRepository) Delete(user models.User) error {
-	delete(users, user.ID)
-	return nil
+// CreateMany creates every user or, failing that, none of them
+func (r *MockUserRepository) CreateMany(ctx context.Context, users []models.User) ([]string, error) {
+	if err := r.record("CreateMany", users); err != nil {
+		return nil, err
+	}
+	return r.next().CreateMany(ctx, users)
+}
+
+// Update replaces an existing User in the repository and increments its
+// version
+func (r *MockUserRepository) Update(ctx context.Context, user models.User) error {
+	if err := r.record("Update", user); err != nil {
+		return err
+	}
+	return r.next().Update(ctx, user)
+}
+
+// Delete soft deletes a User from the repository
+func (r *MockUserRepository) Delete(ctx context.Context, user models.User) error {
+	if err := r.record("Delete", user); err != nil {
+		return err
+	}
+	return r.next().Delete(ctx, user)
+}
+
+// Restore undoes the soft delete of a User
+func (r *MockUserRepository) Restore(ctx context.Context, user models.User) error {
+	if err := r.record("Restore", user); err != nil {
+		return err
+	}
+	return r.next().Restore(ctx, user)
+}
+
+// Purge permanently removes the users soft deleted before the given time
+func (r *MockUserRepository) Purge(ctx context.Context, before time.Time) (int, error) {
+	if err := r.record("Purge", before); err != nil {
+		return 0, err
+	}
+	return r.next().Purge(ctx, before)
+}
+
+// History returns a page of the audit trail of a user, oldest entry first
+func (r *MockUserRepository) History(ctx context.Context, id string, opts repository.HistoryOptions) (*repository.AuditPage, error) {
+	if err := r.record("History", id, opts); err != nil {
+		return nil, err
+	}
+	return r.next().History(ctx, id, opts)
+}
+
+// WithTx runs fn in a transaction of the MemoryUserRepository, recording the
+// calls fn makes
+func (r *MockUserRepository) WithTx(ctx context.Context, fn func(repository.UserRepository) error) error {
+	if err := r.record("WithTx"); err != nil {
+		return err
+	}
+	return r.next().WithTx(ctx, func(tx repository.UserRepository) error {
+		return fn(&MockUserRepository{MemoryUserRepository: r.memory(), log: r.log, tx: tx})
+	})
 }
 
 // MockErroringUserRepository returns errors for all operations.
-type MockErroringUserRepository struct{}
+type MockErroringUserRepository struct {
+	err error
+}
 
 // NewMockErroringUserRepository convenience function to create a MockErroringUserRepository
 func NewMockErroringUserRepository() repository.UserRepository {
-	return &MockErroringUserRepository{}
+	return NewMockErroringUserRepositoryWithError(errors.New("blamo"))
+}
+
+// NewMockErroringUserRepositoryWithError creates a MockErroringUserRepository
+// failing every operation with the supplied error
+func NewMockErroringUserRepositoryWithError(err error) repository.UserRepository {
+	return &MockErroringUserRepository{err}
 }
 
 // GetAll get all users from the repository
-func (r MockErroringUserRepository) GetAll() ([]models.User, error) {
-	return nil, errors.New("blamo")
+func (r MockErroringUserRepository) GetAll(ctx context.Context) ([]models.User, error) {
+	return nil, r.err
 }
 
 // GetByID get a user by string identifier
-func (r MockErroringUserRepository) GetByID(id string) (*models.User, error) {
-	return nil, errors.New("blamo")
+func (r MockErroringUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
+	return nil, r.err
+}
+
+// List get a page of users from the repository
+func (r MockErroringUserRepository) List(ctx context.Context, opts repository.ListOptions) (*repository.UserPage, error) {
+	return nil, r.err
+}
+
+// Each calls fn with every user matching opts
+func (r MockErroringUserRepository) Each(ctx context.Context, opts repository.ListOptions, fn func(models.User) error) error {
+	return r.err
 }
 
 // Create a User to the repository
-func (r MockErroringUserRepository) Create(user models.User) (string, error) {
-	return "", errors.New("blamo")
+func (r MockErroringUserRepository) Create(ctx context.Context, user models.User) (string, error) {
+	return "", r.err
+}
+
+// CreateMany creates Users in the repository
+func (r MockErroringUserRepository) CreateMany(ctx context.Context, users []models.User) ([]string, error) {
+	return nil, r.err
+}
+
+// Update replaces an existing User in the repository
+func (r MockErroringUserRepository) Update(ctx context.Context, user models.User) error {
+	return r.err
 }
 
 // Delete a User from the repository
-func (r MockErroringUserRepository) Delete(user models.User) error {
-	return errors.New("blamo")
+func (r MockErroringUserRepository) Delete(ctx context.Context, user models.User) error {
+	return r.err
+}
+
+// Restore undoes the soft delete of a User
+func (r MockErroringUserRepository) Restore(ctx context.Context, user models.User) error {
+	return r.err
+}
+
+// Purge permanently removes the users soft deleted before the given time
+func (r MockErroringUserRepository) Purge(ctx context.Context, before time.Time) (int, error) {
+	return 0, r.err
+}
+
+// History get a page of the audit trail of a user
+func (r MockErroringUserRepository) History(ctx context.Context, id string, opts repository.HistoryOptions) (*repository.AuditPage, error) {
+	return nil, r.err
+}
+
+// WithTx fails to begin a transaction
+func (r MockErroringUserRepository) WithTx(ctx context.Context, fn func(repository.UserRepository) error) error {
+	return r.err
+}
+
+// MockTimeoutUserRepository blocks every operation until the supplied
+// context is done, simulating an unresponsive database.
+type MockTimeoutUserRepository struct{}
+
+// NewMockTimeoutUserRepository convenience function to create a MockTimeoutUserRepository
+func NewMockTimeoutUserRepository() repository.UserRepository {
+	return &MockTimeoutUserRepository{}
+}
+
+// GetAll get all users from the repository
+func (r MockTimeoutUserRepository) GetAll(ctx context.Context) ([]models.User, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// GetByID get a user by string identifier
+func (r MockTimeoutUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// List get a page of users from the repository
+func (r MockTimeoutUserRepository) List(ctx context.Context, opts repository.ListOptions) (*repository.UserPage, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// Each calls fn with every user matching opts
+func (r MockTimeoutUserRepository) Each(ctx context.Context, opts repository.ListOptions, fn func(models.User) error) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// Create a User to the repository
+func (r MockTimeoutUserRepository) Create(ctx context.Context, user models.User) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+// CreateMany creates Users in the repository
+func (r MockTimeoutUserRepository) CreateMany(ctx context.Context, users []models.User) ([]string, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// Update replaces an existing User in the repository
+func (r MockTimeoutUserRepository) Update(ctx context.Context, user models.User) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// Delete a User from the repository
+func (r MockTimeoutUserRepository) Delete(ctx context.Context, user models.User) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// Restore undoes the soft delete of a User
+func (r MockTimeoutUserRepository) Restore(ctx context.Context, user models.User) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// Purge permanently removes the users soft deleted before the given time
+func (r MockTimeoutUserRepository) Purge(ctx context.Context, before time.Time) (int, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+// History get a page of the audit trail of a user
+func (r MockTimeoutUserRepository) History(ctx context.Context, id string, opts repository.HistoryOptions) (*repository.AuditPage, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// WithTx blocks beginning a transaction
+func (r MockTimeoutUserRepository) WithTx(ctx context.Context, fn func(repository.UserRepository) error) error {
+	<-ctx.Done()
+	return ctx.Err()
 }
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+	"github.com/ChrisTheShark/golang-mysql-api/repository/repositorytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMockUserRepositoryConformance(t *testing.T) {
+	repositorytest.RunConformance(t, func(t *testing.T) repository.UserRepository {
+		return NewMockUserRepository()
+	})
+}
+
+func TestMockUserRepositorySeed(t *testing.T) {
+	ur := NewMockUserRepository()
+
+	ids := ur.Seed(models.User{ID: "7", Name: "Q", Age: 30, Gender: "male"},
+		models.User{Name: "M", Age: 60, Gender: "female"})
+	id, err := ur.Create(context.Background(), models.User{Name: "Felix Leiter", Age: 40, Gender: "male"})
+	if err != nil {
+		t.Fatalf("unable to execute Create in TestMockUserRepositorySeed due to: %v", err)
+	}
+
+	assert.Equal(t, []string{"7", "8"}, ids)
+	assert.Equal(t, "9", id)
+}
+
+func TestMockUserRepositoryReset(t *testing.T) {
+	ur := NewMockUserRepository()
+	ur.GetAll(context.Background())
+
+	ur.Reset()
+	users, _ := ur.GetAll(context.Background())
+	id, _ := ur.Create(context.Background(), models.User{Name: "Q", Age: 30, Gender: "male"})
+
+	assert.Empty(t, users)
+	assert.Equal(t, "1", id)
+	assert.Len(t, ur.Calls(), 2)
+}
+
+func TestMockUserRepositoryCalls(t *testing.T) {
+	ur := NewMockUserRepository()
+	ctx := context.Background()
+
+	user, _ := ur.GetByID(ctx, "1")
+	ur.Delete(ctx, *user)
+	err := ur.Delete(ctx, *user)
+
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+	assert.Equal(t, []Call{
+		{Method: "GetByID", Args: []interface{}{"1"}},
+		{Method: "Delete", Args: []interface{}{*user}},
+		{Method: "Delete", Args: []interface{}{*user}},
+	}, ur.Calls())
+	assert.Len(t, ur.CallsTo("Delete"), 2)
+	assert.Empty(t, ur.CallsTo("Create"))
+}
+
+func TestMockUserRepositoryFail(t *testing.T) {
+	ur := NewMockUserRepository()
+	ctx := context.Background()
+	unavailable := models.UnavailableError{Message: "the database is unavailable"}
+
+	ur.Fail("GetByID", unavailable)
+	_, err := ur.GetByID(ctx, "1")
+	assert.Equal(t, unavailable, err)
+	ur.Fail("GetByID", nil)
+	user, err := ur.GetByID(ctx, "1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "James Bond", user.Name)
+	assert.Len(t, ur.CallsTo("GetByID"), 2)
+}
+
+func TestMockUserRepositoryRecordsCallsWithinTx(t *testing.T) {
+	ur := NewMockUserRepository()
+	ctx := context.Background()
+	ur.Fail("Update", models.ConflictError{Message: "conflict"})
+
+	err := ur.WithTx(ctx, func(tx repository.UserRepository) error {
+		if _, err := tx.Create(ctx, models.User{Name: "Q", Age: 30, Gender: "male"}); err != nil {
+			return err
+		}
+		return tx.Update(ctx, models.User{ID: "1", Name: "James Bond", Age: 45, Gender: "male"})
+	})
+	users, _ := ur.GetAll(ctx)
+
+	assert.True(t, errors.Is(err, models.ErrConflict))
+	assert.Len(t, users, 1, "the transaction is rolled back")
+	assert.Equal(t, []string{"WithTx", "Create", "Update", "GetAll"}, methods(ur.Calls()))
+}
+
+func methods(calls []Call) []string {
+	names := make([]string, len(calls))
+	for i, call := range calls {
+		names[i] = call.Method
+	}
+	return names
+}
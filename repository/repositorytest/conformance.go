@@ -0,0 +1,880 @@
+// Package repositorytest provides a conformance suite pinning the behavioral
+// contract every repository.UserRepository implementation, fakes included,
+// must honor.
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+// Factory returns the UserRepository under test. It is called once per
+// conformance test. The repository may already hold users, such as those
+// left behind by an earlier run against a shared database; the suite only
+// relies on users it creates itself.
+type Factory func(t *testing.T) repository.UserRepository
+
+// RunConformance runs the conformance suite against the repositories
+// returned by newRepository, each test as a subtest of t
+func RunConformance(t *testing.T, newRepository Factory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, repository.UserRepository)
+	}{
+		{"CreateAssignsID", testCreateAssignsID},
+		{"IDsAreNotReused", testIDsAreNotReused},
+		{"CreateMany", testCreateMany},
+		{"CreateManyRollsBack", testCreateManyRollsBack},
+		{"GetByIDNotFound", testGetByIDNotFound},
+		{"Update", testUpdate},
+		{"UpdateUnchanged", testUpdateUnchanged},
+		{"UpdateNotFound", testUpdateNotFound},
+		{"UpdateIncrementsVersion", testUpdateIncrementsVersion},
+		{"UpdateStaleVersion", testUpdateStaleVersion},
+		{"Delete", testDelete},
+		{"DeleteNotFound", testDeleteNotFound},
+		{"DeleteStaleVersion", testDeleteStaleVersion},
+		{"DeleteIsSoft", testDeleteIsSoft},
+		{"Restore", testRestore},
+		{"RestoreFailures", testRestoreFailures},
+		{"Purge", testPurge},
+		{"History", testHistory},
+		{"HistoryPages", testHistoryPages},
+		{"HistoryOutlivesPurge", testHistoryOutlivesPurge},
+		{"HistoryRollsBack", testHistoryRollsBack},
+		{"GetAllOrderedByID", testGetAllOrderedByID},
+		{"ListPagesByCursor", testListPagesByCursor},
+		{"PrefixFilterIgnoresCase", testPrefixFilterIgnoresCase},
+		{"Each", testEach},
+		{"EachStopsOnError", testEachStopsOnError},
+		{"TxCommits", testTxCommits},
+		{"TxRollsBack", testTxRollsBack},
+		{"NestedTxJoins", testNestedTxJoins},
+		{"CanceledContext", testCanceledContext},
+		{"ConcurrentCreates", testConcurrentCreates},
+		{"ConcurrentUpdates", testConcurrentUpdates},
+		{"ConcurrentVersionedUpdates", testConcurrentVersionedUpdates},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newRepository(t))
+		})
+	}
+}
+
+func newUser(name string) models.User {
+	return models.User{Name: name, Age: 43, Gender: "male"}
+}
+
+// create stores a user, failing the test when it cannot, and returns it as
+// stored: with its ID assigned and at version 1
+func create(t *testing.T, ur repository.UserRepository, user models.User) models.User {
+	t.Helper()
+	id, err := ur.Create(context.Background(), user)
+	if err != nil {
+		t.Fatalf("unable to create user due to: %v", err)
+	}
+	user.ID = id
+	user.Version = 1
+	return user
+}
+
+// get fetches a user, failing the test when it cannot
+func get(t *testing.T, ur repository.UserRepository, id string) models.User {
+	t.Helper()
+	user, err := ur.GetByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("unable to get user due to: %v", err)
+	}
+	return *user
+}
+
+func testCreateAssignsID(t *testing.T, ur repository.UserRepository) {
+	first := create(t, ur, newUser("James Bond"))
+	second := create(t, ur, newUser("Felix Leiter"))
+
+	assert.NotEmpty(t, first.ID)
+	assert.NotEqual(t, first.ID, second.ID)
+
+	user, err := ur.GetByID(context.Background(), first.ID)
+	if err != nil {
+		t.Fatalf("unable to get created user due to: %v", err)
+	}
+	assert.Equal(t, first, *user)
+}
+
+func testIDsAreNotReused(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	deleted := create(t, ur, newUser("James Bond"))
+	if err := ur.Delete(ctx, deleted); err != nil {
+		t.Fatalf("unable to delete user due to: %v", err)
+	}
+
+	created := create(t, ur, newUser("Felix Leiter"))
+
+	assert.NotEqual(t, deleted.ID, created.ID)
+	_, err := ur.GetByID(ctx, deleted.ID)
+	assert.True(t, errors.Is(err, models.ErrNotFound), "expected not found, got %v", err)
+}
+
+// testCreateMany pins that a batch spanning several multi-row inserts
+// assigns each user its own ID, in order, and audits each creation
+func testCreateMany(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	users := make([]models.User, 650)
+	for i := range users {
+		users[i] = newUser(fmt.Sprintf("Agent %03d", i))
+	}
+
+	ids, err := ur.CreateMany(ctx, users)
+	if err != nil {
+		t.Fatalf("unable to create users due to: %v", err)
+	}
+
+	if !assert.Len(t, ids, len(users)) {
+		return
+	}
+	for _, i := range []int{0, 299, 300, 649} {
+		assert.Equal(t, users[i].Name, get(t, ur, ids[i]).Name)
+	}
+	assert.Equal(t, []string{models.AuditCreate}, operations(history(t, ur, ids[649])))
+
+	ids, err = ur.CreateMany(ctx, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, ids)
+}
+
+func testCreateManyRollsBack(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	failure := errors.New("blamo")
+
+	var ids []string
+	err := ur.WithTx(ctx, func(tx repository.UserRepository) error {
+		var err error
+		if ids, err = tx.CreateMany(ctx, []models.User{newUser("James Bond"), newUser("Felix Leiter")}); err != nil {
+			return err
+		}
+		return failure
+	})
+
+	assert.Equal(t, failure, err)
+	for _, id := range ids {
+		_, err = ur.GetByID(ctx, id)
+		assert.True(t, errors.Is(err, models.ErrNotFound), "expected not found, got %v", err)
+	}
+}
+
+func testGetByIDNotFound(t *testing.T, ur repository.UserRepository) {
+	for _, id := range []string{"999999999", "abc", ""} {
+		user, err := ur.GetByID(context.Background(), id)
+
+		assert.Nil(t, user)
+		assert.True(t, errors.Is(err, models.ErrNotFound), "expected not found for id %q, got %v", id, err)
+	}
+}
+
+func testUpdate(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	user := create(t, ur, newUser("James Bond"))
+	user.Age = 44
+
+	if err := ur.Update(ctx, user); err != nil {
+		t.Fatalf("unable to update user due to: %v", err)
+	}
+
+	user.Version++
+	assert.Equal(t, user, get(t, ur, user.ID))
+}
+
+func testUpdateUnchanged(t *testing.T, ur repository.UserRepository) {
+	user := create(t, ur, newUser("James Bond"))
+
+	assert.Nil(t, ur.Update(context.Background(), user))
+}
+
+func testUpdateNotFound(t *testing.T, ur repository.UserRepository) {
+	user := newUser("James Bond")
+	user.ID = "999999999"
+
+	err := ur.Update(context.Background(), user)
+
+	assert.True(t, errors.Is(err, models.ErrNotFound), "expected not found, got %v", err)
+}
+
+// testUpdateIncrementsVersion pins that every update increments the
+// version, whether or not it is conditioned on one
+func testUpdateIncrementsVersion(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	user := create(t, ur, newUser("James Bond"))
+
+	if err := ur.Update(ctx, user); err != nil {
+		t.Fatalf("unable to update user due to: %v", err)
+	}
+	assert.Equal(t, 2, get(t, ur, user.ID).Version)
+
+	user.Version = 0
+	if err := ur.Update(ctx, user); err != nil {
+		t.Fatalf("unable to update user due to: %v", err)
+	}
+	assert.Equal(t, 3, get(t, ur, user.ID).Version)
+}
+
+func testUpdateStaleVersion(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	user := create(t, ur, newUser("James Bond"))
+	first, second := user, user
+	first.Age = 44
+	second.Age = 45
+	if err := ur.Update(ctx, first); err != nil {
+		t.Fatalf("unable to update user due to: %v", err)
+	}
+
+	err := ur.Update(ctx, second)
+
+	assert.True(t, errors.Is(err, models.ErrVersionMismatch), "expected version mismatch, got %v", err)
+	first.Version++
+	assert.Equal(t, first, get(t, ur, user.ID))
+}
+
+func testDelete(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	user := create(t, ur, newUser("James Bond"))
+
+	if err := ur.Delete(ctx, user); err != nil {
+		t.Fatalf("unable to delete user due to: %v", err)
+	}
+
+	_, err := ur.GetByID(ctx, user.ID)
+	assert.True(t, errors.Is(err, models.ErrNotFound), "expected not found, got %v", err)
+}
+
+// testDeleteNotFound pins that deleting a missing user, including one that
+// was already deleted, leaves the repository untouched and reports not found
+func testDeleteNotFound(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	user := create(t, ur, newUser("James Bond"))
+	kept := create(t, ur, newUser("Felix Leiter"))
+	if err := ur.Delete(ctx, user); err != nil {
+		t.Fatalf("unable to delete user due to: %v", err)
+	}
+
+	err := ur.Delete(ctx, user)
+	assert.True(t, errors.Is(err, models.ErrNotFound), "expected not found, got %v", err)
+
+	err = ur.Delete(ctx, models.User{ID: "999999999"})
+	assert.True(t, errors.Is(err, models.ErrNotFound), "expected not found, got %v", err)
+
+	_, err = ur.GetByID(ctx, kept.ID)
+	assert.Nil(t, err)
+}
+
+func testDeleteStaleVersion(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	user := create(t, ur, newUser("James Bond"))
+	if err := ur.Update(ctx, user); err != nil {
+		t.Fatalf("unable to update user due to: %v", err)
+	}
+
+	err := ur.Delete(ctx, user)
+	assert.True(t, errors.Is(err, models.ErrVersionMismatch), "expected version mismatch, got %v", err)
+
+	user.Version++
+	assert.Nil(t, ur.Delete(ctx, user))
+}
+
+// testDeleteIsSoft pins that deleted users are hidden from every read but a
+// listing that includes them, and can no longer be updated
+func testDeleteIsSoft(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	deleted := create(t, ur, newUser("James Bond"))
+	kept := create(t, ur, newUser("Felix Leiter"))
+	if err := ur.Delete(ctx, deleted); err != nil {
+		t.Fatalf("unable to delete user due to: %v", err)
+	}
+
+	users, err := ur.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("unable to get all users due to: %v", err)
+	}
+	assert.NotContains(t, userIDs(users), deleted.ID)
+	assert.Contains(t, userIDs(users), kept.ID)
+
+	opts := repository.ListOptions{Filter: idFilter(deleted, kept)}
+	page, err := ur.List(ctx, opts)
+	if err != nil {
+		t.Fatalf("unable to list users due to: %v", err)
+	}
+	assert.Equal(t, []string{kept.ID}, userIDs(page.Users))
+	assert.Equal(t, 1, page.Total)
+
+	opts.IncludeDeleted = true
+	page, err = ur.List(ctx, opts)
+	if err != nil {
+		t.Fatalf("unable to list users due to: %v", err)
+	}
+	assert.Equal(t, []string{deleted.ID, kept.ID}, userIDs(page.Users))
+	assert.Equal(t, 2, page.Total)
+	assert.NotNil(t, page.Users[0].DeletedAt)
+	assert.Nil(t, page.Users[1].DeletedAt)
+
+	user, err := repository.GetIncludingDeleted(ctx, ur, deleted.ID)
+	if err != nil {
+		t.Fatalf("unable to get deleted user due to: %v", err)
+	}
+	assert.NotNil(t, user.DeletedAt)
+	assert.Equal(t, deleted.Version+1, user.Version)
+
+	err = ur.Update(ctx, models.User{ID: deleted.ID, Name: "James Bond", Age: 44, Gender: "male"})
+	assert.True(t, errors.Is(err, models.ErrNotFound), "expected not found, got %v", err)
+}
+
+func testRestore(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	user := create(t, ur, newUser("James Bond"))
+	if err := ur.Delete(ctx, user); err != nil {
+		t.Fatalf("unable to delete user due to: %v", err)
+	}
+
+	if err := ur.Restore(ctx, models.User{ID: user.ID, Version: 2}); err != nil {
+		t.Fatalf("unable to restore user due to: %v", err)
+	}
+
+	user.Version = 3
+	assert.Equal(t, user, get(t, ur, user.ID))
+}
+
+func testRestoreFailures(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	user := create(t, ur, newUser("James Bond"))
+
+	err := ur.Restore(ctx, user)
+	assert.True(t, errors.Is(err, models.ErrConflict), "expected conflict, got %v", err)
+
+	if err := ur.Delete(ctx, user); err != nil {
+		t.Fatalf("unable to delete user due to: %v", err)
+	}
+	err = ur.Restore(ctx, user)
+	assert.True(t, errors.Is(err, models.ErrVersionMismatch), "expected version mismatch, got %v", err)
+
+	err = ur.Restore(ctx, models.User{ID: "999999999"})
+	assert.True(t, errors.Is(err, models.ErrNotFound), "expected not found, got %v", err)
+}
+
+// testPurge pins that purging removes exactly the users deleted before the
+// cutoff
+func testPurge(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	purged := create(t, ur, newUser("James Bond"))
+	kept := create(t, ur, newUser("Felix Leiter"))
+	if err := ur.Delete(ctx, purged); err != nil {
+		t.Fatalf("unable to delete user due to: %v", err)
+	}
+
+	if _, err := ur.Purge(ctx, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("unable to purge users due to: %v", err)
+	}
+	_, err := repository.GetIncludingDeleted(ctx, ur, purged.ID)
+	assert.Nil(t, err, "expected a recently deleted user to survive the purge")
+
+	n, err := ur.Purge(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unable to purge users due to: %v", err)
+	}
+	assert.True(t, n >= 1, "expected at least one purged user, got %d", n)
+	_, err = repository.GetIncludingDeleted(ctx, ur, purged.ID)
+	assert.True(t, errors.Is(err, models.ErrNotFound), "expected not found, got %v", err)
+	get(t, ur, kept.ID)
+}
+
+// history fetches the whole audit trail of a user, failing the test when it
+// cannot
+func history(t *testing.T, ur repository.UserRepository, id string) []models.AuditEntry {
+	t.Helper()
+	page, err := ur.History(context.Background(), id, repository.HistoryOptions{Limit: repository.MaxLimit})
+	if err != nil {
+		t.Fatalf("unable to get history due to: %v", err)
+	}
+	return page.Entries
+}
+
+// operations lists the operations recorded by entries, in order
+func operations(entries []models.AuditEntry) []string {
+	ops := make([]string, len(entries))
+	for i, entry := range entries {
+		ops[i] = entry.Operation
+	}
+	return ops
+}
+
+// testHistory pins that every write is recorded, oldest first, with the
+// actor and request of its context and snapshots of the user before and
+// after it
+func testHistory(t *testing.T, ur repository.UserRepository) {
+	ctx := repository.WithRequestID(repository.WithActor(context.Background(), "M"), "req-1")
+	start := time.Now().Add(-time.Minute)
+	id, err := ur.Create(ctx, newUser("James Bond"))
+	if err != nil {
+		t.Fatalf("unable to create user due to: %v", err)
+	}
+	updated := newUser("James Bond")
+	updated.ID = id
+	updated.Age = 44
+	if err := ur.Update(ctx, updated); err != nil {
+		t.Fatalf("unable to update user due to: %v", err)
+	}
+	if err := ur.Delete(ctx, updated); err != nil {
+		t.Fatalf("unable to delete user due to: %v", err)
+	}
+	if err := ur.Restore(ctx, updated); err != nil {
+		t.Fatalf("unable to restore user due to: %v", err)
+	}
+
+	entries := history(t, ur, id)
+
+	if !assert.Equal(t, []string{models.AuditCreate, models.AuditUpdate, models.AuditDelete, models.AuditRestore}, operations(entries)) {
+		return
+	}
+	for _, entry := range entries {
+		assert.NotEmpty(t, entry.ID)
+		assert.Equal(t, id, entry.UserID)
+		assert.Equal(t, "M", entry.Actor)
+		assert.Equal(t, "req-1", entry.RequestID)
+		assert.True(t, entry.At.After(start), "expected a recent timestamp, got %v", entry.At)
+	}
+	assert.Nil(t, entries[0].Before)
+	if assert.NotNil(t, entries[0].After) {
+		assert.Equal(t, 43, entries[0].After.Age)
+	}
+	if assert.NotNil(t, entries[1].Before) && assert.NotNil(t, entries[1].After) {
+		assert.Equal(t, 43, entries[1].Before.Age)
+		assert.Equal(t, 44, entries[1].After.Age)
+		assert.Equal(t, 1, entries[1].Before.Version)
+		assert.Equal(t, 2, entries[1].After.Version)
+	}
+	if assert.NotNil(t, entries[2].After) {
+		assert.NotNil(t, entries[2].After.DeletedAt)
+	}
+	if assert.NotNil(t, entries[3].After) {
+		assert.Nil(t, entries[3].After.DeletedAt)
+	}
+}
+
+func testHistoryPages(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	user := create(t, ur, newUser("James Bond"))
+	for age := 44; age < 48; age++ {
+		user.Age = age
+		if err := ur.Update(ctx, user); err != nil {
+			t.Fatalf("unable to update user due to: %v", err)
+		}
+		user.Version++
+	}
+
+	page, err := ur.History(ctx, user.ID, repository.HistoryOptions{Limit: 2, Offset: 2})
+	if err != nil {
+		t.Fatalf("unable to get history due to: %v", err)
+	}
+
+	assert.Equal(t, 5, page.Total)
+	if assert.Len(t, page.Entries, 2) {
+		assert.Equal(t, 45, page.Entries[0].After.Age)
+		assert.Equal(t, 46, page.Entries[1].After.Age)
+	}
+
+	page, err = ur.History(ctx, "999999999", repository.HistoryOptions{})
+	if err != nil {
+		t.Fatalf("unable to get history due to: %v", err)
+	}
+	assert.Equal(t, 0, page.Total)
+	assert.Empty(t, page.Entries)
+}
+
+func testHistoryOutlivesPurge(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	user := create(t, ur, newUser("James Bond"))
+	if err := ur.Delete(ctx, user); err != nil {
+		t.Fatalf("unable to delete user due to: %v", err)
+	}
+	if _, err := ur.Purge(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("unable to purge users due to: %v", err)
+	}
+
+	entries := history(t, ur, user.ID)
+
+	assert.Equal(t, []string{models.AuditCreate, models.AuditDelete, models.AuditPurge}, operations(entries))
+	if len(entries) == 3 {
+		assert.NotNil(t, entries[2].Before)
+		assert.Nil(t, entries[2].After)
+	}
+}
+
+func testHistoryRollsBack(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	user := create(t, ur, newUser("James Bond"))
+
+	err := ur.WithTx(ctx, func(tx repository.UserRepository) error {
+		if err := tx.Delete(ctx, user); err != nil {
+			return err
+		}
+		return errors.New("blamo")
+	})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{models.AuditCreate}, operations(history(t, ur, user.ID)))
+}
+
+func testGetAllOrderedByID(t *testing.T, ur repository.UserRepository) {
+	var created []string
+	for _, name := range []string{"Vesper Lynd", "James Bond", "Felix Leiter"} {
+		created = append(created, create(t, ur, newUser(name)).ID)
+	}
+
+	users, err := ur.GetAll(context.Background())
+	if err != nil {
+		t.Fatalf("unable to get all users due to: %v", err)
+	}
+
+	ids := make([]int64, len(users))
+	for i, user := range users {
+		ids[i], err = strconv.ParseInt(user.ID, 10, 64)
+		if err != nil {
+			t.Fatalf("unable to parse id %q due to: %v", user.ID, err)
+		}
+	}
+	assert.True(t, sort.SliceIsSorted(ids, func(i, j int) bool { return ids[i] < ids[j] }),
+		"expected users ordered by id, got %v", ids)
+	for _, id := range created {
+		assert.Contains(t, userIDs(users), id)
+	}
+}
+
+func testListPagesByCursor(t *testing.T, ur repository.UserRepository) {
+	var created []string
+	for i := 0; i < 5; i++ {
+		created = append(created, create(t, ur, newUser(fmt.Sprintf("Agent %d", i))).ID)
+	}
+	ids := make([]int, len(created))
+	for i, id := range created {
+		ids[i], _ = strconv.Atoi(id)
+	}
+	filter := repository.Filter{Conditions: []repository.Condition{
+		{Field: "id", Op: repository.OpIn, Value: ids},
+	}}
+
+	var listed []string
+	opts := repository.ListOptions{Limit: 2, Filter: filter}
+	for {
+		page, err := ur.List(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("unable to list users due to: %v", err)
+		}
+		assert.Equal(t, len(created), page.Total)
+		listed = append(listed, userIDs(page.Users)...)
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	assert.Equal(t, created, listed)
+}
+
+// testPrefixFilterIgnoresCase pins the prefix filter as case-insensitive,
+// whatever the collation or LIKE semantics of the backend
+func testPrefixFilterIgnoresCase(t *testing.T, ur repository.UserRepository) {
+	users := []models.User{
+		create(t, ur, newUser("Moneypenny")),
+		create(t, ur, newUser("moneypenny")),
+		create(t, ur, newUser("MONEY_penny")),
+		create(t, ur, newUser("Felix Leiter")),
+	}
+	filter := idFilter(users...)
+	filter.Conditions = append(filter.Conditions, repository.Condition{Field: "name", Op: repository.OpPrefix, Value: "mONEYp"})
+
+	page, err := ur.List(context.Background(), repository.ListOptions{Filter: filter})
+	if err != nil {
+		t.Fatalf("unable to list users due to: %v", err)
+	}
+
+	assert.Equal(t, []string{users[0].ID, users[1].ID}, userIDs(page.Users))
+}
+
+func testEach(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	var created []string
+	for _, name := range []string{"Agent B", "Agent C", "Agent A"} {
+		created = append(created, create(t, ur, newUser(name)).ID)
+	}
+	deleted := create(t, ur, newUser("Agent D"))
+	if err := ur.Delete(ctx, deleted); err != nil {
+		t.Fatalf("unable to delete user due to: %v", err)
+	}
+	ids := make([]int, len(created))
+	for i, id := range created {
+		ids[i], _ = strconv.Atoi(id)
+	}
+	n, _ := strconv.Atoi(deleted.ID)
+	opts := repository.ListOptions{
+		Limit:  1,
+		Sort:   []repository.SortField{{Field: "name", Descending: true}},
+		Filter: repository.Filter{Conditions: []repository.Condition{{Field: "id", Op: repository.OpIn, Value: append(ids, n)}}},
+	}
+
+	var users []models.User
+	err := ur.Each(ctx, opts, func(user models.User) error {
+		users = append(users, user)
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{created[1], created[0], created[2]}, userIDs(users))
+
+	opts.IncludeDeleted = true
+	users = nil
+	err = ur.Each(ctx, opts, func(user models.User) error {
+		users = append(users, user)
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{deleted.ID, created[1], created[0], created[2]}, userIDs(users))
+	assert.NotNil(t, users[0].DeletedAt)
+}
+
+func testEachStopsOnError(t *testing.T, ur repository.UserRepository) {
+	for i := 0; i < 3; i++ {
+		create(t, ur, newUser(fmt.Sprintf("Agent %d", i)))
+	}
+	stop := errors.New("stop")
+
+	calls := 0
+	err := ur.Each(context.Background(), repository.ListOptions{}, func(models.User) error {
+		calls++
+		return stop
+	})
+
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, calls)
+}
+
+func testTxCommits(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	user := create(t, ur, newUser("James Bond"))
+
+	var created string
+	err := ur.WithTx(ctx, func(tx repository.UserRepository) error {
+		var err error
+		if created, err = tx.Create(ctx, newUser("Felix Leiter")); err != nil {
+			return err
+		}
+		if _, err := tx.GetByID(ctx, created); err != nil {
+			return err
+		}
+		return tx.Delete(ctx, user)
+	})
+	if err != nil {
+		t.Fatalf("unable to run transaction due to: %v", err)
+	}
+
+	_, err = ur.GetByID(ctx, created)
+	assert.Nil(t, err)
+	_, err = ur.GetByID(ctx, user.ID)
+	assert.True(t, errors.Is(err, models.ErrNotFound), "expected not found, got %v", err)
+}
+
+func testTxRollsBack(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	user := create(t, ur, newUser("James Bond"))
+	failure := errors.New("blamo")
+
+	var created string
+	err := ur.WithTx(ctx, func(tx repository.UserRepository) error {
+		var err error
+		if created, err = tx.Create(ctx, newUser("Felix Leiter")); err != nil {
+			return err
+		}
+		updated := user
+		updated.Age = 44
+		if err := tx.Update(ctx, updated); err != nil {
+			return err
+		}
+		return failure
+	})
+
+	assert.Equal(t, failure, err)
+	_, err = ur.GetByID(ctx, created)
+	assert.True(t, errors.Is(err, models.ErrNotFound), "expected not found, got %v", err)
+	stored, err := ur.GetByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("unable to get user due to: %v", err)
+	}
+	assert.Equal(t, user, *stored)
+}
+
+func testNestedTxJoins(t *testing.T, ur repository.UserRepository) {
+	ctx := context.Background()
+	failure := errors.New("blamo")
+
+	var created string
+	err := ur.WithTx(ctx, func(tx repository.UserRepository) error {
+		err := tx.WithTx(ctx, func(nested repository.UserRepository) error {
+			var err error
+			created, err = nested.Create(ctx, newUser("James Bond"))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return failure
+	})
+
+	assert.Equal(t, failure, err)
+	_, err = ur.GetByID(ctx, created)
+	assert.True(t, errors.Is(err, models.ErrNotFound), "expected not found, got %v", err)
+}
+
+// testCanceledContext pins that no operation proceeds once its context is
+// canceled
+func testCanceledContext(t *testing.T, ur repository.UserRepository) {
+	user := create(t, ur, newUser("James Bond"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ur.GetAll(ctx)
+	assert.NotNil(t, err)
+	_, err = ur.GetByID(ctx, user.ID)
+	assert.NotNil(t, err)
+	_, err = ur.List(ctx, repository.ListOptions{})
+	assert.NotNil(t, err)
+	assert.NotNil(t, ur.Each(ctx, repository.ListOptions{}, func(models.User) error { return nil }))
+	_, err = ur.Create(ctx, newUser("Felix Leiter"))
+	assert.NotNil(t, err)
+	_, err = ur.CreateMany(ctx, []models.User{newUser("Felix Leiter")})
+	assert.NotNil(t, err)
+	assert.NotNil(t, ur.Update(ctx, user))
+	assert.NotNil(t, ur.Delete(ctx, user))
+	_, err = ur.History(ctx, user.ID, repository.HistoryOptions{})
+	assert.NotNil(t, err)
+	assert.NotNil(t, ur.WithTx(ctx, func(repository.UserRepository) error { return nil }))
+
+	_, err = ur.GetByID(context.Background(), user.ID)
+	assert.Nil(t, err)
+}
+
+func testConcurrentCreates(t *testing.T, ur repository.UserRepository) {
+	const workers = 20
+	ctx := context.Background()
+	ids := make([]string, workers)
+	errs := make([]error, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids[i], errs[i] = ur.Create(ctx, newUser(fmt.Sprintf("Agent %d", i)))
+			if errs[i] == nil {
+				_, errs[i] = ur.GetByID(ctx, ids[i])
+			}
+			if errs[i] == nil {
+				_, errs[i] = ur.List(ctx, repository.ListOptions{Limit: 5})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	seen := map[string]bool{}
+	for i := range ids {
+		assert.Nil(t, errs[i])
+		assert.False(t, seen[ids[i]], "id %q was assigned twice", ids[i])
+		seen[ids[i]] = true
+	}
+}
+
+func testConcurrentUpdates(t *testing.T, ur repository.UserRepository) {
+	const workers = 20
+	ctx := context.Background()
+	user := create(t, ur, newUser("James Bond"))
+	user.Version = 0
+	errs := make([]error, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			updated := user
+			updated.Age = i
+			errs[i] = ur.Update(ctx, updated)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.Nil(t, err)
+	}
+	stored := get(t, ur, user.ID)
+	assert.True(t, stored.Age >= 0 && stored.Age < workers, "expected the age of one update, got %d", stored.Age)
+	assert.Equal(t, workers+1, stored.Version)
+}
+
+// testConcurrentVersionedUpdates pins that of several updates conditioned on
+// the same version exactly one succeeds
+func testConcurrentVersionedUpdates(t *testing.T, ur repository.UserRepository) {
+	const workers = 20
+	ctx := context.Background()
+	user := create(t, ur, newUser("James Bond"))
+	errs := make([]error, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			updated := user
+			updated.Age = i
+			errs[i] = ur.Update(ctx, updated)
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		assert.True(t, errors.Is(err, models.ErrVersionMismatch), "expected version mismatch, got %v", err)
+	}
+	assert.Equal(t, 1, succeeded)
+	assert.Equal(t, 2, get(t, ur, user.ID).Version)
+}
+
+// idFilter restricts a listing to the given users
+func idFilter(users ...models.User) repository.Filter {
+	ids := make([]int, len(users))
+	for i, user := range users {
+		ids[i], _ = strconv.Atoi(user.ID)
+	}
+	return repository.Filter{Conditions: []repository.Condition{
+		{Field: "id", Op: repository.OpIn, Value: ids},
+	}}
+}
+
+func userIDs(users []models.User) []string {
+	ids := make([]string, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	return ids
+}
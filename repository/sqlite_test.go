@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ChrisTheShark/golang-mysql-api/migrations"
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/stretchr/testify/assert"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newSQLiteRepository(t *testing.T) UserRepository {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("unable to open SQLite database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	m, err := migrations.New(db, migrations.SQLite)
+	if err != nil {
+		t.Fatalf("unable to load SQLite migrations: %v", err)
+	}
+	if err := m.Up(context.Background()); err != nil {
+		t.Fatalf("unable to migrate SQLite database: %v", err)
+	}
+	return NewSQLiteUserRepository(db)
+}
+
+func TestSQLiteCRUD(t *testing.T) {
+	ur := newSQLiteRepository(t)
+	ctx := context.Background()
+
+	id, err := ur.Create(ctx, models.User{Name: "James Bond", Age: 43, Gender: "male"})
+	if err != nil {
+		t.Fatalf("unable to execute Create in TestSQLiteCRUD due to: %v", err)
+	}
+	assert.Equal(t, "1", id)
+
+	err = ur.Update(ctx, models.User{ID: id, Name: "James Bond", Age: 44, Gender: "male"})
+	assert.Nil(t, err)
+
+	user, err := ur.GetByID(ctx, id)
+	if err != nil {
+		t.Fatalf("unable to execute GetByID in TestSQLiteCRUD due to: %v", err)
+	}
+	assert.Equal(t, models.User{ID: id, Name: "James Bond", Age: 44, Gender: "male", Version: 2}, *user)
+
+	err = ur.Delete(ctx, *user)
+	assert.Nil(t, err)
+
+	_, err = ur.GetByID(ctx, id)
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+}
+
+func TestSQLiteListFilteredAndSorted(t *testing.T) {
+	ur := newSQLiteRepository(t)
+	ctx := context.Background()
+	for _, user := range listUsers {
+		ur.Create(ctx, user)
+	}
+
+	filter := Filter{Conditions: []Condition{
+		{Field: "age", Op: OpGte, Value: 32},
+		{Field: "name", Op: OpPrefix, Value: "J"},
+	}}
+	page, err := ur.List(ctx, ListOptions{Filter: filter})
+	if err != nil {
+		t.Fatalf("unable to execute List in TestSQLiteListFilteredAndSorted due to: %v", err)
+	}
+	assert.Equal(t, 1, page.Total)
+	assert.Equal(t, "James Bond", page.Users[0].Name)
+
+	opts := ListOptions{Limit: 3, Sort: []SortField{{Field: "age", Descending: true}, {Field: "name"}}}
+	page, err = ur.List(ctx, opts)
+	if err != nil {
+		t.Fatalf("unable to execute List in TestSQLiteListFilteredAndSorted due to: %v", err)
+	}
+	assert.Equal(t, []string{"Felix Leiter", "James Bond", "Vesper Lynd"},
+		[]string{page.Users[0].Name, page.Users[1].Name, page.Users[2].Name})
+
+	opts.Cursor = page.NextCursor
+	page, err = ur.List(ctx, opts)
+	if err != nil {
+		t.Fatalf("unable to execute List in TestSQLiteListFilteredAndSorted due to: %v", err)
+	}
+	assert.Len(t, page.Users, 1)
+	assert.Equal(t, "Eve Moneypenny", page.Users[0].Name)
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestSQLiteRejectsLongName(t *testing.T) {
+	ur := newSQLiteRepository(t)
+
+	_, err := ur.Create(context.Background(), models.User{Name: strings.Repeat("a", 101), Age: 43, Gender: "male"})
+
+	assert.True(t, errors.Is(err, models.ErrValidation))
+}
+
+func TestSQLiteNotFound(t *testing.T) {
+	ur := newSQLiteRepository(t)
+	ctx := context.Background()
+
+	err := ur.Update(ctx, models.User{ID: "99", Name: "James Bond", Age: 43, Gender: "male"})
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+
+	err = ur.Delete(ctx, models.User{ID: "99"})
+	assert.True(t, errors.Is(err, models.ErrNotFound))
+}
+
+// TestSQLiteVersionMigrationRoundTrip pins that reverting the version column
+// keeps the stored users and the AUTOINCREMENT sequence
+func TestSQLiteVersionMigrationRoundTrip(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("unable to open SQLite database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+	m, err := migrations.New(db, migrations.SQLite)
+	if err != nil {
+		t.Fatalf("unable to load SQLite migrations: %v", err)
+	}
+	ctx := context.Background()
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("unable to migrate SQLite database: %v", err)
+	}
+	ur := NewSQLiteUserRepository(db)
+	kept, _ := ur.Create(ctx, models.User{Name: "James Bond", Age: 43, Gender: "male"})
+	deleted, _ := ur.Create(ctx, models.User{Name: "Vesper Lynd", Age: 32, Gender: "female"})
+	ur.Delete(ctx, models.User{ID: deleted})
+
+	if err := m.To(ctx, 1); err != nil {
+		t.Fatalf("unable to revert SQLite migration due to: %v", err)
+	}
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("unable to migrate SQLite database: %v", err)
+	}
+
+	user, err := ur.GetByID(ctx, kept)
+	if err != nil {
+		t.Fatalf("unable to execute GetByID in TestSQLiteVersionMigrationRoundTrip due to: %v", err)
+	}
+	assert.Equal(t, 1, user.Version)
+	id, _ := ur.Create(ctx, models.User{Name: "Felix Leiter", Age: 40, Gender: "male"})
+	assert.Equal(t, "3", id)
+}
+
+func TestSQLiteCreateManyIsAllOrNothing(t *testing.T) {
+	ur := newSQLiteRepository(t)
+	ctx := context.Background()
+
+	users := []models.User{
+		{Name: "James Bond", Age: 43, Gender: "male"},
+		{Name: strings.Repeat("x", 101), Age: 43, Gender: "male"},
+	}
+	ids, err := ur.CreateMany(ctx, users)
+
+	assert.Nil(t, ids)
+	assert.True(t, errors.Is(err, models.ErrValidation), "expected validation error, got %v", err)
+	all, _ := ur.GetAll(ctx)
+	assert.Empty(t, all)
+}
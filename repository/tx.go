@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// TxAttempts bounds how many times WithTx runs a transaction that keeps
+// losing deadlocks or serialization conflicts
+const TxAttempts = 3
+
+// txBackoff is the pause before the second attempt of a transaction; it
+// grows linearly with each further attempt
+const txBackoff = 10 * time.Millisecond
+
+// querier is satisfied by both *sql.DB and *sql.Tx, so the same queries run
+// inside and outside of a transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (r UserRepositoryImpl) querier() querier {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.db
+}
+
+// WithTx runs fn in a database transaction, retrying it up to TxAttempts
+// times when it loses a deadlock or a serialization conflict. Calls nested
+// within fn join the enclosing transaction.
+func (r UserRepositoryImpl) WithTx(ctx context.Context, fn func(UserRepository) error) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+	for attempt := 1; ; attempt++ {
+		err := r.runTx(ctx, fn)
+		if err == nil || attempt == TxAttempts || !r.dialect.retryable(err) {
+			return err
+		}
+		select {
+		case <-time.After(time.Duration(attempt) * txBackoff):
+		case <-ctx.Done():
+			return r.dialect.wrapError("unable to run transaction", ctx.Err())
+		}
+	}
+}
+
+// atomically runs fn in a transaction, joining the enclosing one when
+// called within WithTx, so a write and its audit entry commit together
+func (r UserRepositoryImpl) atomically(ctx context.Context, fn func(UserRepositoryImpl) error) error {
+	return r.WithTx(ctx, func(tx UserRepository) error {
+		return fn(tx.(UserRepositoryImpl))
+	})
+}
+
+func (r UserRepositoryImpl) runTx(ctx context.Context, fn func(UserRepository) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return r.dialect.wrapError("unable to begin transaction", err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	scoped := r
+	scoped.tx = tx
+	if err := fn(scoped); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return r.dialect.wrapError("unable to commit transaction", err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+// deadlocks holds, per dialect, the error the driver reports when a
+// transaction loses a deadlock
+var deadlocks = map[string]error{
+	"mysql":    &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"},
+	"postgres": &pq.Error{Code: "40P01", Message: "deadlock detected"},
+}
+
+// deleteByID looks a user up and deletes it, as the DeleteUser controller
+// does
+func deleteByID(id string) func(UserRepository) error {
+	return func(tx UserRepository) error {
+		user, err := tx.GetByID(context.Background(), id)
+		if err != nil {
+			return err
+		}
+		return tx.Delete(context.Background(), *user)
+	}
+}
+
+func expectLockedUser(mock sqlmock.Sqlmock, d dialect) *sqlmock.ExpectedQuery {
+	return mock.ExpectQuery(query(d, "select id, name, age, gender, version, deleted_at from users where id = ? and deleted_at is null for update")).
+		WithArgs("1")
+}
+
+func TestWithTx(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		expectLockedUser(mock, d).
+			WillReturnRows(sqlmock.NewRows(userColumnNames).AddRow(1, "James Bond", 43, "male", 1, nil))
+		expectStoredUser(mock, d, "1", 1, "James Bond", 43, "male", 1, nil)
+		mock.ExpectExec(query(d, "update users set deleted_at = ?, version = version + 1 where id = ?")).
+			WithArgs(sqlmock.AnyArg(), "1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAudit(mock, d, "1", models.AuditDelete)
+		mock.ExpectCommit()
+
+		err := ur.WithTx(context.Background(), deleteByID("1"))
+
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestWithTxRollsBack(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		expectLockedUser(mock, d).
+			WillReturnRows(sqlmock.NewRows(userColumnNames))
+		mock.ExpectRollback()
+
+		err := ur.WithTx(context.Background(), deleteByID("1"))
+
+		assert.True(t, errors.Is(err, models.ErrNotFound))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestWithTxRetriesDeadlock(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		expectLockedUser(mock, d).
+			WillReturnError(deadlocks[d.name])
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		expectLockedUser(mock, d).
+			WillReturnRows(sqlmock.NewRows(userColumnNames).AddRow(1, "James Bond", 43, "male", 1, nil))
+		expectStoredUser(mock, d, "1", 1, "James Bond", 43, "male", 1, nil)
+		mock.ExpectExec("update users set deleted_at").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAudit(mock, d, "1", models.AuditDelete)
+		mock.ExpectCommit()
+
+		err := ur.WithTx(context.Background(), deleteByID("1"))
+
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestWithTxGivesUpAfterAttempts(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		for i := 0; i < TxAttempts; i++ {
+			mock.ExpectBegin()
+			expectLockedUser(mock, d).
+				WillReturnError(deadlocks[d.name])
+			mock.ExpectRollback()
+		}
+
+		err := ur.WithTx(context.Background(), deleteByID("1"))
+
+		assert.True(t, errors.Is(err, models.ErrUnavailable))
+		assert.True(t, errors.Is(err, deadlocks[d.name]))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestWithTxDoesNotRetryOtherErrors(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		err := ur.WithTx(context.Background(), func(UserRepository) error {
+			return errors.New("blamo")
+		})
+
+		assert.Equal(t, "blamo", err.Error())
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestWithTxBeginError(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin().
+			WillReturnError(errors.New("blamo"))
+
+		err := ur.WithTx(context.Background(), deleteByID("1"))
+
+		assert.True(t, errors.Is(err, models.ErrUnavailable))
+		assert.Equal(t, "unable to begin transaction due to: blamo", err.Error())
+	})
+}
+
+func TestWithTxCommitError(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectCommit().
+			WillReturnError(errors.New("blamo"))
+
+		err := ur.WithTx(context.Background(), func(UserRepository) error { return nil })
+
+		assert.True(t, errors.Is(err, models.ErrUnavailable))
+		assert.Equal(t, "unable to commit transaction due to: blamo", err.Error())
+	})
+}
@@ -1,86 +1,698 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ChrisTheShark/golang-mysql-api/models"
 )
 
-// UserRepository interface describes repository operations on Users
+// UserRepository interface describes repository operations on Users. Every
+// operation honors the cancellation and deadline of the supplied context.
+// Deleted users are only soft deleted: they are hidden from every read
+// except a List with IncludeDeleted until restored or purged. Every write
+// is recorded in an audit trail, attributed to the actor and request set on
+// its context with WithActor and WithRequestID.
+// The contract shared by all implementations is pinned by the
+// repositorytest conformance suite.
 type UserRepository interface {
-	GetAll() ([]models.User, error)
-	GetByID(string) (*models.User, error)
-	Create(models.User) (string, error)
-	Delete(models.User) error
+	GetAll(context.Context) ([]models.User, error)
+	GetByID(context.Context, string) (*models.User, error)
+	List(context.Context, ListOptions) (*UserPage, error)
+	// Each calls fn with every user a List with the same options would
+	// list, across all pages, as they are read rather than once all have
+	// been. Limit, Offset and Cursor are ignored. fn must not use the
+	// repository; an error returned by fn stops the iteration and is
+	// returned by Each.
+	Each(context.Context, ListOptions, func(models.User) error) error
+	Create(context.Context, models.User) (string, error)
+	// CreateMany creates every user or, failing that, none of them,
+	// returning their IDs in order
+	CreateMany(context.Context, []models.User) ([]string, error)
+	Update(context.Context, models.User) error
+	Delete(context.Context, models.User) error
+	// Restore undoes the soft delete of a user
+	Restore(context.Context, models.User) error
+	// Purge permanently removes the users soft deleted before the given
+	// time, returning how many were removed
+	Purge(context.Context, time.Time) (int, error)
+	// History returns a page of the audit trail of a user, deleted or not
+	History(context.Context, string, HistoryOptions) (*AuditPage, error)
+	// WithTx runs fn as a single unit of work, passing it a UserRepository
+	// whose operations all take part in one transaction. The transaction is
+	// committed when fn returns nil and rolled back otherwise. Implementations
+	// may run fn more than once when the transaction loses a deadlock, so fn
+	// must not have side effects outside the repository.
+	WithTx(ctx context.Context, fn func(UserRepository) error) error
 }
 
-// UserRepositoryImpl houses logic to retrieve users from a mongo repository
+// UserRepositoryImpl houses logic to retrieve users from a SQL repository
 type UserRepositoryImpl struct {
-	db *sql.DB
+	db      *sql.DB
+	tx      *sql.Tx
+	dialect dialect
 }
 
-// NewUserRepository convenience function to create a UserRepository
+// NewUserRepository convenience function to create a UserRepository backed
+// by MySQL
 func NewUserRepository(db *sql.DB) UserRepository {
-	return &UserRepositoryImpl{db}
+	return &UserRepositoryImpl{db: db, dialect: mysqlDialect}
 }
 
-// GetAll get all users from the repository
-func (r UserRepositoryImpl) GetAll() ([]models.User, error) {
+// NewSQLiteUserRepository convenience function to create a UserRepository
+// backed by SQLite
+func NewSQLiteUserRepository(db *sql.DB) UserRepository {
+	return &UserRepositoryImpl{db: db, dialect: sqliteDialect}
+}
+
+// NewPostgresUserRepository convenience function to create a UserRepository
+// backed by PostgreSQL
+func NewPostgresUserRepository(db *sql.DB) UserRepository {
+	return &UserRepositoryImpl{db: db, dialect: postgresDialect}
+}
+
+// validID reports whether id could identify a stored user. IDs are
+// generated integers; other values are rejected before reaching databases
+// such as Postgres, which refuse to compare them with an integer column.
+func validID(id string) bool {
+	_, err := strconv.ParseInt(id, 10, 64)
+	return err == nil
+}
+
+// userColumns lists the columns scanned by scanUser, in order
+const userColumns = "id, name, age, gender, version, deleted_at"
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(s scanner) (models.User, error) {
+	var user models.User
+	var deletedAt nullTime
+	err := s.Scan(&user.ID, &user.Name, &user.Age, &user.Gender, &user.Version, &deletedAt)
+	user.DeletedAt = deletedAt.ptr()
+	return user, err
+}
+
+// nullTime scans a nullable column holding a time, whether or not the
+// driver has been configured to parse times (parseTime=true for MySQL)
+type nullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+func (t *nullTime) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*t = nullTime{}
+	case time.Time:
+		*t = nullTime{Time: v, Valid: true}
+	case []byte:
+		return t.Scan(string(v))
+	case string:
+		for _, layout := range []string{"2006-01-02 15:04:05.999999999", "2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano} {
+			if parsed, err := time.Parse(layout, v); err == nil {
+				*t = nullTime{Time: parsed, Valid: true}
+				return nil
+			}
+		}
+		return fmt.Errorf("unable to parse time %q", v)
+	default:
+		return fmt.Errorf("unable to scan %T into a time", src)
+	}
+	return nil
+}
+
+// ptr returns the time in UTC, or nil when the column was null
+func (t nullTime) ptr() *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	utc := t.Time.UTC()
+	return &utc
+}
+
+// GetAll get all users from the repository ordered by ID
+func (r UserRepositoryImpl) GetAll(ctx context.Context) ([]models.User, error) {
 	users := []models.User{}
 
-	rows, err := r.db.Query("select id, name, age, gender from users")
+	rows, err := r.querier().QueryContext(ctx, "select "+userColumns+" from users where deleted_at is null order by id")
 	if err != nil {
-		return nil, fmt.Errorf("unable to locate users due to: %v", err)
+		return nil, r.dialect.wrapError("unable to locate users", err)
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var user models.User
-		if err := rows.Scan(&user.ID, &user.Name, &user.Age, &user.Gender); err != nil {
-			return nil, fmt.Errorf("unable to locate users due to: %v", err)
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, r.dialect.wrapError("unable to locate users", err)
 		}
 		users = append(users, user)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, r.dialect.wrapError("unable to locate users", err)
+	}
 
 	return users, nil
 }
 
-// GetByID get a user by string identifier
-func (r UserRepositoryImpl) GetByID(id string) (*models.User, error) {
-	var user models.User
-	row := r.db.QueryRow("select id, name, age, gender from users where id = ?", id)
-	if err := row.Scan(&user.ID, &user.Name, &user.Age, &user.Gender); err != nil {
-		return nil, fmt.Errorf("unable to locate user due to: %v", err)
+// GetByID get a user by string identifier. Within WithTx the user is
+// locked until the transaction ends.
+func (r UserRepositoryImpl) GetByID(ctx context.Context, id string) (*models.User, error) {
+	if !validID(id) {
+		return nil, notFound()
+	}
+	query := "select " + userColumns + " from users where id = ? and deleted_at is null"
+	if r.tx != nil {
+		// Lock the row so it cannot change before the transaction ends.
+		query += r.dialect.lockRows
+	}
+	user, err := scanUser(r.querier().QueryRowContext(ctx, r.dialect.rebind(query), id))
+	if err != nil {
+		return nil, r.dialect.wrapError("unable to locate user", err)
 	}
 	return &user, nil
 }
 
+// List get a page of users from the repository
+func (r UserRepositoryImpl) List(ctx context.Context, opts ListOptions) (*UserPage, error) {
+	opts = opts.normalize()
+	page := &UserPage{Users: []models.User{}}
+
+	where, args := listClause(opts)
+	countQuery := "select count(*) from users"
+	if where != "" {
+		countQuery += " where " + where
+	}
+	countArgs := append([]interface{}{}, args...)
+
+	if opts.Cursor != "" {
+		values, err := decodeCursor(opts.Sort, opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		clause, cursorArgs := keysetClause(opts.Sort, values)
+		if where != "" {
+			where += " and "
+		}
+		where += clause
+		args = append(args, cursorArgs...)
+	}
+
+	row := r.querier().QueryRowContext(ctx, r.dialect.rebind(countQuery), countArgs...)
+	if err := row.Scan(&page.Total); err != nil {
+		return nil, r.dialect.wrapError("unable to count users", err)
+	}
+
+	query := "select " + userColumns + " from users"
+	if where != "" {
+		query += " where " + where
+	}
+	// Fetch one row beyond the page to learn whether another page follows.
+	query += orderByClause(opts.Sort) + " limit ? offset ?"
+	args = append(args, opts.Limit+1, opts.Offset)
+
+	rows, err := r.querier().QueryContext(ctx, r.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, r.dialect.wrapError("unable to locate users", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, r.dialect.wrapError("unable to locate users", err)
+		}
+		page.Users = append(page.Users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, r.dialect.wrapError("unable to locate users", err)
+	}
+
+	if len(page.Users) > opts.Limit {
+		page.Users = page.Users[:opts.Limit]
+		page.NextCursor = encodeCursor(opts.Sort, page.Users[opts.Limit-1])
+	}
+	return page, nil
+}
+
+// Each streams the users matching opts from a single query
+func (r UserRepositoryImpl) Each(ctx context.Context, opts ListOptions, fn func(models.User) error) error {
+	opts = opts.normalize()
+	where, args := listClause(opts)
+	query := "select " + userColumns + " from users"
+	if where != "" {
+		query += " where " + where
+	}
+	query += orderByClause(opts.Sort)
+
+	rows, err := r.querier().QueryContext(ctx, r.dialect.rebind(query), args...)
+	if err != nil {
+		return r.dialect.wrapError("unable to locate users", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return r.dialect.wrapError("unable to locate users", err)
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return r.dialect.wrapError("unable to locate users", err)
+	}
+	return nil
+}
+
+// listClause renders the where clause selecting the users listed with opts,
+// leaving out the cursor
+func listClause(opts ListOptions) (string, []interface{}) {
+	where, args := opts.Filter.clause()
+	if opts.IncludeDeleted {
+		return where, args
+	}
+	if where != "" {
+		return "deleted_at is null and " + where, args
+	}
+	return "deleted_at is null", args
+}
+
 // Create a User to the repository
-func (r UserRepositoryImpl) Create(user models.User) (string, error) {
-	result, err := r.db.Exec("insert into users (name, age, gender) values (?, ?, ?)",
-		user.Name, user.Age, user.Gender)
+func (r UserRepositoryImpl) Create(ctx context.Context, user models.User) (string, error) {
+	var id string
+	err := r.atomically(ctx, func(tx UserRepositoryImpl) error {
+		var err error
+		if id, err = tx.insert(ctx, user); err != nil {
+			return err
+		}
+		created := user
+		created.ID, created.Version, created.DeletedAt = id, 1, nil
+		return tx.audit(ctx, "unable to create user", NewAuditEntry(ctx, models.AuditCreate, nil, &created))
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// insertBatchRows bounds the rows of a single multi-row insert, keeping its
+// bind parameters within the 999 older SQLite releases allow
+const insertBatchRows = 300
+
+// CreateMany creates users with multi-row inserts in a single transaction
+func (r UserRepositoryImpl) CreateMany(ctx context.Context, users []models.User) ([]string, error) {
+	ids := make([]string, 0, len(users))
+	if len(users) == 0 {
+		return ids, nil
+	}
+	err := r.atomically(ctx, func(tx UserRepositoryImpl) error {
+		ids = ids[:0]
+		for start := 0; start < len(users); start += insertBatchRows {
+			end := start + insertBatchRows
+			if end > len(users) {
+				end = len(users)
+			}
+			batch, err := tx.insertMany(ctx, users[start:end])
+			if err != nil {
+				return err
+			}
+			ids = append(ids, batch...)
+		}
+
+		entries := make([]models.AuditEntry, len(users))
+		for i, user := range users {
+			created := user
+			created.ID, created.Version, created.DeletedAt = ids[i], 1, nil
+			entries[i] = NewAuditEntry(ctx, models.AuditCreate, nil, &created)
+		}
+		return tx.audit(ctx, "unable to create users", entries...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// insertMany inserts users with a single statement. Without a RETURNING
+// clause only one generated ID is reported, so the others are derived from
+// LastInsertId. That assumes the rows of a multi-row insert are given IDs
+// in order, a step apart: MySQL allocates the IDs of an insert with a
+// VALUES list as one block under every innodb_autoinc_lock_mode, spaced by
+// auto_increment_increment, and SQLite writers run one at a time.
+func (r UserRepositoryImpl) insertMany(ctx context.Context, users []models.User) ([]string, error) {
+	if len(users) == 0 {
+		return nil, nil
+	}
+	query := "insert into users (name, age, gender) values " +
+		strings.TrimSuffix(strings.Repeat("(?, ?, ?), ", len(users)), ", ")
+	args := make([]interface{}, 0, 3*len(users))
+	for _, user := range users {
+		args = append(args, user.Name, user.Age, user.Gender)
+	}
+
+	ids := make([]string, 0, len(users))
+	if r.dialect.returning {
+		rows, err := r.tx.QueryContext(ctx, r.dialect.rebind(query+" returning id"), args...)
+		if err != nil {
+			return nil, r.dialect.wrapError("unable to create users", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return nil, r.dialect.wrapError("unable to create users", err)
+			}
+			ids = append(ids, strconv.FormatInt(id, 10))
+		}
+		if err := rows.Err(); err != nil {
+			return nil, r.dialect.wrapError("unable to create users", err)
+		}
+		return ids, nil
+	}
+
+	result, err := r.tx.ExecContext(ctx, r.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, r.dialect.wrapError("unable to create users", err)
+	}
+	first, err := result.LastInsertId()
+	if err != nil {
+		return nil, r.dialect.wrapError("unable to create users", err)
+	}
+	step := int64(1)
+	if r.dialect.idStep != "" {
+		if err := r.tx.QueryRowContext(ctx, r.dialect.idStep).Scan(&step); err != nil {
+			return nil, r.dialect.wrapError("unable to create users", err)
+		}
+	}
+	if r.dialect.lastInsertIsLast {
+		first -= step * int64(len(users)-1)
+	}
+	for i := range users {
+		ids = append(ids, strconv.FormatInt(first+step*int64(i), 10))
+	}
+	return ids, nil
+}
+
+func (r UserRepositoryImpl) insert(ctx context.Context, user models.User) (string, error) {
+	query := "insert into users (name, age, gender) values (?, ?, ?)"
+	if r.dialect.returning {
+		var id int64
+		row := r.tx.QueryRowContext(ctx, r.dialect.rebind(query+" returning id"), user.Name, user.Age, user.Gender)
+		if err := row.Scan(&id); err != nil {
+			return "", r.dialect.wrapError("unable to create user", err)
+		}
+		return strconv.FormatInt(id, 10), nil
+	}
+
+	result, err := r.tx.ExecContext(ctx, r.dialect.rebind(query), user.Name, user.Age, user.Gender)
 	if err != nil {
-		return "", fmt.Errorf("unable to create user due to: %v", err)
+		return "", r.dialect.wrapError("unable to create user", err)
 	}
 
 	id, err := result.LastInsertId()
 	if err != nil {
-		return "", fmt.Errorf("unable to create user due to: %v", err)
+		return "", r.dialect.wrapError("unable to create user", err)
 	}
 	return strconv.FormatInt(id, 10), nil
 }
 
-// Delete a User from the repository
-func (r UserRepositoryImpl) Delete(user models.User) error {
-	result, err := r.db.Exec("delete from users where id = ?", user.ID)
+// Update replaces all mutable fields of an existing User in the repository
+// and increments its version. A non-zero Version makes the update
+// conditional on the stored user still being at that version.
+func (r UserRepositoryImpl) Update(ctx context.Context, user models.User) error {
+	return r.write(ctx, models.AuditUpdate, user, func(tx UserRepositoryImpl, before models.User) (*models.User, error) {
+		if before.DeletedAt != nil {
+			return nil, notFound()
+		}
+		if err := checkVersion(user, before); err != nil {
+			return nil, err
+		}
+		query := "update users set name = ?, age = ?, gender = ?, version = version + 1 where id = ?"
+		if _, err := tx.tx.ExecContext(ctx, tx.dialect.rebind(query), user.Name, user.Age, user.Gender, user.ID); err != nil {
+			return nil, tx.dialect.wrapError("unable to update user", err)
+		}
+		after := user
+		after.Version = before.Version + 1
+		after.DeletedAt = nil
+		return &after, nil
+	})
+}
+
+// Delete soft deletes a User from the repository, stamping it with the
+// current time and incrementing its version. A non-zero Version makes the
+// delete conditional on the stored user still being at that version.
+func (r UserRepositoryImpl) Delete(ctx context.Context, user models.User) error {
+	return r.write(ctx, models.AuditDelete, user, func(tx UserRepositoryImpl, before models.User) (*models.User, error) {
+		if before.DeletedAt != nil {
+			return nil, notFound()
+		}
+		if err := checkVersion(user, before); err != nil {
+			return nil, err
+		}
+		now := time.Now().UTC()
+		query := "update users set deleted_at = ?, version = version + 1 where id = ?"
+		if _, err := tx.tx.ExecContext(ctx, tx.dialect.rebind(query), now, user.ID); err != nil {
+			return nil, tx.dialect.wrapError("unable to delete user", err)
+		}
+		after := before
+		after.Version++
+		after.DeletedAt = &now
+		return &after, nil
+	})
+}
+
+// Restore undoes the soft delete of a User and increments its version. A
+// non-zero Version makes the restore conditional on the stored user still
+// being at that version. Restoring a user that is not deleted is reported as
+// a conflict.
+func (r UserRepositoryImpl) Restore(ctx context.Context, user models.User) error {
+	return r.write(ctx, models.AuditRestore, user, func(tx UserRepositoryImpl, before models.User) (*models.User, error) {
+		if before.DeletedAt == nil {
+			return nil, notDeleted()
+		}
+		if err := checkVersion(user, before); err != nil {
+			return nil, err
+		}
+		query := "update users set deleted_at = null, version = version + 1 where id = ?"
+		if _, err := tx.tx.ExecContext(ctx, tx.dialect.rebind(query), user.ID); err != nil {
+			return nil, tx.dialect.wrapError("unable to restore user", err)
+		}
+		after := before
+		after.Version++
+		after.DeletedAt = nil
+		return &after, nil
+	})
+}
+
+// Purge permanently removes the users soft deleted before the given time,
+// recording each in the audit trail
+func (r UserRepositoryImpl) Purge(ctx context.Context, before time.Time) (int, error) {
+	var purged int
+	err := r.atomically(ctx, func(tx UserRepositoryImpl) error {
+		query := "select " + userColumns + " from users where deleted_at < ? order by id" + tx.dialect.lockRows
+		users, err := tx.scanUsers(tx.tx.QueryContext(ctx, tx.dialect.rebind(query), before.UTC()))
+		if err != nil {
+			return tx.dialect.wrapError("unable to purge users", err)
+		}
+
+		for i := range users {
+			if _, err := tx.tx.ExecContext(ctx, tx.dialect.rebind("delete from users where id = ?"), users[i].ID); err != nil {
+				return tx.dialect.wrapError("unable to purge users", err)
+			}
+			if err := tx.audit(ctx, "unable to purge users", NewAuditEntry(ctx, models.AuditPurge, &users[i], nil)); err != nil {
+				return err
+			}
+		}
+		purged = len(users)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// History returns a page of the audit trail of a user, oldest entry first
+func (r UserRepositoryImpl) History(ctx context.Context, id string, opts HistoryOptions) (*AuditPage, error) {
+	opts = opts.normalize()
+	page := &AuditPage{Entries: []models.AuditEntry{}}
+	if !validID(id) {
+		return page, nil
+	}
+
+	row := r.querier().QueryRowContext(ctx, r.dialect.rebind("select count(*) from user_audit where user_id = ?"), id)
+	if err := row.Scan(&page.Total); err != nil {
+		return nil, r.dialect.wrapError("unable to count audit entries", err)
+	}
+
+	query := "select id, user_id, operation, actor, request_id, before_snapshot, after_snapshot, created_at" +
+		" from user_audit where user_id = ? order by id limit ? offset ?"
+	rows, err := r.querier().QueryContext(ctx, r.dialect.rebind(query), id, opts.Limit, opts.Offset)
 	if err != nil {
-		return fmt.Errorf("unable to delete user due to: %v", err)
+		return nil, r.dialect.wrapError("unable to locate audit entries", err)
 	}
+	defer rows.Close()
 
-	re, err := result.RowsAffected()
-	if err != nil || re != 1 {
-		return fmt.Errorf("unable to delete user due to: %v", err)
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, r.dialect.wrapError("unable to locate audit entries", err)
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, r.dialect.wrapError("unable to locate audit entries", err)
+	}
+	return page, nil
+}
+
+// write applies a change to a stored user in a transaction and records it
+// in the audit trail. The user, soft deleted or not, is locked and read
+// first so apply can check the change against it; apply returns the user as
+// changed.
+func (r UserRepositoryImpl) write(ctx context.Context, operation string, user models.User, apply func(UserRepositoryImpl, models.User) (*models.User, error)) error {
+	if !validID(user.ID) {
+		return notFound()
+	}
+	message := "unable to " + operation + " user"
+	return r.atomically(ctx, func(tx UserRepositoryImpl) error {
+		query := "select " + userColumns + " from users where id = ?" + tx.dialect.lockRows
+		before, err := scanUser(tx.tx.QueryRowContext(ctx, tx.dialect.rebind(query), user.ID))
+		if err != nil {
+			return tx.dialect.wrapError(message, err)
+		}
+		after, err := apply(tx, before)
+		if err != nil {
+			return err
+		}
+		return tx.audit(ctx, message, NewAuditEntry(ctx, operation, &before, after))
+	})
+}
+
+// checkVersion reports a version mismatch when user is conditioned on a
+// version other than that of the stored user
+func checkVersion(user, stored models.User) error {
+	if user.Version != 0 && user.Version != stored.Version {
+		return versionMismatch()
 	}
 	return nil
 }
+
+// scanUsers reads every user returned by a query
+func (r UserRepositoryImpl) scanUsers(rows *sql.Rows, err error) ([]models.User, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// auditBatchRows bounds the entries recorded by a single multi-row insert,
+// keeping its bind parameters within the 999 older SQLite releases allow
+const auditBatchRows = 100
+
+// audit records entries in the audit trail, within the transaction of the
+// writes they describe
+func (r UserRepositoryImpl) audit(ctx context.Context, message string, entries ...models.AuditEntry) error {
+	for start := 0; start < len(entries); start += auditBatchRows {
+		end := start + auditBatchRows
+		if end > len(entries) {
+			end = len(entries)
+		}
+		batch := entries[start:end]
+
+		args := make([]interface{}, 0, 7*len(batch))
+		for _, entry := range batch {
+			before, err := snapshot(entry.Before)
+			if err != nil {
+				return r.dialect.wrapError(message, err)
+			}
+			after, err := snapshot(entry.After)
+			if err != nil {
+				return r.dialect.wrapError(message, err)
+			}
+			args = append(args, entry.UserID, entry.Operation, entry.Actor, entry.RequestID, before, after, entry.At)
+		}
+		query := "insert into user_audit (user_id, operation, actor, request_id, before_snapshot, after_snapshot, created_at) values " +
+			strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?, ?, ?, ?), ", len(batch)), ", ")
+		if _, err := r.tx.ExecContext(ctx, r.dialect.rebind(query), args...); err != nil {
+			return r.dialect.wrapError(message, err)
+		}
+	}
+	return nil
+}
+
+// userSnapshot is a user as recorded in the audit trail. Unlike the JSON
+// encoding of models.User, which leaves the version to the ETag header, it
+// holds the version.
+type userSnapshot struct {
+	Name      string     `json:"name"`
+	Gender    string     `json:"gender"`
+	Age       int        `json:"age"`
+	ID        string     `json:"id"`
+	Version   int        `json:"version"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// snapshot encodes a user as JSON for the audit trail, or as null when
+// there is none
+func snapshot(user *models.User) (interface{}, error) {
+	if user == nil {
+		return nil, nil
+	}
+	bs, err := json.Marshal(userSnapshot(*user))
+	if err != nil {
+		return nil, err
+	}
+	return string(bs), nil
+}
+
+func scanAuditEntry(s scanner) (models.AuditEntry, error) {
+	var entry models.AuditEntry
+	var before, after []byte
+	var at nullTime
+	err := s.Scan(&entry.ID, &entry.UserID, &entry.Operation, &entry.Actor, &entry.RequestID, &before, &after, &at)
+	if err != nil {
+		return entry, err
+	}
+	if entry.Before, err = unsnapshot(before); err != nil {
+		return entry, err
+	}
+	if entry.After, err = unsnapshot(after); err != nil {
+		return entry, err
+	}
+	entry.At = at.Time.UTC()
+	return entry, nil
+}
+
+// unsnapshot decodes a user snapshot recorded by audit. Snapshots recorded
+// before versions were leave Version zero.
+func unsnapshot(bs []byte) (*models.User, error) {
+	if bs == nil {
+		return nil, nil
+	}
+	var s userSnapshot
+	if err := json.Unmarshal(bs, &s); err != nil {
+		return nil, err
+	}
+	user := models.User(s)
+	return &user, nil
+}
@@ -1,204 +1,388 @@
 package repository
 
 import (
+	"context"
+	"database/sql/driver"
 	"errors"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
 )
 
-func TestGetAll(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("unable to create mock DB object: %v", err)
-	}
-	defer db.Close()
+// dialects lists the SQL dialects UserRepositoryImpl is tested against
+var dialects = []dialect{mysqlDialect, postgresDialect}
 
-	expectedUsers := []models.User{
-		models.User{
-			ID:     "1",
-			Name:   "James Bond",
-			Age:    43,
-			Gender: "male",
-		},
+// forEachDialect runs a test once per dialect, each with a repository over a
+// fresh mock database
+func forEachDialect(t *testing.T, test func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock)) {
+	for _, d := range dialects {
+		t.Run(d.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("unable to create mock DB object: %v", err)
+			}
+			defer db.Close()
+
+			test(t, d, &UserRepositoryImpl{db: db, dialect: d}, mock)
+		})
 	}
+}
 
-	rows := sqlmock.NewRows([]string{"id", "name", "age", "gender"}).
-		AddRow(1, expectedUsers[0].Name, expectedUsers[0].Age, expectedUsers[0].Gender)
-	mock.ExpectQuery("select (.+) from users").
-		WillReturnRows(rows)
+// query returns a pattern matching exactly the query, written with ?
+// placeholders, as the dialect issues it
+func query(d dialect, q string) string {
+	return regexp.QuoteMeta(d.rebind(q))
+}
 
-	ur := NewUserRepository(db)
-	users, err := ur.GetAll()
+// expectInsert expects the statement Create issues in the dialect, answering
+// it with the generated id or, when err is set, with err
+func expectInsert(mock sqlmock.Sqlmock, d dialect, id int64, err error) {
+	if d.returning {
+		e := mock.ExpectQuery("insert into users (.+) returning id")
+		if err != nil {
+			e.WillReturnError(err)
+			return
+		}
+		e.WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(id))
+		return
+	}
+	e := mock.ExpectExec("insert into users")
 	if err != nil {
-		t.Fatalf("unable to execute GetAll in TestGetAll due to: %v", err)
+		e.WillReturnError(err)
+		return
 	}
+	e.WillReturnResult(sqlmock.NewResult(id, 1))
+}
+
+// driverErrors holds, per dialect, the errors the driver reports for a
+// duplicate key and for a value too long for its column
+var driverErrors = map[string]struct{ duplicate, tooLong error }{
+	"mysql": {
+		&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"},
+		&mysql.MySQLError{Number: 1406, Message: "Data too long for column 'name'"},
+	},
+	"postgres": {
+		&pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint"},
+		&pq.Error{Code: "22001", Message: "value too long for type character varying(100)"},
+	},
+}
+
+func TestGetAll(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		expectedUsers := []models.User{
+			models.User{
+				ID:      "1",
+				Name:    "James Bond",
+				Age:     43,
+				Gender:  "male",
+				Version: 1,
+			},
+		}
+
+		rows := sqlmock.NewRows([]string{"id", "name", "age", "gender", "version", "deleted_at"}).
+			AddRow(1, expectedUsers[0].Name, expectedUsers[0].Age, expectedUsers[0].Gender, expectedUsers[0].Version, nil)
+		mock.ExpectQuery("select (.+) from users").
+			WillReturnRows(rows)
+
+		users, err := ur.GetAll(context.Background())
+		if err != nil {
+			t.Fatalf("unable to execute GetAll in TestGetAll due to: %v", err)
+		}
 
-	assert.Equal(t, expectedUsers, users)
+		assert.Equal(t, expectedUsers, users)
+	})
 }
 
 func TestGetAllQueryError(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("unable to create mock DB object: %v", err)
-	}
-	defer db.Close()
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("select (.+) from users").
+			WillReturnError(errors.New("blamo"))
 
-	mock.ExpectQuery("select (.+) from users").
-		WillReturnError(errors.New("blamo"))
+		users, err := ur.GetAll(context.Background())
 
-	ur := NewUserRepository(db)
-	users, err := ur.GetAll()
+		assert.Nil(t, users)
+		assert.NotNil(t, err)
+		assert.Equal(t, "unable to locate users due to: blamo", err.Error())
+	})
+}
 
-	assert.Nil(t, users)
-	assert.NotNil(t, err)
-	assert.Equal(t, "unable to locate users due to: blamo", err.Error())
+func TestGetAllContextDeadline(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		rows := sqlmock.NewRows([]string{"id", "name", "age", "gender", "version", "deleted_at"}).
+			AddRow(1, "James Bond", 43, "male", 1, nil)
+		mock.ExpectQuery("select (.+) from users").
+			WillDelayFor(time.Second).
+			WillReturnRows(rows)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		users, err := ur.GetAll(ctx)
+
+		assert.Nil(t, users)
+		assert.True(t, errors.Is(err, models.ErrUnavailable))
+	})
 }
 
 func TestGetAllRowScanError(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("unable to create mock DB object: %v", err)
-	}
-	defer db.Close()
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		expectedUsers := []models.User{
+			models.User{
+				ID:     "1",
+				Name:   "James Bond",
+				Age:    43,
+				Gender: "male",
+			},
+		}
+
+		// Adding a value of type string to the rows for age, this should
+		// trigger a row scan error as go attempts to set a string value into
+		// an int field.
+		rows := sqlmock.NewRows([]string{"id", "name", "age", "gender", "version", "deleted_at"}).
+			AddRow(expectedUsers[0].ID, expectedUsers[0].Name, "expectedUsers[0].Age", expectedUsers[0].Gender, 1, nil)
+		mock.ExpectQuery("select (.+) from users").
+			WillReturnRows(rows)
 
-	expectedUsers := []models.User{
-		models.User{
+		users, err := ur.GetAll(context.Background())
+
+		assert.Nil(t, users)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetByID(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		expectedUser := models.User{
 			ID:     "1",
 			Name:   "James Bond",
 			Age:    43,
 			Gender: "male",
-		},
-	}
+		}
 
-	// Adding a value of type string to the rows for age, this should
-	// trigger a row scan error as go attempts to set a string value into
-	// an int field.
-	rows := sqlmock.NewRows([]string{"id", "name", "age", "gender"}).
-		AddRow(expectedUsers[0].ID, expectedUsers[0].Name, "expectedUsers[0].Age", expectedUsers[0].Gender)
-	mock.ExpectQuery("select (.+) from users").
-		WillReturnRows(rows)
+		rows := sqlmock.NewRows([]string{"id", "name", "age", "gender", "version", "deleted_at"}).
+			AddRow(1, expectedUser.Name, expectedUser.Age, expectedUser.Gender, 1, nil)
+		mock.ExpectQuery("select (.+) from users").
+			WillReturnRows(rows)
 
-	ur := NewUserRepository(db)
-	users, err := ur.GetAll()
+		user, err := ur.GetByID(context.Background(), "1")
+		if err != nil {
+			t.Fatalf("unable to execute GetByID in TestGetByID due to: %v", err)
+		}
 
-	assert.Nil(t, users)
-	assert.NotNil(t, err)
+		assert.NotEmpty(t, user)
+		assert.Equal(t, expectedUser.ID, user.ID)
+		assert.Equal(t, expectedUser.Name, user.Name)
+		assert.Equal(t, expectedUser.Age, user.Age)
+		assert.Equal(t, expectedUser.Gender, user.Gender)
+		assert.Equal(t, 1, user.Version)
+	})
 }
 
-func TestGetByID(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("unable to create mock DB object: %v", err)
-	}
-	defer db.Close()
+// TestGetAllDeletedAtText pins that deleted_at is read from MySQL
+// connections that were not configured with parseTime=true
+func TestGetAllDeletedAtText(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		rows := sqlmock.NewRows([]string{"id", "name", "age", "gender", "version", "deleted_at"}).
+			AddRow(1, "James Bond", 43, "male", 2, []byte("2020-01-02 03:04:05"))
+		mock.ExpectQuery("select (.+) from users").
+			WillReturnRows(rows)
 
-	expectedUser := models.User{
-		ID:     "1",
-		Name:   "James Bond",
-		Age:    43,
-		Gender: "male",
-	}
+		users, err := ur.GetAll(context.Background())
+		if err != nil {
+			t.Fatalf("unable to execute GetAll in TestGetAllDeletedAtText due to: %v", err)
+		}
 
-	rows := sqlmock.NewRows([]string{"id", "name", "age", "gender"}).
-		AddRow(1, expectedUser.Name, expectedUser.Age, expectedUser.Gender)
-	mock.ExpectQuery("select (.+) from users").
-		WillReturnRows(rows)
+		if assert.NotNil(t, users[0].DeletedAt) {
+			assert.Equal(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), *users[0].DeletedAt)
+		}
+	})
+}
 
-	ur := NewUserRepository(db)
-	user, err := ur.GetByID("1")
-	if err != nil {
-		t.Fatalf("unable to execute GetByID in TestGetByID due to: %v", err)
-	}
+func TestGetByIDNotFound(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("select (.+) from users").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "gender", "version", "deleted_at"}))
+
+		user, err := ur.GetByID(context.Background(), "99")
 
-	assert.NotEmpty(t, user)
-	assert.Equal(t, expectedUser.ID, user.ID)
-	assert.Equal(t, expectedUser.Name, user.Name)
-	assert.Equal(t, expectedUser.Age, user.Age)
-	assert.Equal(t, expectedUser.Gender, user.Gender)
+		assert.Nil(t, user)
+		assert.IsType(t, models.UserNotFoundError{}, err)
+		assert.True(t, errors.Is(err, models.ErrNotFound))
+	})
 }
 
-func TestGetByIDRowScanError(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("unable to create mock DB object: %v", err)
-	}
-	defer db.Close()
+func TestGetByIDMalformedID(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		user, err := ur.GetByID(context.Background(), "abc")
 
-	expectedUser := models.User{
-		ID:     "1",
-		Name:   "James Bond",
-		Age:    43,
-		Gender: "male",
-	}
+		assert.Nil(t, user)
+		assert.True(t, errors.Is(err, models.ErrNotFound))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
 
-	// Adding a value of type string to the rows for age, this should
-	// trigger a row scan error as go attempts to set a string value into
-	// an int field.
-	rows := sqlmock.NewRows([]string{"id", "name", "age", "gender"}).
-		AddRow(1, expectedUser.Name, "expectedUser.Age", expectedUser.Gender)
-	mock.ExpectQuery("select (.+) from users").
-		WillReturnRows(rows)
+func TestGetByIDQueryError(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("select (.+) from users").
+			WillReturnError(errors.New("blamo"))
 
-	ur := NewUserRepository(db)
-	user, err := ur.GetByID("1")
+		user, err := ur.GetByID(context.Background(), "1")
 
-	assert.Nil(t, user)
-	assert.NotNil(t, err)
+		assert.Nil(t, user)
+		assert.True(t, errors.Is(err, models.ErrUnavailable))
+		assert.Equal(t, "unable to locate user due to: blamo", err.Error())
+	})
 }
 
-func TestCreate(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("unable to create mock DB object: %v", err)
-	}
-	defer db.Close()
+func TestGetByIDRowScanError(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		expectedUser := models.User{
+			ID:     "1",
+			Name:   "James Bond",
+			Age:    43,
+			Gender: "male",
+		}
 
-	expectedUser := models.User{
-		Name:   "James Bond",
-		Age:    43,
-		Gender: "male",
+		// Adding a value of type string to the rows for age, this should
+		// trigger a row scan error as go attempts to set a string value into
+		// an int field.
+		rows := sqlmock.NewRows([]string{"id", "name", "age", "gender", "version", "deleted_at"}).
+			AddRow(1, expectedUser.Name, "expectedUser.Age", expectedUser.Gender, 1, nil)
+		mock.ExpectQuery("select (.+) from users").
+			WillReturnRows(rows)
+
+		user, err := ur.GetByID(context.Background(), "1")
+
+		assert.Nil(t, user)
+		assert.NotNil(t, err)
+	})
+}
+
+// userColumnNames lists the columns of userColumns, for mocked rows
+var userColumnNames = []string{"id", "name", "age", "gender", "version", "deleted_at"}
+
+// expectStoredUser expects the locked read of a user made by every write
+// other than Create, answering it with values or, when there are none, with
+// no rows
+func expectStoredUser(mock sqlmock.Sqlmock, d dialect, id string, values ...driver.Value) {
+	rows := sqlmock.NewRows(userColumnNames)
+	if len(values) > 0 {
+		rows.AddRow(values...)
 	}
+	mock.ExpectQuery(query(d, "select id, name, age, gender, version, deleted_at from users where id = ? for update")).
+		WithArgs(id).
+		WillReturnRows(rows)
+}
 
-	mock.ExpectExec("insert into users").
+// expectAudit expects the audit entry recorded for an operation on a user.
+// values match the actor, request ID, snapshots and time that follow, in
+// order; those not given match anything.
+func expectAudit(mock sqlmock.Sqlmock, d dialect, userID, operation string, values ...driver.Value) *sqlmock.ExpectedExec {
+	args := append([]driver.Value{userID, operation}, values...)
+	for len(args) < 7 {
+		args = append(args, sqlmock.AnyArg())
+	}
+	return mock.ExpectExec(query(d, "insert into user_audit (user_id, operation, actor, request_id, before_snapshot, after_snapshot, created_at) values (?, ?, ?, ?, ?, ?, ?)")).
+		WithArgs(args...).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+}
 
-	ur := NewUserRepository(db)
-	id, err := ur.Create(expectedUser)
-	if err != nil {
-		t.Fatalf("unable to execute GetByID in TestGetByID due to: %v", err)
-	}
+func TestCreate(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		expectedUser := models.User{
+			Name:   "James Bond",
+			Age:    43,
+			Gender: "male",
+		}
+
+		mock.ExpectBegin()
+		expectInsert(mock, d, 1, nil)
+		expectAudit(mock, d, "1", models.AuditCreate, "M", "req-1", nil, `{"name":"James Bond","gender":"male","age":43,"id":"1","version":1}`)
+		mock.ExpectCommit()
 
-	assert.Equal(t, "1", id)
+		ctx := WithRequestID(WithActor(context.Background(), "M"), "req-1")
+		id, err := ur.Create(ctx, expectedUser)
+		if err != nil {
+			t.Fatalf("unable to execute Create in TestCreate due to: %v", err)
+		}
+
+		assert.Equal(t, "1", id)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
 }
 
 func TestCreateExecError(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("unable to create mock DB object: %v", err)
-	}
-	defer db.Close()
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		expectedUser := models.User{
+			Name:   "James Bond",
+			Age:    43,
+			Gender: "male",
+		}
 
-	expectedUser := models.User{
-		Name:   "James Bond",
-		Age:    43,
-		Gender: "male",
-	}
+		mock.ExpectBegin()
+		expectInsert(mock, d, 0, errors.New("blamo"))
+		mock.ExpectRollback()
 
-	mock.ExpectExec("insert into users").
-		WillReturnError(errors.New("blamo"))
+		id, err := ur.Create(context.Background(), expectedUser)
 
-	ur := NewUserRepository(db)
-	id, err := ur.Create(expectedUser)
+		assert.Empty(t, id)
+		assert.NotNil(t, err)
+		assert.Equal(t, "unable to create user due to: blamo", err.Error())
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
 
-	assert.Empty(t, id)
-	assert.NotNil(t, err)
-	assert.Equal(t, "unable to create user due to: blamo", err.Error())
+func TestCreateAuditError(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		expectInsert(mock, d, 1, nil)
+		expectAudit(mock, d, "1", models.AuditCreate).
+			WillReturnError(errors.New("blamo"))
+		mock.ExpectRollback()
+
+		id, err := ur.Create(context.Background(), models.User{Name: "James Bond", Age: 43, Gender: "male"})
+
+		assert.Empty(t, id)
+		assert.True(t, errors.Is(err, models.ErrUnavailable))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCreateDuplicateEntry(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		expectInsert(mock, d, 0, driverErrors[d.name].duplicate)
+		mock.ExpectRollback()
+
+		id, err := ur.Create(context.Background(), models.User{Name: "James Bond", Age: 43, Gender: "male"})
+
+		assert.Empty(t, id)
+		assert.True(t, errors.Is(err, models.ErrConflict))
+	})
+}
+
+func TestCreateDataTooLong(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		expectInsert(mock, d, 0, driverErrors[d.name].tooLong)
+		mock.ExpectRollback()
+
+		id, err := ur.Create(context.Background(), models.User{Name: "James Bond", Age: 43, Gender: "male"})
+
+		assert.Empty(t, id)
+		assert.True(t, errors.Is(err, models.ErrValidation))
+	})
 }
 
+// TestCreateRowsAffectedError covers dialects that read the generated id
+// through LastInsertId
 func TestCreateRowsAffectedError(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -212,85 +396,686 @@ func TestCreateRowsAffectedError(t *testing.T) {
 		Gender: "male",
 	}
 
+	mock.ExpectBegin()
 	mock.ExpectExec("insert into users").
 		WillReturnResult(sqlmock.NewErrorResult(errors.New("blamo")))
+	mock.ExpectRollback()
 
 	ur := NewUserRepository(db)
-	id, err := ur.Create(expectedUser)
+	id, err := ur.Create(context.Background(), expectedUser)
 
 	assert.Empty(t, id)
 	assert.NotNil(t, err)
 	assert.Equal(t, "unable to create user due to: blamo", err.Error())
 }
 
-func TestDelete(t *testing.T) {
+func TestCreateMany(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		users := []models.User{
+			{Name: "James Bond", Age: 43, Gender: "male"},
+			{Name: "Felix Leiter", Age: 40, Gender: "male"},
+		}
+
+		mock.ExpectBegin()
+		insert := query(d, "insert into users (name, age, gender) values (?, ?, ?), (?, ?, ?)")
+		if d.returning {
+			mock.ExpectQuery(insert+" returning id").
+				WithArgs("James Bond", 43, "male", "Felix Leiter", 40, "male").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7).AddRow(8))
+		} else {
+			mock.ExpectExec(insert).
+				WithArgs("James Bond", 43, "male", "Felix Leiter", 40, "male").
+				WillReturnResult(sqlmock.NewResult(7, 2))
+		}
+		if d.idStep != "" {
+			mock.ExpectQuery(regexp.QuoteMeta(d.idStep)).
+				WillReturnRows(sqlmock.NewRows([]string{"step"}).AddRow(1))
+		}
+		mock.ExpectExec(query(d, "insert into user_audit (user_id, operation, actor, request_id, before_snapshot, after_snapshot, created_at) values (?, ?, ?, ?, ?, ?, ?), (?, ?, ?, ?, ?, ?, ?)")).
+			WillReturnResult(sqlmock.NewResult(2, 2))
+		mock.ExpectCommit()
+
+		ids, err := ur.CreateMany(context.Background(), users)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"7", "8"}, ids)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+// TestCreateManyLastInsertIsLast covers dialects whose LastInsertId
+// identifies the last row of a multi-row insert
+func TestCreateManyLastInsertIsLast(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("unable to create mock DB object: %v", err)
 	}
 	defer db.Close()
 
-	expectedUser := models.User{
-		ID:     "1",
-		Name:   "James Bond",
-		Age:    43,
-		Gender: "male",
-	}
+	mock.ExpectBegin()
+	mock.ExpectExec("insert into users").
+		WillReturnResult(sqlmock.NewResult(9, 3))
+	mock.ExpectExec("insert into user_audit").
+		WillReturnResult(sqlmock.NewResult(3, 3))
+	mock.ExpectCommit()
 
-	mock.ExpectExec("delete from users").
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	ur := NewSQLiteUserRepository(db)
+	ids, err := ur.CreateMany(context.Background(), make([]models.User, 3))
 
-	ur := NewUserRepository(db)
-	err = ur.Delete(expectedUser)
-	if err != nil {
-		t.Fatalf("unable to execute GetByID in TestGetByID due to: %v", err)
-	}
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"7", "8", "9"}, ids)
 }
 
-func TestDeleteExecError(t *testing.T) {
+// TestCreateManyIDStep covers MySQL servers spacing generated IDs by an
+// auto_increment_increment above 1
+func TestCreateManyIDStep(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("unable to create mock DB object: %v", err)
 	}
 	defer db.Close()
 
-	expectedUser := models.User{
-		ID:     "1",
-		Name:   "James Bond",
-		Age:    43,
-		Gender: "male",
-	}
-
-	mock.ExpectExec("delete from users").
-		WillReturnError(errors.New("blamo"))
+	mock.ExpectBegin()
+	mock.ExpectExec("insert into users").
+		WillReturnResult(sqlmock.NewResult(11, 3))
+	mock.ExpectQuery("select @@auto_increment_increment").
+		WillReturnRows(sqlmock.NewRows([]string{"@@auto_increment_increment"}).AddRow(10))
+	mock.ExpectExec("insert into user_audit").
+		WillReturnResult(sqlmock.NewResult(3, 3))
+	mock.ExpectCommit()
 
 	ur := NewUserRepository(db)
-	err = ur.Delete(expectedUser)
+	ids, err := ur.CreateMany(context.Background(), make([]models.User, 3))
 
-	assert.NotNil(t, err)
-	assert.Equal(t, "unable to delete user due to: blamo", err.Error())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"11", "21", "31"}, ids)
+	assert.Nil(t, mock.ExpectationsWereMet())
 }
 
-func TestDeleteRowsAffectedError(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("unable to create mock DB object: %v", err)
+func TestCreateManyError(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		if d.returning {
+			mock.ExpectQuery("insert into users").WillReturnError(driverErrors[d.name].duplicate)
+		} else {
+			mock.ExpectExec("insert into users").WillReturnError(driverErrors[d.name].duplicate)
+		}
+		mock.ExpectRollback()
+
+		ids, err := ur.CreateMany(context.Background(), make([]models.User, 2))
+
+		assert.Nil(t, ids)
+		assert.True(t, errors.Is(err, models.ErrConflict))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDelete(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		expectedUser := models.User{
+			ID:     "1",
+			Name:   "James Bond",
+			Age:    43,
+			Gender: "male",
+		}
+
+		mock.ExpectBegin()
+		expectStoredUser(mock, d, "1", 1, "James Bond", 43, "male", 1, nil)
+		mock.ExpectExec(query(d, "update users set deleted_at = ?, version = version + 1 where id = ?")).
+			WithArgs(sqlmock.AnyArg(), expectedUser.ID).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		expectAudit(mock, d, "1", models.AuditDelete)
+		mock.ExpectCommit()
+
+		err := ur.Delete(context.Background(), expectedUser)
+		if err != nil {
+			t.Fatalf("unable to execute Delete in TestDelete due to: %v", err)
+		}
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDeleteVersioned(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		expectStoredUser(mock, d, "1", 1, "James Bond", 43, "male", 3, nil)
+		mock.ExpectExec("update users set deleted_at").
+			WithArgs(sqlmock.AnyArg(), "1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAudit(mock, d, "1", models.AuditDelete)
+		mock.ExpectCommit()
+
+		err := ur.Delete(context.Background(), models.User{ID: "1", Version: 3})
+
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDeleteNotFound(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		expectStoredUser(mock, d, "99")
+		mock.ExpectRollback()
+
+		err := ur.Delete(context.Background(), models.User{ID: "99"})
+
+		assert.IsType(t, models.UserNotFoundError{}, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDeleteAlreadyDeleted(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		expectStoredUser(mock, d, "1", 1, "James Bond", 43, "male", 2, "2020-01-02 03:04:05")
+		mock.ExpectRollback()
+
+		err := ur.Delete(context.Background(), models.User{ID: "1"})
+
+		assert.IsType(t, models.UserNotFoundError{}, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDeleteVersionMismatch(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		expectStoredUser(mock, d, "1", 1, "James Bond", 43, "male", 3, nil)
+		mock.ExpectRollback()
+
+		err := ur.Delete(context.Background(), models.User{ID: "1", Version: 2})
+
+		assert.True(t, errors.Is(err, models.ErrVersionMismatch))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDeleteExecError(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		expectedUser := models.User{
+			ID:     "1",
+			Name:   "James Bond",
+			Age:    43,
+			Gender: "male",
+		}
+
+		mock.ExpectBegin()
+		expectStoredUser(mock, d, "1", 1, "James Bond", 43, "male", 1, nil)
+		mock.ExpectExec("update users set deleted_at").
+			WillReturnError(errors.New("blamo"))
+		mock.ExpectRollback()
+
+		err := ur.Delete(context.Background(), expectedUser)
+
+		assert.NotNil(t, err)
+		assert.Equal(t, "unable to delete user due to: blamo", err.Error())
+	})
+}
+
+func TestDeleteLockError(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("select (.+) from users where id = (.+) for update").
+			WillReturnError(errors.New("blamo"))
+		mock.ExpectRollback()
+
+		err := ur.Delete(context.Background(), models.User{ID: "1"})
+
+		assert.NotNil(t, err)
+		assert.Equal(t, "unable to delete user due to: blamo", err.Error())
+	})
+}
+
+func TestRestore(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		expectStoredUser(mock, d, "1", 1, "James Bond", 43, "male", 2, "2020-01-02 03:04:05")
+		mock.ExpectExec(query(d, "update users set deleted_at = null, version = version + 1 where id = ?")).
+			WithArgs("1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAudit(mock, d, "1", models.AuditRestore)
+		mock.ExpectCommit()
+
+		err := ur.Restore(context.Background(), models.User{ID: "1"})
+
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRestoreFailures(t *testing.T) {
+	tests := map[string]struct {
+		deletedAt []driver.Value
+		expected  error
+	}{
+		"not found":        {nil, models.ErrNotFound},
+		"not deleted":      {[]driver.Value{nil}, models.ErrConflict},
+		"version mismatch": {[]driver.Value{"2020-01-02 03:04:05"}, models.ErrVersionMismatch},
 	}
-	defer db.Close()
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+				var values []driver.Value
+				if tt.deletedAt != nil {
+					values = append([]driver.Value{1, "James Bond", 43, "male", 3}, tt.deletedAt...)
+				}
+				mock.ExpectBegin()
+				expectStoredUser(mock, d, "1", values...)
+				mock.ExpectRollback()
 
-	expectedUser := models.User{
-		ID:     "1",
-		Name:   "James Bond",
-		Age:    43,
-		Gender: "male",
+				err := ur.Restore(context.Background(), models.User{ID: "1", Version: 2})
+
+				assert.True(t, errors.Is(err, tt.expected), "expected %v, got %v", tt.expected, err)
+				assert.Nil(t, mock.ExpectationsWereMet())
+			})
+		})
 	}
+}
 
-	mock.ExpectExec("delete from users").
-		WillReturnResult(sqlmock.NewErrorResult(errors.New("blamo")))
+func TestPurge(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		before := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		mock.ExpectBegin()
+		mock.ExpectQuery(query(d, "select id, name, age, gender, version, deleted_at from users where deleted_at < ? order by id for update")).
+			WithArgs(before).
+			WillReturnRows(sqlmock.NewRows(userColumnNames).
+				AddRow(1, "James Bond", 43, "male", 2, "2019-01-02 03:04:05").
+				AddRow(3, "Q", 30, "male", 2, "2019-01-02 03:04:05"))
+		for _, id := range []string{"1", "3"} {
+			mock.ExpectExec(query(d, "delete from users where id = ?")).
+				WithArgs(id).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			expectAudit(mock, d, id, models.AuditPurge)
+		}
+		mock.ExpectCommit()
 
-	ur := NewUserRepository(db)
-	err = ur.Delete(expectedUser)
+		purged, err := ur.Purge(context.Background(), before)
 
-	assert.NotNil(t, err)
-	assert.Equal(t, "unable to delete user due to: blamo", err.Error())
+		assert.Nil(t, err)
+		assert.Equal(t, 2, purged)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPurgeExecError(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("select (.+) from users where deleted_at").
+			WillReturnRows(sqlmock.NewRows(userColumnNames).AddRow(1, "James Bond", 43, "male", 2, "2019-01-02 03:04:05"))
+		mock.ExpectExec("delete from users").
+			WillReturnError(errors.New("blamo"))
+		mock.ExpectRollback()
+
+		purged, err := ur.Purge(context.Background(), time.Now())
+
+		assert.Zero(t, purged)
+		assert.True(t, errors.Is(err, models.ErrUnavailable))
+		assert.Equal(t, "unable to purge users due to: blamo", err.Error())
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		expectedUser := models.User{
+			ID:     "1",
+			Name:   "James Bond",
+			Age:    44,
+			Gender: "male",
+		}
+
+		mock.ExpectBegin()
+		expectStoredUser(mock, d, "1", 1, "James Bond", 43, "male", 1, nil)
+		mock.ExpectExec(query(d, "update users set name = ?, age = ?, gender = ?, version = version + 1 where id = ?")).
+			WithArgs(expectedUser.Name, expectedUser.Age, expectedUser.Gender, expectedUser.ID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAudit(mock, d, "1", models.AuditUpdate, "", "",
+			`{"name":"James Bond","gender":"male","age":43,"id":"1","version":1}`,
+			`{"name":"James Bond","gender":"male","age":44,"id":"1","version":2}`)
+		mock.ExpectCommit()
+
+		err := ur.Update(context.Background(), expectedUser)
+		if err != nil {
+			t.Fatalf("unable to execute Update in TestUpdate due to: %v", err)
+		}
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestUpdateVersioned(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		expectedUser := models.User{
+			ID:      "1",
+			Name:    "James Bond",
+			Age:     44,
+			Gender:  "male",
+			Version: 3,
+		}
+
+		mock.ExpectBegin()
+		expectStoredUser(mock, d, "1", 1, "James Bond", 43, "male", 3, nil)
+		mock.ExpectExec("update users set name").
+			WithArgs(expectedUser.Name, expectedUser.Age, expectedUser.Gender, expectedUser.ID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAudit(mock, d, "1", models.AuditUpdate)
+		mock.ExpectCommit()
+
+		err := ur.Update(context.Background(), expectedUser)
+
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestUpdateVersionMismatch(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		expectedUser := models.User{
+			ID:      "1",
+			Name:    "James Bond",
+			Age:     43,
+			Gender:  "male",
+			Version: 2,
+		}
+
+		mock.ExpectBegin()
+		expectStoredUser(mock, d, "1", 1, "James Bond", 43, "male", 3, nil)
+		mock.ExpectRollback()
+
+		err := ur.Update(context.Background(), expectedUser)
+
+		assert.IsType(t, models.VersionMismatchError{}, err)
+		assert.True(t, errors.Is(err, models.ErrVersionMismatch))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestUpdateNotFound(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		expectedUser := models.User{
+			ID:     "99",
+			Name:   "James Bond",
+			Age:    43,
+			Gender: "male",
+		}
+
+		mock.ExpectBegin()
+		expectStoredUser(mock, d, "99")
+		mock.ExpectRollback()
+
+		err := ur.Update(context.Background(), expectedUser)
+
+		assert.IsType(t, models.UserNotFoundError{}, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestUpdateExecError(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		expectedUser := models.User{
+			ID:     "1",
+			Name:   "James Bond",
+			Age:    43,
+			Gender: "male",
+		}
+
+		mock.ExpectBegin()
+		expectStoredUser(mock, d, "1", 1, "James Bond", 43, "male", 1, nil)
+		mock.ExpectExec("update users").
+			WillReturnError(errors.New("blamo"))
+		mock.ExpectRollback()
+
+		err := ur.Update(context.Background(), expectedUser)
+
+		assert.NotNil(t, err)
+		assert.Equal(t, "unable to update user due to: blamo", err.Error())
+	})
+}
+
+func TestUpdateAuditError(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		expectStoredUser(mock, d, "1", 1, "James Bond", 43, "male", 1, nil)
+		mock.ExpectExec("update users").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAudit(mock, d, "1", models.AuditUpdate).
+			WillReturnError(errors.New("blamo"))
+		mock.ExpectRollback()
+
+		err := ur.Update(context.Background(), models.User{ID: "1", Name: "James Bond", Age: 44, Gender: "male"})
+
+		assert.NotNil(t, err)
+		assert.Equal(t, "unable to update user due to: blamo", err.Error())
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestSnapshotRecordsVersion(t *testing.T) {
+	user := models.User{ID: "1", Name: "James Bond", Gender: "male", Age: 43, Version: 3}
+
+	encoded, err := snapshot(&user)
+
+	assert.Nil(t, err)
+	assert.Equal(t, `{"name":"James Bond","gender":"male","age":43,"id":"1","version":3}`, encoded)
+	decoded, err := unsnapshot([]byte(encoded.(string)))
+	assert.Nil(t, err)
+	assert.Equal(t, user, *decoded)
+}
+
+func TestHistory(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		mock.ExpectQuery(query(d, "select count(*) from user_audit where user_id = ?")).
+			WithArgs("1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		mock.ExpectQuery(query(d, "select id, user_id, operation, actor, request_id, before_snapshot, after_snapshot, created_at from user_audit where user_id = ? order by id limit ? offset ?")).
+			WithArgs("1", 2, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "operation", "actor", "request_id", "before_snapshot", "after_snapshot", "created_at"}).
+				AddRow(2, 1, "update", "M", "req-2", []byte(`{"name":"James Bond","gender":"male","age":43,"id":"1"}`), []byte(`{"name":"James Bond","gender":"male","age":44,"id":"1","version":2}`), at).
+				AddRow(3, 1, "delete", "anonymous", "", []byte(`{"name":"James Bond","gender":"male","age":44,"id":"1"}`), nil, at))
+
+		page, err := ur.History(context.Background(), "1", HistoryOptions{Limit: 2, Offset: 1})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 3, page.Total)
+		assert.Equal(t, []models.AuditEntry{
+			{
+				ID: "2", UserID: "1", Operation: "update", Actor: "M", RequestID: "req-2",
+				Before: &models.User{ID: "1", Name: "James Bond", Gender: "male", Age: 43},
+				After:  &models.User{ID: "1", Name: "James Bond", Gender: "male", Age: 44, Version: 2},
+				At:     at,
+			},
+			{
+				ID: "3", UserID: "1", Operation: "delete", Actor: "anonymous",
+				Before: &models.User{ID: "1", Name: "James Bond", Gender: "male", Age: 44},
+				At:     at,
+			},
+		}, page.Entries)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestHistoryMalformedID(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		page, err := ur.History(context.Background(), "abc", HistoryOptions{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0, page.Total)
+		assert.Empty(t, page.Entries)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestHistoryQueryError(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("select count(.+) from user_audit").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery("select (.+) from user_audit").
+			WillReturnError(errors.New("blamo"))
+
+		page, err := ur.History(context.Background(), "1", HistoryOptions{})
+
+		assert.Nil(t, page)
+		assert.True(t, errors.Is(err, models.ErrUnavailable))
+		assert.Equal(t, "unable to locate audit entries due to: blamo", err.Error())
+	})
+}
+
+func TestList(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("select count\\(\\*\\) from users").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		rows := sqlmock.NewRows([]string{"id", "name", "age", "gender", "version", "deleted_at"}).
+			AddRow(2, "Vesper Lynd", 32, "female", 1, nil).
+			AddRow(1, "James Bond", 43, "male", 1, nil).
+			AddRow(3, "Felix Leiter", 43, "male", 1, nil)
+		mock.ExpectQuery(query(d, "select id, name, age, gender, version, deleted_at from users where deleted_at is null order by age, id limit ? offset ?")).
+			WithArgs(3, 0).
+			WillReturnRows(rows)
+
+		page, err := ur.List(context.Background(), ListOptions{Limit: 2, Sort: []SortField{{Field: "age"}}})
+		if err != nil {
+			t.Fatalf("unable to execute List in TestList due to: %v", err)
+		}
+
+		assert.Equal(t, 3, page.Total)
+		assert.Equal(t, []string{"2", "1"}, userIDs(page.Users))
+		assert.NotEmpty(t, page.NextCursor)
+
+		mock.ExpectQuery("select count\\(\\*\\) from users").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		mock.ExpectQuery(query(d, "select id, name, age, gender, version, deleted_at from users where deleted_at is null and ((age > ?) or (age = ? and id > ?)) order by age, id limit ? offset ?")).
+			WithArgs(int64(43), int64(43), int64(1), 3, 0).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "gender", "version", "deleted_at"}).AddRow(3, "Felix Leiter", 43, "male", 1, nil))
+
+		page, err = ur.List(context.Background(), ListOptions{Limit: 2, Cursor: page.NextCursor, Sort: []SortField{{Field: "age"}}})
+		if err != nil {
+			t.Fatalf("unable to execute List in TestList due to: %v", err)
+		}
+
+		assert.Equal(t, []string{"3"}, userIDs(page.Users))
+		assert.Empty(t, page.NextCursor)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestListCountError(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("select count\\(\\*\\) from users").
+			WillReturnError(errors.New("blamo"))
+
+		page, err := ur.List(context.Background(), ListOptions{})
+
+		assert.Nil(t, page)
+		assert.NotNil(t, err)
+		assert.Equal(t, "unable to count users due to: blamo", err.Error())
+	})
+}
+
+func TestListQueryError(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("select count\\(\\*\\) from users").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery("select (.+) from users").
+			WillReturnError(errors.New("blamo"))
+
+		page, err := ur.List(context.Background(), ListOptions{})
+
+		assert.Nil(t, page)
+		assert.NotNil(t, err)
+		assert.Equal(t, "unable to locate users due to: blamo", err.Error())
+	})
+}
+
+func TestListInvalidCursor(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		page, err := ur.List(context.Background(), ListOptions{Cursor: "garbage"})
+
+		assert.Nil(t, page)
+		assert.Equal(t, ErrInvalidCursor, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestListFiltered(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(query(d, "select count(*) from users where deleted_at is null and age >= ? and gender = ?")).
+			WithArgs(30, "female").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(query(d, "select id, name, age, gender, version, deleted_at from users where deleted_at is null and age >= ? and gender = ? order by id limit ? offset ?")).
+			WithArgs(30, "female", 101, 0).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "gender", "version", "deleted_at"}).AddRow(2, "Vesper Lynd", 32, "female", 1, nil))
+
+		filter := Filter{Conditions: []Condition{
+			{Field: "age", Op: OpGte, Value: 30},
+			{Field: "gender", Op: OpEq, Value: "female"},
+		}}
+		page, err := ur.List(context.Background(), ListOptions{Filter: filter})
+		if err != nil {
+			t.Fatalf("unable to execute List in TestListFiltered due to: %v", err)
+		}
+
+		assert.Equal(t, 1, page.Total)
+		assert.Equal(t, []string{"2"}, userIDs(page.Users))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestEach(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(query(d, "select id, name, age, gender, version, deleted_at from users where age >= ? order by name desc, id")).
+			WithArgs(30).
+			WillReturnRows(sqlmock.NewRows(userColumnNames).
+				AddRow(2, "Vesper Lynd", 32, "female", 1, nil).
+				AddRow(1, "James Bond", 43, "male", 2, "2018-01-02 03:04:05"))
+
+		var users []models.User
+		opts := ListOptions{
+			Limit:          1,
+			Sort:           []SortField{{Field: "name", Descending: true}},
+			Filter:         Filter{Conditions: []Condition{{Field: "age", Op: OpGte, Value: 30}}},
+			IncludeDeleted: true,
+		}
+		err := ur.Each(context.Background(), opts, func(user models.User) error {
+			users = append(users, user)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unable to execute Each in TestEach due to: %v", err)
+		}
+
+		assert.Equal(t, []string{"2", "1"}, userIDs(users))
+		assert.NotNil(t, users[1].DeletedAt)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestEachStopsOnError(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(query(d, "select id, name, age, gender, version, deleted_at from users where deleted_at is null order by id")).
+			WillReturnRows(sqlmock.NewRows(userColumnNames).
+				AddRow(1, "James Bond", 43, "male", 1, nil).
+				AddRow(2, "Vesper Lynd", 32, "female", 1, nil))
+
+		calls := 0
+		err := ur.Each(context.Background(), ListOptions{}, func(models.User) error {
+			calls++
+			return errors.New("blamo")
+		})
+
+		assert.Equal(t, "blamo", err.Error())
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestEachQueryError(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, d dialect, ur UserRepository, mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("select (.+) from users").
+			WillReturnError(errors.New("blamo"))
+
+		err := ur.Each(context.Background(), ListOptions{}, func(models.User) error { return nil })
+
+		assert.True(t, errors.Is(err, models.ErrUnavailable))
+		assert.Equal(t, "unable to locate users due to: blamo", err.Error())
+	})
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/config"
+	"github.com/ChrisTheShark/golang-mysql-api/controllers"
+	"github.com/ChrisTheShark/golang-mysql-api/logging"
+)
+
+// serve answers requests with server until SIGINT or SIGTERM, then shuts it
+// down gracefully: readiness fails at once, connections are still accepted
+// for the shutdown delay so load balancers can stop routing to the service,
+// and requests in flight are given the shutdown timeout to complete before
+// their connections are closed. A second signal skips what remains of both.
+func serve(server *http.Server, cfg config.Server, health *controllers.HealthController) error {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	errs := make(chan error, 1)
+	go func() {
+		if cfg.TLS.CertFile != "" {
+			logging.Infof("serving HTTPS on %s", server.Addr)
+			errs <- server.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			return
+		}
+		logging.Infof("serving HTTP on %s", server.Addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		logging.Infof("received %s, shutting down", sig)
+	}
+	health.Drain()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case sig := <-signals:
+			logging.Warnf("received %s, shutting down immediately", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if delay := time.Duration(cfg.ShutdownDelay); delay > 0 {
+		logging.Infof("failing readiness for %s before draining connections", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+
+	logging.Infof("draining connections for up to %s", cfg.ShutdownTimeout)
+	drainCtx, cancelDrain := context.WithTimeout(ctx, time.Duration(cfg.ShutdownTimeout))
+	defer cancelDrain()
+	if err := server.Shutdown(drainCtx); err != nil {
+		logging.Warnf("closing connections with requests still in flight: %v", err)
+		server.Close()
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
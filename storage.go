@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/config"
+	"github.com/ChrisTheShark/golang-mysql-api/migrations"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// storage is an opened storage backend. db and dialect are nil for backends
+// that are not SQL databases.
+type storage struct {
+	users   repository.UserRepository
+	db      *sql.DB
+	dialect *migrations.Dialect
+}
+
+// Close closes the database of the storage backend, waiting for the queries
+// in progress to finish
+func (s *storage) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// databaseSchemes maps the scheme of a database URL onto the storage backend
+// serving it
+var databaseSchemes = map[string]string{
+	"mysql":      "mysql",
+	"postgres":   "postgres",
+	"postgresql": "postgres",
+	"sqlite":     "sqlite",
+	"sqlite3":    "sqlite",
+	"memory":     "memory",
+}
+
+// parseDatabaseURL selects a storage backend by the scheme of a database URL
+// and returns the dsn its driver expects. Postgres URLs are understood by
+// the driver as is; for the other backends the scheme is stripped, leaving
+// e.g. "user:password@tcp(localhost:3306)/sample" for mysql://... or a file
+// path for sqlite://...
+func parseDatabaseURL(raw string) (backend, dsn string, err error) {
+	i := strings.Index(raw, "://")
+	if i < 0 {
+		return "", "", fmt.Errorf("database URL %q has no scheme", raw)
+	}
+	backend, ok := databaseSchemes[strings.ToLower(raw[:i])]
+	if !ok {
+		return "", "", fmt.Errorf("database URL %q has unsupported scheme %q", raw, raw[:i])
+	}
+	if backend == "postgres" {
+		return backend, raw, nil
+	}
+	return backend, raw[i+len("://"):], nil
+}
+
+// openStorage opens the named storage backend: "mysql", "postgres",
+// "sqlite" or "memory". The dsn is passed to the database driver and ignored by the
+// memory backend. The pool settings of cfg apply to mysql and postgres only.
+func openStorage(backend, dsn string, cfg config.Database) (*storage, error) {
+	switch backend {
+	case "", "mysql":
+		db, err := sql.Open("mysql", dsn)
+		if err != nil {
+			return nil, err
+		}
+		configurePool(db, cfg)
+		return &storage{repository.NewUserRepository(db), db, &migrations.MySQL}, nil
+	case "postgres":
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return nil, err
+		}
+		configurePool(db, cfg)
+		return &storage{repository.NewPostgresUserRepository(db), db, &migrations.Postgres}, nil
+	case "sqlite":
+		db, err := sql.Open("sqlite3", dsn)
+		if err != nil {
+			return nil, err
+		}
+		// SQLite serializes writers; a single connection avoids SQLITE_BUSY
+		// errors and keeps ":memory:" databases from being per connection.
+		// That connection must never be closed, as a ":memory:" database
+		// would be replaced by an empty one, so the pool settings of cfg
+		// are not applied.
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+		db.SetConnMaxLifetime(0)
+		db.SetConnMaxIdleTime(0)
+		// SQLite databases are local to the process, so they are migrated on
+		// startup to let the service run without any setup.
+		m, err := migrations.New(db, migrations.SQLite)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.Up(context.Background()); err != nil {
+			return nil, err
+		}
+		return &storage{repository.NewSQLiteUserRepository(db), db, &migrations.SQLite}, nil
+	case "memory":
+		return &storage{users: repository.NewMemoryUserRepository()}, nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q, expected mysql, postgres, sqlite or memory", backend)
+}
+
+// configurePool applies the connection pool settings of cfg to db
+func configurePool(db *sql.DB, cfg config.Database) {
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime))
+	db.SetConnMaxIdleTime(time.Duration(cfg.ConnMaxIdleTime))
+}
@@ -0,0 +1,143 @@
+// Package transfer moves users in and out of a UserRepository in bulk, as
+// CSV or newline delimited JSON. It backs both the import and export
+// endpoints and the import and export subcommands.
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+)
+
+// Media types of the supported formats
+const (
+	CSV    = "text/csv"
+	NDJSON = "application/x-ndjson"
+)
+
+// Columns are the CSV columns written by Export, in order
+var Columns = []string{"id", "name", "gender", "age", "deleted_at"}
+
+// flushRows is how many users Export writes between flushes, so a client
+// reading the export sees it arrive as it is produced
+const flushRows = 100
+
+// flusher is implemented by writers such as http.ResponseWriter that hold
+// output back until flushed
+type flusher interface {
+	Flush()
+}
+
+// encoder writes users in one of the supported formats
+type encoder interface {
+	encode(models.User) error
+	flush() error
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+// formulaTriggers are the leading characters that make a spreadsheet treat a
+// CSV cell as a formula
+const formulaTriggers = "=+-@\t\r"
+
+// escapeCell keeps a spreadsheet from running a cell as a formula by
+// prefixing it with a quote. Cells already starting with a quote are
+// prefixed too, so unescapeCell can tell the two apart.
+func escapeCell(s string) string {
+	if s != "" && strings.ContainsRune(formulaTriggers+"'", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// unescapeCell undoes escapeCell
+func unescapeCell(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune(formulaTriggers+"'", rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
+
+func (e csvEncoder) encode(user models.User) error {
+	deletedAt := ""
+	if user.DeletedAt != nil {
+		deletedAt = user.DeletedAt.UTC().Format(time.RFC3339)
+	}
+	return e.w.Write([]string{user.ID, escapeCell(user.Name), escapeCell(user.Gender), strconv.Itoa(user.Age), deletedAt})
+}
+
+func (e csvEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonEncoder struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (e ndjsonEncoder) encode(user models.User) error {
+	return e.enc.Encode(user)
+}
+
+func (e ndjsonEncoder) flush() error {
+	return e.w.Flush()
+}
+
+func newEncoder(w io.Writer, format string) (encoder, error) {
+	switch format {
+	case CSV:
+		e := csvEncoder{csv.NewWriter(w)}
+		return e, e.w.Write(Columns)
+	case NDJSON:
+		bw := bufio.NewWriter(w)
+		return ndjsonEncoder{bw, json.NewEncoder(bw)}, nil
+	}
+	return nil, fmt.Errorf("unsupported export format %q", format)
+}
+
+// Export writes every user matching opts to w in format, one row or line
+// per user, as they are read from the repository. Paging options are
+// ignored. It returns how many users were written.
+func Export(ctx context.Context, ur repository.UserRepository, opts repository.ListOptions, w io.Writer, format string) (int, error) {
+	enc, err := newEncoder(w, format)
+	if err != nil {
+		return 0, err
+	}
+	flush := func() error {
+		if err := enc.flush(); err != nil {
+			return err
+		}
+		if f, ok := w.(flusher); ok {
+			f.Flush()
+		}
+		return nil
+	}
+
+	n := 0
+	err = ur.Each(ctx, opts, func(user models.User) error {
+		if err := enc.encode(user); err != nil {
+			return err
+		}
+		n++
+		if n%flushRows == 0 {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, flush()
+}
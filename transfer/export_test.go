@@ -0,0 +1,119 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+	mocks "github.com/ChrisTheShark/golang-mysql-api/repository/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func seededRepository() *mocks.MockUserRepository {
+	ur := mocks.NewMockUserRepository()
+	deletedAt := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	ur.Reset()
+	ur.Seed(
+		models.User{Name: "James Bond", Gender: "male", Age: 43},
+		models.User{Name: "Vesper Lynd, \"Vesper\"", Gender: "female", Age: 32},
+		models.User{Name: "Le Chiffre", Gender: "male", Age: 45, DeletedAt: &deletedAt},
+	)
+	return ur
+}
+
+func TestExportCSV(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	n, err := Export(context.Background(), seededRepository(), repository.ListOptions{IncludeDeleted: true}, &buf, CSV)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "id,name,gender,age,deleted_at\n"+
+		"1,James Bond,male,43,\n"+
+		"2,\"Vesper Lynd, \"\"Vesper\"\"\",female,32,\n"+
+		"3,Le Chiffre,male,45,2018-01-02T03:04:05Z\n", buf.String())
+}
+
+func TestExportCSVEscapesFormulas(t *testing.T) {
+	t.Parallel()
+	ur := mocks.NewMockUserRepository()
+	ur.Reset()
+	ur.Seed(
+		models.User{Name: `=HYPERLINK("http://evil.example","x")`, Gender: "male", Age: 43},
+		models.User{Name: "@SUM(A1:A2)", Gender: "female", Age: 32},
+		models.User{Name: "'Tis Q", Gender: "male", Age: 30},
+	)
+
+	var buf bytes.Buffer
+	_, err := Export(context.Background(), ur, repository.ListOptions{}, &buf, CSV)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "id,name,gender,age,deleted_at\n"+
+		"1,\"'=HYPERLINK(\"\"http://evil.example\"\",\"\"x\"\")\",male,43,\n"+
+		"2,'@SUM(A1:A2),female,32,\n"+
+		"3,''Tis Q,male,30,\n", buf.String())
+}
+
+func TestExportNDJSON(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	n, err := Export(context.Background(), seededRepository(), repository.ListOptions{Sort: []repository.SortField{{Field: "age"}}}, &buf, NDJSON)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, `{"name":"Vesper Lynd, \"Vesper\"","gender":"female","age":32,"id":"2"}`+"\n"+
+		`{"name":"James Bond","gender":"male","age":43,"id":"1"}`+"\n", buf.String())
+}
+
+// flushRecorder counts the flushes of the writer it wraps
+type flushRecorder struct {
+	bytes.Buffer
+	flushes int
+}
+
+func (f *flushRecorder) Flush() {
+	f.flushes++
+}
+
+func TestExportFlushes(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	ur.Reset()
+	for i := 0; i < 2*flushRows+1; i++ {
+		ur.Seed(models.User{Name: "Agent", Gender: "male", Age: 30})
+	}
+
+	w := &flushRecorder{}
+	n, err := Export(context.Background(), ur, repository.ListOptions{}, w, CSV)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2*flushRows+1, n)
+	assert.Equal(t, 3, w.flushes)
+}
+
+func TestExportError(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	n, err := Export(context.Background(), mocks.NewMockErroringUserRepository(), repository.ListOptions{}, &buf, CSV)
+
+	assert.Equal(t, 0, n)
+	assert.Equal(t, "blamo", err.Error())
+	assert.Empty(t, buf.String())
+}
+
+func TestExportUnsupportedFormat(t *testing.T) {
+	t.Parallel()
+
+	_, err := Export(context.Background(), seededRepository(), repository.ListOptions{}, &bytes.Buffer{}, "application/xml")
+
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, models.ErrUnavailable))
+}
@@ -0,0 +1,200 @@
+package transfer
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+)
+
+// Fields are the user fields read by Import; every other column, such as
+// the id and deleted_at written by Export, is ignored
+var Fields = []string{"name", "gender", "age"}
+
+// ErrTooManyRows is returned by Import when the CSV holds more rows than
+// Options.MaxRows allows
+var ErrTooManyRows = errors.New("too many rows")
+
+// FormatError reports a CSV that cannot be imported at all, as opposed to a
+// row that is invalid
+type FormatError struct {
+	Message string
+}
+
+func (e FormatError) Error() string {
+	return e.Message
+}
+
+// Options control an Import
+type Options struct {
+	// Columns maps a field to the header of the column it is read from. A
+	// field missing from Columns is read from the column whose header is
+	// the field's name, ignoring case.
+	Columns map[string]string
+	// DryRun validates every row without creating any user
+	DryRun bool
+	// MaxRows bounds the number of rows read; zero leaves it unbounded
+	MaxRows int
+}
+
+// RowError describes why a row was rejected. Rows are numbered as in a
+// spreadsheet, the header being row 1. Field is empty when the row as a
+// whole is malformed.
+type RowError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// Report summarizes an Import: how many rows were read, how many users were
+// created and why each rejected row was rejected
+type Report struct {
+	Rows     int        `json:"rows"`
+	Imported int        `json:"imported"`
+	DryRun   bool       `json:"dry_run"`
+	Errors   []RowError `json:"errors"`
+}
+
+// WriteCSV writes the errors of the report as CSV, one row per error
+func (r Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"row", "field", "message"})
+	for _, e := range r.Errors {
+		cw.Write([]string{strconv.Itoa(e.Row), e.Field, e.Message})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Import reads users from a CSV whose first row is a header and creates them
+// with a single CreateMany. Either every row is imported or, when any row
+// is rejected or opts.DryRun is set, none is; the report lists the rejected
+// rows either way.
+func Import(ctx context.Context, ur repository.UserRepository, r io.Reader, opts Options) (*Report, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, FormatError{"the CSV is empty; its first row must be a header"}
+	}
+	if err != nil {
+		return nil, FormatError{fmt.Sprintf("unable to read the header due to: %v", err)}
+	}
+	columns, err := mapColumns(header, opts.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{DryRun: opts.DryRun, Errors: []RowError{}}
+	var users []models.User
+	for row := 2; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		report.Rows++
+		if opts.MaxRows > 0 && report.Rows > opts.MaxRows {
+			return nil, fmt.Errorf("the CSV must hold at most %d rows: %w", opts.MaxRows, ErrTooManyRows)
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			report.Errors = append(report.Errors, RowError{Row: row, Message: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		user, rowErrors := readUser(row, record, columns)
+		if len(rowErrors) > 0 {
+			report.Errors = append(report.Errors, rowErrors...)
+			continue
+		}
+		users = append(users, user)
+	}
+
+	if len(report.Errors) > 0 || opts.DryRun || len(users) == 0 {
+		return report, nil
+	}
+	if _, err := ur.CreateMany(ctx, users); err != nil {
+		return nil, err
+	}
+	report.Imported = len(users)
+	return report, nil
+}
+
+// mapColumns locates the column each field is read from
+func mapColumns(header []string, mapping map[string]string) (map[string]int, error) {
+	for field := range mapping {
+		if !isField(field) {
+			return nil, FormatError{fmt.Sprintf("unable to map unknown field %q, expected one of %s", field, strings.Join(Fields, ", "))}
+		}
+	}
+	if len(header) > 0 {
+		// Spreadsheets commonly prefix UTF-8 files with a byte order mark.
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	columns := map[string]int{}
+	for _, field := range Fields {
+		name, ok := mapping[field]
+		if !ok {
+			name = field
+		}
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), strings.TrimSpace(name)) {
+				columns[field] = i
+				break
+			}
+		}
+		if _, ok := columns[field]; !ok {
+			return nil, FormatError{fmt.Sprintf("the header has no column %q for field %s", name, field)}
+		}
+	}
+	return columns, nil
+}
+
+func isField(name string) bool {
+	for _, field := range Fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readUser reads the user held by a row, reporting every field that is
+// missing or invalid
+func readUser(row int, record []string, columns map[string]int) (models.User, []RowError) {
+	var user models.User
+	var rowErrors []RowError
+	user.Name = unescapeCell(strings.TrimSpace(record[columns["name"]]))
+	user.Gender = unescapeCell(strings.TrimSpace(record[columns["gender"]]))
+	age, ageErr := strconv.Atoi(strings.TrimSpace(record[columns["age"]]))
+	if ageErr != nil {
+		rowErrors = append(rowErrors, RowError{Row: row, Field: "age", Message: "must be a whole number"})
+	}
+	user.Age = age
+
+	var validation models.ValidationError
+	if err := user.Validate(); errors.As(err, &validation) {
+		fields := make([]string, 0, len(validation.Fields))
+		for field := range validation.Fields {
+			if field == "age" && ageErr != nil {
+				continue
+			}
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			rowErrors = append(rowErrors, RowError{Row: row, Field: field, Message: validation.Fields[field]})
+		}
+	}
+	return user, rowErrors
+}
@@ -0,0 +1,175 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ChrisTheShark/golang-mysql-api/models"
+	"github.com/ChrisTheShark/golang-mysql-api/repository"
+	mocks "github.com/ChrisTheShark/golang-mysql-api/repository/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImport(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	csv := "\ufeffID,Name,Gender,Age,Deleted_At\n" +
+		"7,Felix Leiter,male,40,\n" +
+		"8,\"Lynd, Vesper\",female, 30 ,\n"
+
+	report, err := Import(context.Background(), ur, strings.NewReader(csv), Options{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Report{Rows: 2, Imported: 2, Errors: []RowError{}}, report)
+	assert.Len(t, ur.CallsTo("CreateMany"), 1)
+	user, err := ur.GetByID(context.Background(), "3")
+	assert.Nil(t, err)
+	assert.Equal(t, models.User{ID: "3", Name: "Lynd, Vesper", Gender: "female", Age: 30, Version: 1}, *user)
+}
+
+func TestImportMapsColumns(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	csv := "Full Name,Years,Sex\nFelix Leiter,40,male\n"
+
+	report, err := Import(context.Background(), ur, strings.NewReader(csv), Options{
+		Columns: map[string]string{"name": "full name", "age": "Years", "gender": "Sex"},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Imported)
+	user, _ := ur.GetByID(context.Background(), "2")
+	assert.Equal(t, "Felix Leiter", user.Name)
+	assert.Equal(t, 40, user.Age)
+}
+
+func TestImportRejectsInvalidRows(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+	csv := "name,gender,age\n" +
+		"Felix Leiter,male,40\n" +
+		",robot,-1\n" +
+		"Q,male,old\n" +
+		"Moneypenny,female\n"
+
+	report, err := Import(context.Background(), ur, strings.NewReader(csv), Options{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 4, report.Rows)
+	assert.Equal(t, 0, report.Imported)
+	if assert.Len(t, report.Errors, 5) {
+		assert.Equal(t, []string{"age", "gender", "name"}, []string{report.Errors[0].Field, report.Errors[1].Field, report.Errors[2].Field})
+		assert.Equal(t, 3, report.Errors[0].Row)
+		assert.Equal(t, RowError{Row: 4, Field: "age", Message: "must be a whole number"}, report.Errors[3])
+		assert.Equal(t, 5, report.Errors[4].Row)
+		assert.Empty(t, report.Errors[4].Field)
+	}
+	assert.Empty(t, ur.CallsTo("CreateMany"))
+}
+
+func TestImportDryRun(t *testing.T) {
+	t.Parallel()
+
+	ur := mocks.NewMockUserRepository()
+
+	report, err := Import(context.Background(), ur, strings.NewReader("name,gender,age\nFelix Leiter,male,40\n"), Options{DryRun: true})
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Report{Rows: 1, DryRun: true, Errors: []RowError{}}, report)
+	assert.Empty(t, ur.CallsTo("CreateMany"))
+}
+
+func TestImportFormatErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		csv     string
+		columns map[string]string
+	}{
+		"empty":          {"", nil},
+		"missing column": {"name,gender\nQ,male\n", nil},
+		"unknown field":  {"name,gender,age\n", map[string]string{"email": "Email"}},
+		"unmapped":       {"name,gender,age\n", map[string]string{"age": "Years"}},
+	}
+	for name, tt := range tests {
+		_, err := Import(context.Background(), mocks.NewMockUserRepository(), strings.NewReader(tt.csv), Options{Columns: tt.columns})
+
+		var formatErr FormatError
+		assert.True(t, errors.As(err, &formatErr), name)
+	}
+}
+
+func TestImportTooManyRows(t *testing.T) {
+	t.Parallel()
+
+	csv := "name,gender,age\n" + strings.Repeat("Q,male,30\n", 3)
+
+	_, err := Import(context.Background(), mocks.NewMockUserRepository(), strings.NewReader(csv), Options{MaxRows: 2})
+
+	assert.True(t, errors.Is(err, ErrTooManyRows))
+}
+
+func TestImportRepositoryError(t *testing.T) {
+	t.Parallel()
+
+	report, err := Import(context.Background(), mocks.NewMockErroringUserRepository(), strings.NewReader("name,gender,age\nQ,male,30\n"), Options{})
+
+	assert.Nil(t, report)
+	assert.Equal(t, "blamo", err.Error())
+}
+
+func TestImportRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	_, err := Export(context.Background(), seededRepository(), repository.ListOptions{}, &buf, CSV)
+	assert.Nil(t, err)
+	ur := mocks.NewMockUserRepository()
+	ur.Reset()
+
+	report, err := Import(context.Background(), ur, &buf, Options{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, report.Imported)
+	users, _ := ur.GetAll(context.Background())
+	assert.Equal(t, []string{"James Bond", "Vesper Lynd, \"Vesper\""}, []string{users[0].Name, users[1].Name})
+}
+
+func TestImportUnescapesFormulas(t *testing.T) {
+	t.Parallel()
+	ur := mocks.NewMockUserRepository()
+	ur.Reset()
+	names := []string{"=1+1", "+44 Q", "-Q", "@Q", "'Tis Q", "'Q", "Q'"}
+
+	var csv strings.Builder
+	csv.WriteString("name,gender,age\n")
+	for _, name := range names {
+		csv.WriteString(escapeCell(name) + ",male,30\n")
+	}
+	report, err := Import(context.Background(), ur, strings.NewReader(csv.String()), Options{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, len(names), report.Imported)
+	users, _ := ur.GetAll(context.Background())
+	imported := make([]string, len(users))
+	for i, user := range users {
+		imported[i] = user.Name
+	}
+	assert.Equal(t, names, imported)
+}
+
+func TestReportWriteCSV(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	err := Report{Errors: []RowError{{Row: 3, Field: "age", Message: "must be a whole number"}, {Row: 5, Message: "wrong number of fields"}}}.WriteCSV(&buf)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "row,field,message\n3,age,must be a whole number\n5,,wrong number of fields\n", buf.String())
+}